@@ -0,0 +1,53 @@
+package archive
+
+import (
+	"sort"
+	"wisp/internal/textdiff"
+)
+
+// Файловый статус в диффе конфигураций.
+const (
+	FileAdded    = "added"
+	FileRemoved  = "removed"
+	FileModified = "modified"
+)
+
+// FileDiff — изменения одного файла; Diff — unified diff (a/<path> → b/<path>).
+type FileDiff struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Diff   string `json:"diff"`
+}
+
+// DiffFiles — пофайловое сравнение двух наборов; неизменённые файлы не попадают
+// в результат, но считаются во втором значении.
+func DiffFiles(a, b map[string]string) ([]FileDiff, int) {
+	paths := make([]string, 0, len(a)+len(b))
+	for p := range a {
+		paths = append(paths, p)
+	}
+	for p := range b {
+		if _, ok := a[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var out []FileDiff
+	same := 0
+	for _, p := range paths {
+		av, inA := a[p]
+		bv, inB := b[p]
+		switch {
+		case !inA:
+			out = append(out, FileDiff{Path: p, Status: FileAdded, Diff: textdiff.Unified("/dev/null", "b/"+p, "", bv)})
+		case !inB:
+			out = append(out, FileDiff{Path: p, Status: FileRemoved, Diff: textdiff.Unified("a/"+p, "/dev/null", av, "")})
+		case av != bv:
+			out = append(out, FileDiff{Path: p, Status: FileModified, Diff: textdiff.Unified("a/"+p, "b/"+p, av, bv)})
+		default:
+			same++
+		}
+	}
+	return out, same
+}
@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+	"wisp/internal/owctrl"
+
+	"github.com/gorilla/mux"
+)
+
+type HTTP struct {
+	store   *Store
+	devices DeviceLookup
+	builder owctrl.ConfigBuilder
+}
+
+func NewHTTP(s *Store, devices DeviceLookup, builder owctrl.ConfigBuilder) *HTTP {
+	return &HTTP{store: s, devices: devices, builder: builder}
+}
+
+func (h *HTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// GET /api/v1/devices/{uuid}/configs — отданные устройству архивы, новые первыми
+	api.HandleFunc("/devices/{uuid}/configs", h.list).Methods(http.MethodGet)
+	// GET /api/v1/devices/{uuid}/configs/{sha}[?format=json] — tar.gz или карта файлов;
+	//   значения секретов устройства вырезаны (********), ?secrets=1 — архив как есть
+	api.HandleFunc("/devices/{uuid}/configs/{sha}", h.get).Methods(http.MethodGet)
+}
+
+func (h *HTTP) list(w http.ResponseWriter, r *http.Request) {
+	items, err := h.store.List(mux.Vars(r)["uuid"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (h *HTTP) get(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	sha := strings.ToLower(v["sha"])
+	tgz, err := h.store.Get(v["uuid"], sha)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "archive not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Openwisp-Archive-Sha256", sha)
+	asJSON := r.URL.Query().Get("format") == "json"
+	if !asJSON && owctrl.WantsSecrets(r) {
+		w.Header().Set("ETag", `"`+sha+`"`)
+		writeTarGz(w, sha, tgz)
+		return
+	}
+
+	files, err := Files(tgz)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !owctrl.WantsSecrets(r) {
+		values, err := secretValues(h.devices, h.builder, []string{v["uuid"]})
+		if err == nil {
+			var recorded []string
+			recorded, err = h.store.SecretsOf(sha)
+			values = append(values, recorded...)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		files = varschema.Redact(files, values)
+		w.Header().Set("X-Secrets-Masked", "true")
+	}
+	if asJSON {
+		paths := make([]string, 0, len(files))
+		for p := range files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		models.WriteJSON(w, http.StatusOK, map[string]any{"sha256": sha, "paths": paths, "files": files})
+		return
+	}
+	// перепакованный архив с вырезанными секретами: sha в заголовке — исходного
+	modes, err := Modes(tgz)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	masked, _, err := owctrl.TarGz(files, modes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeTarGz(w, sha, masked)
+}
+
+func writeTarGz(w http.ResponseWriter, sha string, tgz []byte) {
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename=configuration-"+sha[:12]+".tar.gz")
+	_, _ = w.Write(tgz)
+}
+
+// secretValues — текущие значения секретов устройств ids для varschema.Redact
+// (если билдер реализует owctrl.SecretSource). Для архивов к ним добавляются
+// записанные при отдаче (Store.SecretsOf) — секрет мог смениться после.
+func secretValues(devices DeviceLookup, b owctrl.ConfigBuilder, ids []string) ([]string, error) {
+	src, ok := b.(owctrl.SecretSource)
+	if !ok {
+		return nil, nil
+	}
+	var out []string
+	for _, id := range ids {
+		dev, ok := devices.FindByUUID(id)
+		if !ok {
+			continue
+		}
+		vs, err := src.SecretValues(dev)
+		if err != nil {
+			return nil, fmt.Errorf("secrets of %s: %w", id, err)
+		}
+		out = append(out, vs...)
+	}
+	return out, nil
+}
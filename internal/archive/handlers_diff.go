@@ -0,0 +1,238 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+	"wisp/internal/owctrl"
+
+	"github.com/gorilla/mux"
+)
+
+// DeviceLookup — поиск устройства (реализуется repo.DeviceStore).
+type DeviceLookup interface {
+	FindByUUID(id string) (owctrl.DeviceFields, bool)
+}
+
+// DiffHTTP — сравнение применённой, текущей и архивных конфигураций.
+type DiffHTTP struct {
+	store   *Store
+	devices DeviceLookup
+	builder owctrl.ConfigBuilder
+}
+
+func NewDiffHTTP(s *Store, devices DeviceLookup, builder owctrl.ConfigBuilder) *DiffHTTP {
+	return &DiffHTTP{store: s, devices: devices, builder: builder}
+}
+
+func (h *DiffHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// GET /api/v1/devices/{uuid}/config-diff?from=applied&to=current
+	//   from/to: applied (config_sha из отчёта агента) | current (что отдадим сейчас) | <sha из архива>
+	api.HandleFunc("/devices/{uuid}/config-diff", h.deviceDiff).Methods(http.MethodGet)
+	// GET /api/v1/config-diff?from=<ref>&to=<ref>
+	//   ref: <uuid>[@applied|@current|@<sha>] | sha:<sha>
+	// Значения секретов устройств вырезаны (********) из обеих сторон, ?secrets=1 — без маскирования.
+	api.HandleFunc("/config-diff", h.diff).Methods(http.MethodGet)
+}
+
+// side — одна сторона сравнения.
+type side struct {
+	Ref     string `json:"ref"`
+	SHA256  string `json:"sha256"`
+	files   map[string]string
+	devices []string // чьи секреты вырезать из files
+	secrets []string // записанные с архивом (Store.SecretsOf)
+}
+
+type diffOut struct {
+	From      side       `json:"from"`
+	To        side       `json:"to"`
+	Changed   bool       `json:"changed"`
+	Unchanged int        `json:"unchanged"`
+	Files     []FileDiff `json:"files"`
+}
+
+// refError — ошибка разбора/поиска ссылки с HTTP-кодом.
+type refError struct {
+	code int
+	msg  string
+}
+
+func (e *refError) Error() string { return e.msg }
+
+func (h *DiffHTTP) deviceDiff(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["uuid"]
+	q := r.URL.Query()
+	from, to := q.Get("from"), q.Get("to")
+	if from == "" {
+		from = "applied"
+	}
+	if to == "" {
+		to = "current"
+	}
+	a, err := h.resolveDevice(id, from)
+	if err != nil {
+		writeRefErr(w, err)
+		return
+	}
+	b, err := h.resolveDevice(id, to)
+	if err != nil {
+		writeRefErr(w, err)
+		return
+	}
+	h.write(w, r, a, b)
+}
+
+func (h *DiffHTTP) diff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("from") == "" || q.Get("to") == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	a, err := h.resolve(q.Get("from"))
+	if err != nil {
+		writeRefErr(w, err)
+		return
+	}
+	b, err := h.resolve(q.Get("to"))
+	if err != nil {
+		writeRefErr(w, err)
+		return
+	}
+	h.write(w, r, a, b)
+}
+
+// resolve — ref вида sha:<sha> или <uuid>[@what].
+func (h *DiffHTTP) resolve(ref string) (side, error) {
+	ref = strings.TrimSpace(ref)
+	if sha, ok := strings.CutPrefix(ref, "sha:"); ok {
+		sha = strings.ToLower(sha)
+		tgz, err := h.store.GetBySHA(sha)
+		if err != nil {
+			return side{}, archiveErr(sha, err)
+		}
+		s, err := h.unpack(ref, sha, tgz)
+		if err != nil {
+			return side{}, err
+		}
+		s.devices, err = h.store.DevicesOf(sha)
+		return s, err
+	}
+	id, what, _ := strings.Cut(ref, "@")
+	s, err := h.resolveDevice(id, what)
+	if err != nil {
+		return side{}, err
+	}
+	s.Ref = ref
+	return s, nil
+}
+
+// resolveDevice — what: current (по умолчанию) | applied | <sha>.
+func (h *DiffHTTP) resolveDevice(id, what string) (side, error) {
+	s, err := h.resolveDeviceFiles(id, what)
+	s.devices = []string{id}
+	return s, err
+}
+
+func (h *DiffHTTP) resolveDeviceFiles(id, what string) (side, error) {
+	dev, ok := h.devices.FindByUUID(id)
+	if !ok {
+		return side{}, &refError{http.StatusNotFound, "device not found: " + id}
+	}
+	ref := id + "@" + what
+	switch strings.ToLower(what) {
+	case "", "current":
+		files, modes, err := h.builder.BuildConfig(dev)
+		if err != nil {
+			return side{}, &refError{http.StatusUnprocessableEntity, fmt.Sprintf("build %s: %v", id, err)}
+		}
+		_, sha, err := owctrl.TarGz(files, modes)
+		if err != nil {
+			return side{}, err
+		}
+		return side{Ref: id + "@current", SHA256: sha, files: files}, nil
+	case "applied":
+		if dev.LastSHA == "" {
+			return side{}, &refError{http.StatusConflict, "device " + id + " has not reported a config_sha yet"}
+		}
+		tgz, err := h.store.GetBySHA(dev.LastSHA)
+		if err != nil {
+			return side{}, archiveErr(dev.LastSHA, err)
+		}
+		return h.unpack(ref, dev.LastSHA, tgz)
+	default:
+		sha := strings.ToLower(what)
+		tgz, err := h.store.Get(id, sha)
+		if err != nil {
+			return side{}, archiveErr(sha, err)
+		}
+		return h.unpack(ref, sha, tgz)
+	}
+}
+
+func (h *DiffHTTP) unpack(ref, sha string, tgz []byte) (side, error) {
+	files, err := Files(tgz)
+	if err != nil {
+		return side{}, err
+	}
+	secrets, err := h.store.SecretsOf(sha)
+	if err != nil {
+		return side{}, err
+	}
+	return side{Ref: ref, SHA256: sha, files: files, secrets: secrets}, nil
+}
+
+func archiveErr(sha string, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return &refError{http.StatusNotFound, "archive not found: " + sha}
+	}
+	return err
+}
+
+func writeRefErr(w http.ResponseWriter, err error) {
+	var re *refError
+	if errors.As(err, &re) {
+		http.Error(w, re.msg, re.code)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// write — JSON по умолчанию, ?format=text — склеенный unified diff (как `diff -ruN`).
+func (h *DiffHTTP) write(w http.ResponseWriter, r *http.Request, a, b side) {
+	// значения секретов вырезаны с обеих сторон до сравнения, если не ?secrets=1
+	if !owctrl.WantsSecrets(r) {
+		values, err := secretValues(h.devices, h.builder, append(append([]string{}, a.devices...), b.devices...))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		values = append(append(values, a.secrets...), b.secrets...)
+		a.files = varschema.Redact(a.files, values)
+		b.files = varschema.Redact(b.files, values)
+		w.Header().Set("X-Secrets-Masked", "true")
+	}
+	files, same := DiffFiles(a.files, b.files)
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		for _, f := range files {
+			_, _ = io.WriteString(w, f.Diff)
+		}
+		return
+	}
+	if files == nil {
+		files = []FileDiff{}
+	}
+	models.WriteJSON(w, http.StatusOK, diffOut{
+		From: a, To: b,
+		Changed:   a.SHA256 != b.SHA256,
+		Unchanged: same,
+		Files:     files,
+	})
+}
@@ -0,0 +1,286 @@
+// internal/archive/repo.go
+//
+// Архив отданных устройствам конфигураций: tar.gz хранится в БД по sha256
+// (дедупликация), привязки device → sha подрезаются политикой хранения.
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+	"wisp/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNotFound = errors.New("archive not found")
+
+// Retention — политика хранения; нулевые значения — без ограничения.
+type Retention struct {
+	KeepPerDevice int           // сколько последних архивов держать на устройство
+	MaxAge        time.Duration // старше (по последней отдаче) — удаляются
+}
+
+type Store struct {
+	db  *gorm.DB
+	ret Retention
+}
+
+func NewStore(db *gorm.DB, ret Retention) *Store { return &Store{db: db, ret: ret} }
+
+// Entry — архив в истории устройства.
+type Entry struct {
+	SHA256        string    `json:"sha256"`
+	Size          int64     `json:"size"`
+	FirstServedAt time.Time `json:"first_served_at"`
+	LastServedAt  time.Time `json:"last_served_at"`
+	ServeCount    int       `json:"serve_count"`
+	Current       bool      `json:"current"` // совпадает с last_config_sha устройства
+}
+
+// SaveArchive — сохраняет отданный архив и отмечает факт отдачи устройству;
+// secrets — значения секретов устройства, вошедшие в сборку (см. SecretsOf).
+func (s *Store) SaveArchive(uuid, sha string, tgz []byte, secrets []string) error {
+	now := time.Now()
+	sv, err := encodeSecrets(secrets)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		blob := models.ConfigArchive{SHA256: sha, Size: int64(len(tgz)), Data: tgz, CreatedAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&blob).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&models.DeviceConfigArchive{}).
+			Where("device_uuid = ? AND sha256 = ?", uuid, sha).
+			Updates(map[string]any{
+				"last_served_at": now,
+				"serve_count":    gorm.Expr("serve_count + 1"),
+				"secret_values":  sv,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			link := models.DeviceConfigArchive{
+				DeviceUUID: uuid, SHA256: sha,
+				FirstServedAt: now, LastServedAt: now, ServeCount: 1,
+				SecretValues: sv,
+			}
+			if err := tx.Create(&link).Error; err != nil {
+				return err
+			}
+		}
+		return s.prune(tx, uuid, now)
+	})
+}
+
+// prune — применяет Retention к устройству и удаляет ставшие ничьими архивы.
+// Применённый устройством архив (last_config_sha) не удаляется — он нужен для диффа.
+func (s *Store) prune(tx *gorm.DB, uuid string, now time.Time) error {
+	var applied string
+	if err := tx.Model(&models.Device{}).Where("uuid = ?", uuid).
+		Limit(1).Pluck("last_config_sha", &applied).Error; err != nil {
+		return err
+	}
+	var stale []models.DeviceConfigArchive
+	if s.ret.MaxAge > 0 {
+		if err := tx.Select("id", "sha256").
+			Where("device_uuid = ? AND sha256 <> ? AND last_served_at < ?", uuid, applied, now.Add(-s.ret.MaxAge)).
+			Find(&stale).Error; err != nil {
+			return err
+		}
+	}
+	if s.ret.KeepPerDevice > 0 {
+		var old []models.DeviceConfigArchive
+		if err := tx.Select("id", "sha256").
+			Where("device_uuid = ? AND sha256 <> ?", uuid, applied).
+			Order("last_served_at DESC").Order("id DESC").
+			Offset(s.ret.KeepPerDevice).
+			Limit(1 << 30). // Offset без Limit не везде допустим
+			Find(&old).Error; err != nil {
+			return err
+		}
+		stale = append(stale, old...)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(stale))
+	shas := make([]string, 0, len(stale))
+	for _, l := range stale {
+		ids = append(ids, l.ID)
+		shas = append(shas, l.SHA256)
+	}
+	if err := tx.Delete(&models.DeviceConfigArchive{}, ids).Error; err != nil {
+		return err
+	}
+	return DeleteOrphans(tx, shas)
+}
+
+// DeleteOrphans — удаляет из shas архивы, на которые больше не ссылается ни одно
+// устройство (проверяются только переданные, а не вся таблица).
+func DeleteOrphans(tx *gorm.DB, shas []string) error {
+	if len(shas) == 0 {
+		return nil
+	}
+	return tx.Where("sha256 IN ?", shas).
+		Where("NOT EXISTS (?)", tx.Model(&models.DeviceConfigArchive{}).Select("1").
+			Where("device_config_archives.sha256 = config_archives.sha256")).
+		Delete(&models.ConfigArchive{}).Error
+}
+
+// List — архивы устройства, новые первыми.
+func (s *Store) List(uuid string) ([]Entry, error) {
+	var cur string
+	if err := s.db.Model(&models.Device{}).Where("uuid = ?", uuid).
+		Limit(1).Pluck("last_config_sha", &cur).Error; err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		models.DeviceConfigArchive
+		Size int64
+	}
+	err := s.db.Table("device_config_archives AS d").
+		Select("d.*, a.size").
+		Joins("JOIN config_archives a ON a.sha256 = d.sha256").
+		Where("d.device_uuid = ?", uuid).
+		Order("d.last_served_at DESC").Order("d.id DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Entry, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, Entry{
+			SHA256:        r.SHA256,
+			Size:          r.Size,
+			FirstServedAt: r.FirstServedAt,
+			LastServedAt:  r.LastServedAt,
+			ServeCount:    r.ServeCount,
+			Current:       cur != "" && cur == r.SHA256,
+		})
+	}
+	return out, nil
+}
+
+// Get — tar.gz, отданный устройству (только если он есть в его истории).
+func (s *Store) Get(uuid, sha string) ([]byte, error) {
+	var n int64
+	if err := s.db.Model(&models.DeviceConfigArchive{}).
+		Where("device_uuid = ? AND sha256 = ?", uuid, sha).Count(&n).Error; err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrNotFound
+	}
+	return s.GetBySHA(sha)
+}
+
+// GetBySHA — архив по sha без привязки к устройству.
+func (s *Store) GetBySHA(sha string) ([]byte, error) {
+	var a models.ConfigArchive
+	if err := s.db.Where("sha256 = ?", sha).First(&a).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return a.Data, nil
+}
+
+// DevicesOf — устройства, в истории которых есть архив sha.
+func (s *Store) DevicesOf(sha string) ([]string, error) {
+	var ids []string
+	err := s.db.Model(&models.DeviceConfigArchive{}).Where("sha256 = ?", sha).
+		Distinct().Order("device_uuid").Pluck("device_uuid", &ids).Error
+	return ids, err
+}
+
+// SecretsOf — значения секретов, записанные при отдаче архива sha (всем устройствам).
+// У архивов, сохранённых до появления записи, — пусто.
+func (s *Store) SecretsOf(sha string) ([]string, error) {
+	var rows []string
+	if err := s.db.Model(&models.DeviceConfigArchive{}).
+		Where("sha256 = ? AND secret_values IS NOT NULL AND secret_values <> ''", sha).
+		Pluck("secret_values", &rows).Error; err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, r := range rows {
+		var vs []string
+		if err := json.Unmarshal([]byte(r), &vs); err != nil {
+			return nil, fmt.Errorf("archive %s: secret values: %w", sha, err)
+		}
+		out = append(out, vs...)
+	}
+	return out, nil
+}
+
+func encodeSecrets(values []string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(values)
+	return string(b), err
+}
+
+// Files — содержимое tar.gz в виде path -> content.
+func Files(tgz []byte) (map[string]string, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = string(b)
+	}
+	return files, nil
+}
+
+// Modes — права файлов tar.gz, отличные от 0644 (как возвращает сборка, см. owctrl.TarGz).
+func Modes(tgz []byte) (map[string]fs.FileMode, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	modes := map[string]fs.FileMode{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar: %w", err)
+		}
+		if m := hdr.FileInfo().Mode(); m.IsRegular() && m != 0o644 {
+			modes[hdr.Name] = m
+		}
+	}
+	return modes, nil
+}
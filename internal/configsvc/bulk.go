@@ -0,0 +1,450 @@
+package configsvc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ── Bulk operations ─────────────────────────────────────────
+// Одна операция над множеством устройств: выбор (селектор) → изменения
+// переменных, шаблонов и групп в одной транзакции. dry_run проходит тот же путь
+// и откатывает транзакцию, поэтому отчёт «что изменится» совпадает с реальным.
+
+var (
+	ErrBulkSelector = errors.New("bulk: selector is empty (uuids, group_id, filter or all)")
+	ErrBulkInvalid  = errors.New("bulk: invalid request")
+	errBulkDryRun   = errors.New("bulk: dry run")
+)
+
+// BulkSelector — какие устройства затронуть; заданные условия пересекаются.
+type BulkSelector struct {
+	UUIDs   []string     `json:"uuids,omitempty"`
+	GroupID uint         `json:"group_id,omitempty"` // прямые члены группы и её подгрупп
+	Filter  []BulkFilter `json:"filter,omitempty"`   // все должны совпасть
+	All     bool         `json:"all,omitempty"`      // явное «все устройства»
+}
+
+// BulkFilter — условие в синтаксисе правил динамических групп (см. normalizeRule).
+type BulkFilter struct {
+	Field   string `json:"field"`
+	FactKey string `json:"fact_key,omitempty"`
+	Op      string `json:"op,omitempty"`
+	Value   string `json:"value"`
+}
+
+func (f BulkFilter) rule() models.GroupRule {
+	return models.GroupRule{Field: f.Field, FactKey: f.FactKey, Op: f.Op, Value: f.Value}
+}
+
+// BulkRequest — тело POST /bulk.
+type BulkRequest struct {
+	Select            BulkSelector      `json:"select"`
+	SetVars           map[string]string `json:"set_vars,omitempty"`
+	DeleteVars        []string          `json:"delete_vars,omitempty"`
+	AssignTemplates   []uint            `json:"assign_templates,omitempty"`
+	UnassignTemplates []uint            `json:"unassign_templates,omitempty"`
+	BlockTemplates    []uint            `json:"block_templates,omitempty"`
+	UnblockTemplates  []uint            `json:"unblock_templates,omitempty"`
+	AddGroups         []uint            `json:"add_groups,omitempty"`
+	RemoveGroups      []uint            `json:"remove_groups,omitempty"`
+	DryRun            bool              `json:"dry_run"`
+}
+
+// Операции BulkChange.
+const (
+	BulkSetVar           = "set_var"
+	BulkDeleteVar        = "delete_var"
+	BulkAssignTemplate   = "assign_template"
+	BulkUnassignTemplate = "unassign_template"
+	BulkBlockTemplate    = "block_template"
+	BulkUnblockTemplate  = "unblock_template"
+	BulkAddGroup         = "add_group"
+	BulkRemoveGroup      = "remove_group"
+)
+
+// BulkChange — одно изменение одного устройства; секреты замаскированы.
+type BulkChange struct {
+	Op         string `json:"op"`
+	Key        string `json:"key,omitempty"`
+	TemplateID uint   `json:"template_id,omitempty"`
+	GroupID    uint   `json:"group_id,omitempty"`
+	Old        string `json:"old,omitempty"`
+	New        string `json:"new,omitempty"`
+	Skipped    string `json:"skipped,omitempty"` // причина, по которой изменение не сделано
+}
+
+type BulkDevice struct {
+	UUID    string       `json:"uuid"`
+	Name    string       `json:"name"`
+	Changes []BulkChange `json:"changes"`
+}
+
+// BulkResult — отчёт; при dry_run ничего не записано.
+type BulkResult struct {
+	DryRun  bool         `json:"dry_run"`
+	Matched int          `json:"matched"`
+	Changed int          `json:"changed"` // устройств с изменениями
+	Total   int          `json:"total"`   // изменений всего (без skipped)
+	Devices []BulkDevice `json:"devices"`
+}
+
+// BulkFieldError — ошибка проверки запроса до транзакции.
+type BulkFieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+type BulkValidationError struct{ Errors []BulkFieldError }
+
+func (e *BulkValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+": "+fe.Error)
+	}
+	return ErrBulkInvalid.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *BulkValidationError) Unwrap() error { return ErrBulkInvalid }
+
+// Bulk выполняет BulkRequest.
+type Bulk struct {
+	repo  *Repo
+	facts FactsProvider // для filter по fact; опционально
+}
+
+func NewBulk(repo *Repo, facts FactsProvider) *Bulk {
+	return &Bulk{repo: repo, facts: facts}
+}
+
+// Apply проверяет запрос, выбирает устройства и применяет изменения одной транзакцией.
+func (b *Bulk) Apply(req BulkRequest) (*BulkResult, error) {
+	vars, err := b.validate(&req)
+	if err != nil {
+		return nil, err
+	}
+	devs, err := b.Select(req.Select)
+	if err != nil {
+		return nil, err
+	}
+	res := &BulkResult{DryRun: req.DryRun, Matched: len(devs), Devices: make([]BulkDevice, 0, len(devs))}
+	err = b.repo.db.Transaction(func(tx *gorm.DB) error {
+		t := &Repo{db: tx, keys: b.repo.keys}
+		for _, d := range devs {
+			ch, err := t.bulkDevice(d.UUID, &req, vars)
+			if err != nil {
+				return fmt.Errorf("device %s: %w", d.UUID, err)
+			}
+			bd := BulkDevice{UUID: d.UUID, Name: d.Name, Changes: ch}
+			n := 0
+			for _, c := range ch {
+				if c.Skipped == "" {
+					n++
+				}
+			}
+			if n > 0 {
+				res.Changed++
+				res.Total += n
+			}
+			res.Devices = append(res.Devices, bd)
+		}
+		if req.DryRun {
+			return errBulkDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBulkDryRun) {
+		return nil, err
+	}
+	return res, nil
+}
+
+// validate — всё, что можно проверить без устройств; возвращает нормализованные set_vars.
+func (b *Bulk) validate(req *BulkRequest) (map[string]string, error) {
+	var errs []BulkFieldError
+	add := func(field, msg string) { errs = append(errs, BulkFieldError{Field: field, Error: msg}) }
+
+	vars := map[string]string{}
+	for k, v := range req.SetVars {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			add("set_vars", "empty key")
+			continue
+		}
+		if varschema.Unchanged(k, v) {
+			continue
+		}
+		nv, err := varschema.ValidateOne(k, v)
+		if err != nil {
+			add("set_vars."+k, err.Error())
+			continue
+		}
+		vars[k] = nv
+	}
+	for i, k := range req.DeleteVars {
+		req.DeleteVars[i] = strings.TrimSpace(k)
+		if _, both := vars[req.DeleteVars[i]]; both {
+			add("delete_vars."+k, "key is also in set_vars")
+		}
+	}
+
+	tplIDs := map[uint]bool{}
+	for _, l := range [][]uint{req.AssignTemplates, req.UnassignTemplates, req.BlockTemplates, req.UnblockTemplates} {
+		for _, id := range l {
+			tplIDs[id] = true
+		}
+	}
+	if len(tplIDs) > 0 {
+		ids := make([]uint, 0, len(tplIDs))
+		for id := range tplIDs {
+			ids = append(ids, id)
+		}
+		found, err := b.repo.GetTemplatesByIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			if _, ok := found[id]; !ok {
+				add("templates", fmt.Sprintf("template %d not found", id))
+			}
+		}
+	}
+
+	idx, err := b.repo.groupIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range [][]uint{req.AddGroups, req.RemoveGroups} {
+		for _, id := range l {
+			if _, ok := idx[id]; !ok {
+				add("groups", fmt.Sprintf("group %d not found", id))
+			}
+		}
+	}
+	if req.Select.GroupID != 0 {
+		if _, ok := idx[req.Select.GroupID]; !ok {
+			add("select.group_id", fmt.Sprintf("group %d not found", req.Select.GroupID))
+		}
+	}
+	for i, f := range req.Select.Filter {
+		gr := f.rule()
+		if err := normalizeRule(&gr); err != nil {
+			add(fmt.Sprintf("select.filter[%d]", i), err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &BulkValidationError{Errors: errs}
+	}
+	return vars, nil
+}
+
+// Select — устройства под селектор, по id.
+func (b *Bulk) Select(sel BulkSelector) ([]models.Device, error) {
+	if len(sel.UUIDs) == 0 && sel.GroupID == 0 && len(sel.Filter) == 0 && !sel.All {
+		return nil, ErrBulkSelector
+	}
+	q := b.repo.db.Model(&models.Device{}).Order("id")
+	if len(sel.UUIDs) > 0 {
+		q = q.Where("uuid IN ?", sel.UUIDs)
+	}
+	if sel.GroupID != 0 {
+		ids, err := b.repo.subtreeIDs(sel.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where("uuid IN (?)", b.repo.db.Model(&models.DeviceGroup{}).
+			Select("device_uuid").Where("group_id IN ?", ids))
+	}
+	var devs []models.Device
+	if err := q.Find(&devs).Error; err != nil {
+		return nil, err
+	}
+	if len(sel.Filter) == 0 {
+		return devs, nil
+	}
+
+	match := make([]ruleMatcher, 0, len(sel.Filter))
+	for _, f := range sel.Filter {
+		fn, err := compileRule(f.rule())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBulkInvalid, err)
+		}
+		match = append(match, fn)
+	}
+	out := devs[:0]
+	for _, d := range devs {
+		subj := RuleSubject{Name: d.Name, Backend: d.Backend, MAC: d.MAC, Status: d.Status}
+		if b.facts != nil {
+			if f, err := b.facts.GetDeviceFacts(d.UUID); err == nil {
+				subj.Facts = f
+			}
+		}
+		ok := true
+		for _, fn := range match {
+			if ok = fn(subj); !ok {
+				break
+			}
+		}
+		if ok {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// subtreeIDs — группа и все её потомки.
+func (r *Repo) subtreeIDs(id uint) ([]uint, error) {
+	idx, err := r.groupIndex()
+	if err != nil {
+		return nil, err
+	}
+	out := []uint{}
+	for gid := range idx {
+		for _, g := range lineage(idx, gid) {
+			if g.ID == id {
+				out = append(out, gid)
+				break
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, nil
+}
+
+// bulkDevice — изменения одного устройства; r работает внутри транзакции.
+func (r *Repo) bulkDevice(uuid string, req *BulkRequest, vars map[string]string) ([]BulkChange, error) {
+	out := []BulkChange{}
+
+	// vars
+	cur, err := r.GetDeviceVars(uuid)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		old, had := cur[k]
+		if had && old == vars[k] {
+			continue
+		}
+		if err := r.UpsertDeviceVar(uuid, k, vars[k]); err != nil {
+			return nil, err
+		}
+		out = append(out, BulkChange{Op: BulkSetVar, Key: k, Old: varschema.Mask(k, old), New: varschema.Mask(k, vars[k])})
+	}
+	for _, k := range req.DeleteVars {
+		old, had := cur[k]
+		if !had {
+			continue
+		}
+		if err := r.DeleteDeviceVar(uuid, k); err != nil {
+			return nil, err
+		}
+		out = append(out, BulkChange{Op: BulkDeleteVar, Key: k, Old: varschema.Mask(k, old)})
+	}
+
+	// templates
+	var as []models.DeviceTemplateAssignment
+	if err := r.db.Where("device_uuid = ?", uuid).Find(&as).Error; err != nil {
+		return nil, err
+	}
+	assigned := map[uint]bool{} // id → enabled
+	for _, a := range as {
+		assigned[a.TemplateID] = a.Enabled
+	}
+	for _, id := range req.AssignTemplates {
+		if en, ok := assigned[id]; ok && en {
+			continue
+		}
+		if err := r.AssignTemplate(uuid, id, true); err != nil {
+			return nil, err
+		}
+		assigned[id] = true
+		out = append(out, BulkChange{Op: BulkAssignTemplate, TemplateID: id})
+	}
+	for _, id := range req.UnassignTemplates {
+		if _, ok := assigned[id]; !ok {
+			continue
+		}
+		if err := r.UnassignTemplate(uuid, id); err != nil {
+			return nil, err
+		}
+		delete(assigned, id)
+		out = append(out, BulkChange{Op: BulkUnassignTemplate, TemplateID: id})
+	}
+	blocks, err := r.ListDeviceTemplateBlocks(uuid)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range req.BlockTemplates {
+		if _, ok := blocks[id]; ok {
+			continue
+		}
+		if err := r.BlockTemplateForDevice(uuid, id); err != nil {
+			return nil, err
+		}
+		blocks[id] = struct{}{}
+		out = append(out, BulkChange{Op: BulkBlockTemplate, TemplateID: id})
+	}
+	for _, id := range req.UnblockTemplates {
+		if _, ok := blocks[id]; !ok {
+			continue
+		}
+		if err := r.UnblockTemplateForDevice(uuid, id); err != nil {
+			return nil, err
+		}
+		delete(blocks, id)
+		out = append(out, BulkChange{Op: BulkUnblockTemplate, TemplateID: id})
+	}
+
+	// groups
+	var links []models.DeviceGroup
+	if err := r.db.Where("device_uuid = ?", uuid).Find(&links).Error; err != nil {
+		return nil, err
+	}
+	member := map[uint]models.DeviceGroup{}
+	for _, l := range links {
+		member[l.GroupID] = l
+	}
+	for _, gid := range req.AddGroups {
+		l, ok := member[gid]
+		if ok && l.RuleID == nil {
+			continue
+		}
+		if _, _, err := r.AddDeviceToGroup(uuid, gid); err != nil {
+			return nil, err
+		}
+		c := BulkChange{Op: BulkAddGroup, GroupID: gid, New: "manual"}
+		if ok {
+			c.Old = "rule"
+		}
+		member[gid] = models.DeviceGroup{DeviceUUID: uuid, GroupID: gid}
+		out = append(out, c)
+	}
+	for _, gid := range req.RemoveGroups {
+		l, ok := member[gid]
+		if !ok {
+			continue
+		}
+		if l.RuleID != nil {
+			// правило вернёт устройство при следующем пересчёте
+			out = append(out, BulkChange{Op: BulkRemoveGroup, GroupID: gid, Skipped: "membership comes from a group rule"})
+			continue
+		}
+		if err := r.RemoveDeviceFromGroup(uuid, gid); err != nil {
+			return nil, err
+		}
+		delete(member, gid)
+		out = append(out, BulkChange{Op: BulkRemoveGroup, GroupID: gid})
+	}
+	return out, nil
+}
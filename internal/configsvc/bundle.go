@@ -0,0 +1,1313 @@
+// internal/configsvc/bundle.go
+package configsvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ── Export / import bundle ──────────────────────────────────
+// Перенос конфигурации между контроллерами (staging → production): шаблоны,
+// группы с переменными, назначениями и префиксами, настройки устройств, дерево
+// IPAM-префиксов, пользовательские определения переменных и глобальные переменные
+// из БД (global_vars конфига не переносятся). Ссылки в бандле — только по естественным ключам (имя шаблона,
+// имя группы, UUID/MAC устройства, CIDR), id целевой БД не переносятся.
+// Сами устройства импорт не создаёт: они регистрируются агентом.
+
+// BundleVersion — версия формата; импорт других версий отклоняется.
+const BundleVersion = 1
+
+// Режимы импорта.
+const (
+	ImportMerge   = "merge"   // добавить и обновить, ничего не удалять
+	ImportReplace = "replace" // привести к бандлу: лишнее удаляется (устройства вне бандла не трогаются)
+)
+
+var (
+	ErrBundleVersion = fmt.Errorf("bundle: unsupported version (want %d)", BundleVersion)
+	ErrBundleInvalid = errors.New("bundle: invalid")
+	errBundleDryRun  = errors.New("bundle: dry run")
+)
+
+type Bundle struct {
+	Version    int              `json:"version" yaml:"version"`
+	ExportedAt string           `json:"exported_at,omitempty" yaml:"exported_at,omitempty"` // RFC 3339, справочно
+	Templates  []BundleTemplate `json:"templates" yaml:"templates"`
+	Groups     []BundleGroup    `json:"groups" yaml:"groups"`
+	Devices    []BundleDevice   `json:"devices" yaml:"devices"`
+	Prefixes   []BundlePrefix   `json:"prefixes" yaml:"prefixes"`
+	// nil (нет в бандле, например бандл старой сборки) — при импорте не трогаются
+	VarDefs []BundleVarDef    `json:"var_definitions" yaml:"var_definitions"`
+	Globals map[string]string `json:"globals" yaml:"globals"`
+
+	varDef func(key string) (varschema.VarDef, bool) // определения с учётом бандла, см. check
+}
+
+// BundleVarDef — пользовательское определение переменной (см. varschema.Spec).
+type BundleVarDef struct {
+	Key         string   `json:"key" yaml:"key"`
+	Type        string   `json:"type,omitempty" yaml:"type,omitempty"`
+	Pattern     string   `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Min         *int     `json:"min,omitempty" yaml:"min,omitempty"`
+	Max         *int     `json:"max,omitempty" yaml:"max,omitempty"`
+	Enum        []string `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Required    bool     `json:"required,omitempty" yaml:"required,omitempty"`
+	Secret      bool     `json:"secret,omitempty" yaml:"secret,omitempty"`
+	RequiredIf  string   `json:"required_if,omitempty" yaml:"required_if,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Example     string   `json:"example,omitempty" yaml:"example,omitempty"`
+}
+
+func (d BundleVarDef) spec() varschema.Spec {
+	s := varschema.Spec{Key: d.Key, Type: varschema.VarType(d.Type), Pattern: d.Pattern, Min: d.Min, Max: d.Max,
+		Enum: d.Enum, Required: d.Required, Secret: d.Secret, RequiredIf: d.RequiredIf,
+		Description: d.Description, Example: d.Example}
+	if s.Type == "" {
+		s.Type = varschema.TString
+	}
+	if len(s.Enum) == 0 {
+		s.Enum = nil
+	}
+	return s
+}
+
+type BundleTemplate struct {
+	Name     string `json:"name" yaml:"name"`
+	Path     string `json:"path,omitempty" yaml:"path,omitempty"`
+	Type     string `json:"type,omitempty" yaml:"type,omitempty"`
+	Required bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Default  bool   `json:"default,omitempty" yaml:"default,omitempty"`
+	Body     string `json:"body" yaml:"body"`
+}
+
+// BundleAssignment — назначение шаблона группе или устройству.
+type BundleAssignment struct {
+	Template string `json:"template" yaml:"template"`
+	Order    int    `json:"order" yaml:"order"`
+	Enabled  bool   `json:"enabled" yaml:"enabled"`
+}
+
+type BundleGroup struct {
+	Name      string             `json:"name" yaml:"name"`
+	Parent    string             `json:"parent,omitempty" yaml:"parent,omitempty"`
+	Priority  int                `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Vars      map[string]string  `json:"vars,omitempty" yaml:"vars,omitempty"`
+	Templates []BundleAssignment `json:"templates,omitempty" yaml:"templates,omitempty"`
+	Prefixes  []string           `json:"prefixes,omitempty" yaml:"prefixes,omitempty"` // CIDR
+	Rules     []BundleRule       `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// BundleRule — правило динамического членства (см. models.GroupRule). Вычисленное
+// членство не переносится: после импорта пересчитывается на целевом контроллере.
+type BundleRule struct {
+	Field   string `json:"field" yaml:"field"`
+	FactKey string `json:"fact_key,omitempty" yaml:"fact_key,omitempty"`
+	Op      string `json:"op,omitempty" yaml:"op,omitempty"`
+	Value   string `json:"value" yaml:"value"`
+}
+
+func (br BundleRule) model(groupID uint) models.GroupRule {
+	return models.GroupRule{GroupID: groupID, Field: br.Field, FactKey: br.FactKey, Op: br.Op, Value: br.Value}
+}
+
+// BundleMembership — ручное членство (вычисленное правилами не переносится).
+type BundleMembership struct {
+	Group   string `json:"group" yaml:"group"`
+	Primary bool   `json:"primary,omitempty" yaml:"primary,omitempty"`
+}
+
+// BundleDevice — настройки устройства; сопоставляется по UUID, затем по MAC.
+type BundleDevice struct {
+	UUID      string             `json:"uuid" yaml:"uuid"`
+	MAC       string             `json:"mac,omitempty" yaml:"mac,omitempty"`
+	Name      string             `json:"name,omitempty" yaml:"name,omitempty"` // справочно
+	Vars      map[string]string  `json:"vars,omitempty" yaml:"vars,omitempty"`
+	Templates []BundleAssignment `json:"templates,omitempty" yaml:"templates,omitempty"`
+	Blocks    []string           `json:"blocks,omitempty" yaml:"blocks,omitempty"` // имена шаблонов
+	Groups    []BundleMembership `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+type BundlePrefix struct {
+	CIDR   string `json:"cidr" yaml:"cidr"`
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty"` // CIDR родителя
+	Note   string `json:"note,omitempty" yaml:"note,omitempty"`
+}
+
+// ── Export ──────────────────────────────────────────────────
+
+// ExportBundle — текущая конфигурация. withSecrets=false — значения секретных
+// переменных замаскированы (при импорте такие значения не меняются).
+func (r *Repo) ExportBundle(withSecrets bool) (*Bundle, error) {
+	b := &Bundle{Version: BundleVersion, ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Templates: []BundleTemplate{}, Groups: []BundleGroup{}, Devices: []BundleDevice{}, Prefixes: []BundlePrefix{},
+		VarDefs: []BundleVarDef{}}
+	mask := func(m map[string]string) map[string]string {
+		if withSecrets || len(m) == 0 {
+			return m
+		}
+		return varschema.MaskMap(m)
+	}
+
+	specs, err := r.ListVarDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range specs {
+		b.VarDefs = append(b.VarDefs, BundleVarDef{Key: s.Key, Type: string(s.Type), Pattern: s.Pattern, Min: s.Min, Max: s.Max,
+			Enum: s.Enum, Required: s.Required, Secret: s.Secret, RequiredIf: s.RequiredIf,
+			Description: s.Description, Example: s.Example})
+	}
+	globals, err := r.GetGlobalVars()
+	if err != nil {
+		return nil, err
+	}
+	b.Globals = mask(globals)
+
+	tpls, err := r.ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+	tplName := make(map[uint]string, len(tpls))
+	for _, t := range tpls {
+		tplName[t.ID] = t.Name
+		b.Templates = append(b.Templates, BundleTemplate{Name: t.Name, Path: t.Path, Type: t.Type, Required: t.Required, Default: t.Default, Body: t.Body})
+	}
+
+	// IPAM
+	var pfx []models.Prefix
+	if err := r.db.Order("id").Find(&pfx).Error; err != nil {
+		return nil, err
+	}
+	cidr := make(map[uint]string, len(pfx))
+	for _, p := range pfx {
+		cidr[p.ID] = p.CIDR
+	}
+	for _, p := range pfx {
+		bp := BundlePrefix{CIDR: p.CIDR, Note: p.Note}
+		if p.ParentID != nil {
+			bp.Parent = cidr[*p.ParentID]
+		}
+		b.Prefixes = append(b.Prefixes, bp)
+	}
+	var gps []models.GroupPrefix
+	if err := r.db.Order("id").Find(&gps).Error; err != nil {
+		return nil, err
+	}
+	groupPfx := map[uint][]string{}
+	for _, gp := range gps {
+		if c, ok := cidr[gp.PrefixID]; ok {
+			groupPfx[gp.GroupID] = append(groupPfx[gp.GroupID], c)
+		}
+	}
+
+	// groups: родители раньше детей
+	idx, err := r.groupIndex()
+	if err != nil {
+		return nil, err
+	}
+	gs := make([]models.Group, 0, len(idx))
+	for _, g := range idx {
+		gs = append(gs, g)
+	}
+	depth := func(g models.Group) int { return len(lineage(idx, g.ID)) }
+	sort.Slice(gs, func(i, j int) bool {
+		if di, dj := depth(gs[i]), depth(gs[j]); di != dj {
+			return di < dj
+		}
+		return gs[i].Name < gs[j].Name
+	})
+	ids := make([]uint, 0, len(gs))
+	for _, g := range gs {
+		ids = append(ids, g.ID)
+	}
+	gvars, err := r.GetGroupVarsByGroup(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gs {
+		bg := BundleGroup{Name: g.Name, Priority: g.Priority, Vars: mask(gvars[g.ID]), Prefixes: groupPfx[g.ID]}
+		if g.ParentID != nil {
+			bg.Parent = idx[*g.ParentID].Name
+		}
+		as, err := r.ListGroupTemplateAssignments(g.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range as {
+			if n, ok := tplName[a.TemplateID]; ok {
+				bg.Templates = append(bg.Templates, BundleAssignment{Template: n, Order: a.Order, Enabled: a.Enabled})
+			}
+		}
+		rules, err := r.ListGroupRules(g.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, gr := range rules {
+			bg.Rules = append(bg.Rules, BundleRule{Field: gr.Field, FactKey: gr.FactKey, Op: gr.Op, Value: gr.Value})
+		}
+		b.Groups = append(b.Groups, bg)
+	}
+
+	// devices — только те, у кого есть что переносить
+	var devs []models.Device
+	if err := r.db.Order("id").Find(&devs).Error; err != nil {
+		return nil, err
+	}
+	for _, d := range devs {
+		bd := BundleDevice{UUID: d.UUID, MAC: d.MAC, Name: d.Name}
+		vars, err := r.GetDeviceVars(d.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", d.UUID, err)
+		}
+		if len(vars) > 0 {
+			bd.Vars = mask(vars)
+		}
+		var as []models.DeviceTemplateAssignment
+		if err := r.db.Where("device_uuid = ?", d.UUID).Order(orderAsc).Find(&as).Error; err != nil {
+			return nil, err
+		}
+		for _, a := range as {
+			if n, ok := tplName[a.TemplateID]; ok {
+				bd.Templates = append(bd.Templates, BundleAssignment{Template: n, Order: a.Order, Enabled: a.Enabled})
+			}
+		}
+		blocks, err := r.ListDeviceTemplateBlocks(d.UUID)
+		if err != nil {
+			return nil, err
+		}
+		for id := range blocks {
+			if n, ok := tplName[id]; ok {
+				bd.Blocks = append(bd.Blocks, n)
+			}
+		}
+		sort.Strings(bd.Blocks)
+		var links []models.DeviceGroup
+		if err := r.db.Where("device_uuid = ? AND rule_id IS NULL", d.UUID).Order("group_id").Find(&links).Error; err != nil {
+			return nil, err
+		}
+		for _, l := range links {
+			if g, ok := idx[l.GroupID]; ok {
+				bd.Groups = append(bd.Groups, BundleMembership{Group: g.Name, Primary: l.IsPrimary})
+			}
+		}
+		if len(bd.Vars)+len(bd.Templates)+len(bd.Blocks)+len(bd.Groups) > 0 {
+			b.Devices = append(b.Devices, bd)
+		}
+	}
+	return b, nil
+}
+
+// ── Import ──────────────────────────────────────────────────
+
+// ImportStat — счётчики по одному виду записей.
+type ImportStat struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+type ImportResult struct {
+	Mode     string                 `json:"mode"`
+	DryRun   bool                   `json:"dry_run"`
+	Devices  int                    `json:"devices_matched"`
+	Stats    map[string]*ImportStat `json:"stats"`
+	Warnings []string               `json:"warnings"`
+}
+
+func (res *ImportResult) stat(kind string) *ImportStat {
+	s, ok := res.Stats[kind]
+	if !ok {
+		s = &ImportStat{}
+		res.Stats[kind] = s
+	}
+	return s
+}
+
+func (res *ImportResult) warnf(format string, args ...any) {
+	res.Warnings = append(res.Warnings, fmt.Sprintf(format, args...))
+}
+
+// ImportBundle применяет бандл одной транзакцией; dryRun — то же, но с откатом.
+// Реестр varschema перечитывается только после коммита; внутри транзакции секретность
+// ключей берётся из определений бандла (Bundle.check). Динамическое членство
+// пересчитывает вызывающий (Membership.EvaluateAll).
+func (r *Repo) ImportBundle(b *Bundle, mode string, dryRun bool) (*ImportResult, error) {
+	if b.Version != BundleVersion {
+		return nil, ErrBundleVersion
+	}
+	if mode == "" {
+		mode = ImportMerge
+	}
+	if mode != ImportMerge && mode != ImportReplace {
+		return nil, fmt.Errorf("%w: mode must be merge|replace", ErrBundleInvalid)
+	}
+	if err := b.check(mode == ImportReplace); err != nil {
+		return nil, err
+	}
+	res := &ImportResult{Mode: mode, DryRun: dryRun, Stats: map[string]*ImportStat{}, Warnings: []string{}}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		secret := func(k string) bool { d, ok := b.varDef(k); return ok && d.Secret }
+		im := &importer{r: &Repo{db: tx, keys: r.keys, secret: secret}, b: b, replace: mode == ImportReplace, res: res,
+			tpl: map[string]uint{}, grp: map[string]uint{}, pfx: map[string]uint{}}
+		steps := []func() error{im.varDefs, im.prefixes, im.templates, im.groups, im.groupContents, im.devices, im.globals, im.prune}
+		for _, step := range steps {
+			if err := step(); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errBundleDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBundleDryRun) {
+		return nil, err
+	}
+	if !dryRun && b.VarDefs != nil {
+		if err := r.ReloadVarSchema(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// check — уникальность естественных ключей, формат CIDR, определения переменных
+// и значения переменных (проверяются и нормализуются как в API) до транзакции.
+func (b *Bundle) check(replace bool) error {
+	var errs []string
+	dup := func(kind string) func(string) {
+		seen := map[string]bool{}
+		return func(k string) {
+			switch {
+			case strings.TrimSpace(k) == "":
+				errs = append(errs, kind+": empty key")
+			case seen[k]:
+				errs = append(errs, fmt.Sprintf("%s %q: duplicate", kind, k))
+			}
+			seen[k] = true
+		}
+	}
+	t, g, d, p := dup("template"), dup("group"), dup("device"), dup("prefix")
+	for _, x := range b.Templates {
+		t(x.Name)
+	}
+	for _, x := range b.Groups {
+		g(x.Name)
+	}
+	for _, x := range b.Devices {
+		d(x.UUID)
+	}
+	for i, x := range b.Prefixes {
+		c, err := normCIDR(x.CIDR)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("prefix %q: %v", x.CIDR, err))
+			continue
+		}
+		b.Prefixes[i].CIDR = c
+		p(c)
+	}
+
+	// определения из бандла действуют для его же переменных; в replace определения
+	// вне бандла будут удалены — для их ключей остаётся только встроенный каталог
+	defs := map[string]varschema.VarDef{}
+	vd := dup("var definition")
+	for _, x := range b.VarDefs {
+		vd(x.Key)
+		if varschema.IsBuiltin(x.Key) {
+			errs = append(errs, fmt.Sprintf("var definition %q: %v", x.Key, ErrBuiltinVar))
+			continue
+		}
+		d, err := varschema.Compile(x.spec())
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("var definition %q: %v", x.Key, err))
+			continue
+		}
+		defs[x.Key] = d
+	}
+	def := func(k string) (varschema.VarDef, bool) {
+		if d, ok := defs[k]; ok {
+			return d, true
+		}
+		if replace && b.VarDefs != nil && !varschema.IsBuiltin(k) {
+			return varschema.VarDef{}, false
+		}
+		return varschema.Def(k)
+	}
+	b.varDef = def
+	// как в API: у устройств и глобальных — только известные ключи, у групп —
+	// любые (свободные переменные шаблонов), известные проверяются
+	vars := func(where string, m map[string]string, known bool) map[string]string {
+		if m == nil {
+			return nil
+		}
+		out := make(map[string]string, len(m))
+		for k, v := range m {
+			k = strings.TrimSpace(k)
+			d, ok := def(k)
+			switch {
+			case k == "":
+				errs = append(errs, where+": empty var key")
+				continue
+			case !ok && known:
+				errs = append(errs, fmt.Sprintf("%s: unknown variable: %s", where, k))
+				continue
+			}
+			if _, dup := out[k]; dup {
+				errs = append(errs, fmt.Sprintf("%s: var %s: duplicate", where, k))
+				continue
+			}
+			if !ok || d.Secret && v == varschema.Masked { // замаскированный секрет — «не менять», см. syncVars
+				out[k] = v
+				continue
+			}
+			nv, err := d.Validate(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: var %s: %v", where, k, err))
+				continue
+			}
+			out[k] = nv
+		}
+		return out
+	}
+	for i, x := range b.Groups {
+		b.Groups[i].Vars = vars(fmt.Sprintf("group %q", x.Name), x.Vars, false)
+		for j := range x.Rules {
+			gr := x.Rules[j].model(0)
+			if err := normalizeRule(&gr); err != nil {
+				errs = append(errs, fmt.Sprintf("group %q: rules[%d]: %v", x.Name, j, err))
+				continue
+			}
+			b.Groups[i].Rules[j] = BundleRule{Field: gr.Field, FactKey: gr.FactKey, Op: gr.Op, Value: gr.Value}
+		}
+	}
+	for i, x := range b.Devices {
+		b.Devices[i].Vars = vars("device "+x.UUID, x.Vars, true)
+	}
+	b.Globals = vars("globals", b.Globals, true)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrBundleInvalid, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func normCIDR(s string) (string, error) {
+	_, nw, err := net.ParseCIDR(strings.TrimSpace(s))
+	if err != nil {
+		return "", err
+	}
+	return nw.String(), nil
+}
+
+// importer — состояние одного импорта; r работает внутри транзакции.
+type importer struct {
+	r       *Repo
+	b       *Bundle
+	replace bool
+	res     *ImportResult
+	tpl     map[string]uint // имя → id в целевой БД
+	grp     map[string]uint
+	pfx     map[string]uint // CIDR → id
+}
+
+// varDefs — определения переменных (только запись: реестр varschema перечитывается
+// после коммита, до него секретность ключей — по бандлу, см. Repo.secret).
+func (im *importer) varDefs() error {
+	if im.b.VarDefs == nil {
+		return nil
+	}
+	st := im.res.stat("var_definitions")
+	secret := false
+	for _, bd := range im.b.VarDefs {
+		want := bd.spec()
+		secret = secret || want.Secret
+		cur, err := im.r.GetVarDefinition(want.Key)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			st.Created++
+		case err != nil:
+			return err
+		case sameSpec(cur, want):
+			st.Unchanged++
+			continue
+		default:
+			st.Updated++
+		}
+		if _, err := im.r.writeVarDefinition(want); err != nil {
+			return fmt.Errorf("var definition %q: %w", want.Key, err)
+		}
+	}
+	if secret && im.r.keys != nil {
+		// уже сохранённые значения ставших секретными переменных — зашифровать
+		if _, err := im.r.RotateSecrets(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameSpec(a, b varschema.Spec) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+// globals — глобальные переменные из таблицы (как syncVars групп и устройств).
+func (im *importer) globals() error {
+	if im.b.Globals == nil {
+		return nil
+	}
+	cur, err := im.r.GetGlobalVars()
+	if err != nil {
+		return err
+	}
+	return im.syncVars(cur, im.b.Globals, im.r.UpsertGlobalVar, im.r.DeleteGlobalVar, im.res.stat("globals"))
+}
+
+func (im *importer) prefixes() error {
+	st := im.res.stat("prefixes")
+	// родители раньше детей: по длине маски
+	ps := append([]BundlePrefix(nil), im.b.Prefixes...)
+	sort.SliceStable(ps, func(i, j int) bool { return maskLen(ps[i].CIDR) < maskLen(ps[j].CIDR) })
+	for _, bp := range ps {
+		var parent *uint
+		if bp.Parent != "" {
+			pc, err := normCIDR(bp.Parent)
+			if err != nil {
+				return fmt.Errorf("%w: prefix %s: parent %q: %v", ErrBundleInvalid, bp.CIDR, bp.Parent, err)
+			}
+			id, err := im.prefixID(pc)
+			if err != nil {
+				return err
+			}
+			if id == 0 {
+				return fmt.Errorf("%w: prefix %s: parent %s not found", ErrBundleInvalid, bp.CIDR, pc)
+			}
+			parent = &id
+		}
+		var cur models.Prefix
+		err := im.r.db.Unscoped().Where(&models.Prefix{CIDR: bp.CIDR}).First(&cur).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			p := models.Prefix{CIDR: bp.CIDR, ParentID: parent, Family: prefixFamily(bp.CIDR), Note: bp.Note}
+			if err := im.r.db.Create(&p).Error; err != nil {
+				return err
+			}
+			im.pfx[bp.CIDR] = p.ID
+			st.Created++
+		case err != nil:
+			return err
+		default:
+			im.pfx[bp.CIDR] = cur.ID
+			if cur.DeletedAt.Valid || cur.Note != bp.Note || !sameUint(cur.ParentID, parent) {
+				if err := im.r.db.Unscoped().Model(&cur).Updates(map[string]any{
+					"note": bp.Note, "parent_id": parent, "deleted_at": nil,
+				}).Error; err != nil {
+					return err
+				}
+				st.Updated++
+			} else {
+				st.Unchanged++
+			}
+		}
+	}
+	return nil
+}
+
+func (im *importer) templates() error {
+	st := im.res.stat("templates")
+	meta := RevisionMeta{Author: "import", Message: "bundle import"}
+	for _, bt := range im.b.Templates {
+		want := models.Template{Name: bt.Name, Path: bt.Path, Type: bt.Type, Required: bt.Required, Default: bt.Default, Body: bt.Body}
+		if want.Type == "" {
+			want.Type = "go"
+		}
+		var cur models.Template
+		err := im.r.db.Unscoped().Where("name = ?", bt.Name).First(&cur).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := im.r.CreateTemplateRev(&want, meta); err != nil {
+				return err
+			}
+			im.tpl[bt.Name] = want.ID
+			st.Created++
+		case err != nil:
+			return err
+		default:
+			im.tpl[bt.Name] = cur.ID
+			if cur.Source != "" && !cur.DeletedAt.Valid {
+				// правится только через файл; бандл может лишь сослаться на него
+				im.res.warnf("template %q is managed by file %s, not updated", cur.Name, cur.Source)
+				st.Unchanged++
+				continue
+			}
+			if !cur.DeletedAt.Valid && cur.Path == want.Path && cur.Type == want.Type && cur.Body == want.Body &&
+				cur.Required == want.Required && cur.Default == want.Default {
+				st.Unchanged++
+				continue
+			}
+			cur.Path, cur.Type, cur.Body, cur.Required, cur.Default = want.Path, want.Type, want.Body, want.Required, want.Default
+			cur.DeletedAt = gorm.DeletedAt{}
+			cur.Source = "" // удалённый файловый шаблон оживает как обычный
+			if err := im.r.db.Unscoped().Model(&models.Template{}).Where("id = ?", cur.ID).Update("deleted_at", nil).Error; err != nil {
+				return err
+			}
+			if err := im.r.UpdateTemplateRev(&cur, meta); err != nil {
+				return err
+			}
+			st.Updated++
+		}
+	}
+	return nil
+}
+
+func (im *importer) groups() error {
+	st := im.res.stat("groups")
+	for _, bg := range im.b.Groups {
+		var cur models.Group
+		err := im.r.db.Unscoped().Where("name = ?", bg.Name).First(&cur).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			g := models.Group{Name: bg.Name, Priority: bg.Priority}
+			if err := im.r.db.Create(&g).Error; err != nil {
+				return err
+			}
+			im.grp[bg.Name] = g.ID
+			st.Created++
+		case err != nil:
+			return err
+		default:
+			im.grp[bg.Name] = cur.ID
+			if cur.DeletedAt.Valid || cur.Priority != bg.Priority {
+				if err := im.r.db.Unscoped().Model(&cur).Updates(map[string]any{"priority": bg.Priority, "deleted_at": nil}).Error; err != nil {
+					return err
+				}
+				st.Updated++
+			} else {
+				st.Unchanged++
+			}
+		}
+	}
+
+	// дерево: сначала проверяем итоговое целиком, потом пишем
+	idx, err := im.r.groupIndex()
+	if err != nil {
+		return err
+	}
+	changed := map[uint]*uint{}
+	for _, bg := range im.b.Groups {
+		id := im.grp[bg.Name]
+		var parent *uint
+		if bg.Parent != "" {
+			pid, err := im.groupID(bg.Parent)
+			if err != nil {
+				return err
+			}
+			if pid == 0 {
+				return fmt.Errorf("%w: group %q: parent %q not found", ErrBundleInvalid, bg.Name, bg.Parent)
+			}
+			parent = &pid
+		}
+		g := idx[id]
+		if !sameUint(g.ParentID, parent) {
+			g.ParentID = parent
+			idx[id] = g
+			changed[id] = parent
+		}
+	}
+	for _, bg := range im.b.Groups {
+		// цепочка должна дойти до корня: иначе цикл или слишком глубоко
+		ch := lineage(idx, im.grp[bg.Name])
+		switch {
+		case len(ch) > 0 && ch[0].ParentID == nil:
+		case len(ch) >= models.MaxGroupDepth:
+			return fmt.Errorf("%w: group %q: %v", ErrBundleInvalid, bg.Name, ErrGroupDepth)
+		default:
+			return fmt.Errorf("%w: group %q: %v", ErrBundleInvalid, bg.Name, ErrGroupCycle)
+		}
+	}
+	for id, parent := range changed {
+		if err := im.r.db.Model(&models.Group{}).Where("id = ?", id).Update("parent_id", parent).Error; err != nil {
+			return err
+		}
+		st.Updated++
+	}
+	return nil
+}
+
+func (im *importer) groupContents() error {
+	for _, bg := range im.b.Groups {
+		gid := im.grp[bg.Name]
+		cur, err := im.r.GetGroupVarsByGroup([]uint{gid})
+		if err != nil {
+			return err
+		}
+		err = im.syncVars(cur[gid], bg.Vars,
+			func(k, v string) error { return im.r.UpsertGroupVar(gid, k, v) },
+			func(k string) error { return im.r.DeleteGroupVar(gid, k) },
+			im.res.stat("group_vars"))
+		if err != nil {
+			return fmt.Errorf("group %q: %w", bg.Name, err)
+		}
+
+		as, err := im.r.ListGroupTemplateAssignments(gid)
+		if err != nil {
+			return err
+		}
+		create := func(tid uint, order int, enabled bool) error {
+			a := models.GroupTemplateAssignment{GroupID: gid, TemplateID: tid, Order: order}
+			return im.createAssignment(&a, enabled)
+		}
+		if err := im.syncAssignments(&models.GroupTemplateAssignment{}, groupAssignRows(as), bg.Templates, create, im.res.stat("group_templates")); err != nil {
+			return fmt.Errorf("group %q: %w", bg.Name, err)
+		}
+
+		if err := im.syncGroupPrefixes(gid, bg); err != nil {
+			return err
+		}
+		if err := im.syncGroupRules(gid, bg); err != nil {
+			return fmt.Errorf("group %q: %w", bg.Name, err)
+		}
+	}
+	return nil
+}
+
+// syncGroupRules — правила сравниваются по (field, fact_key, op, value); членство
+// по удалённым правилам снимается, по новым — появится после EvaluateAll.
+func (im *importer) syncGroupRules(gid uint, bg BundleGroup) error {
+	st := im.res.stat("group_rules")
+	cur, err := im.r.ListGroupRules(gid)
+	if err != nil {
+		return err
+	}
+	key := func(gr models.GroupRule) string {
+		return strings.Join([]string{gr.Field, gr.FactKey, gr.Op, gr.Value}, "\x00")
+	}
+	have := map[string]uint{}
+	for _, gr := range cur {
+		have[key(gr)] = gr.ID
+	}
+	keep := map[string]bool{}
+	for _, br := range bg.Rules {
+		gr := br.model(gid)
+		k := key(gr)
+		if keep[k] {
+			continue
+		}
+		keep[k] = true
+		if _, ok := have[k]; ok {
+			st.Unchanged++
+			continue
+		}
+		if err := im.r.CreateGroupRule(&gr); err != nil {
+			return err
+		}
+		st.Created++
+	}
+	if !im.replace {
+		return nil
+	}
+	for k, id := range have {
+		if keep[k] {
+			continue
+		}
+		if err := im.r.DeleteGroupRule(gid, id); err != nil {
+			return err
+		}
+		st.Deleted++
+	}
+	return nil
+}
+
+func (im *importer) syncGroupPrefixes(gid uint, bg BundleGroup) error {
+	st := im.res.stat("group_prefixes")
+	want := map[uint]bool{}
+	for _, c := range bg.Prefixes {
+		nc, err := normCIDR(c)
+		if err != nil {
+			return fmt.Errorf("%w: group %q: prefix %q: %v", ErrBundleInvalid, bg.Name, c, err)
+		}
+		pid, err := im.prefixID(nc)
+		if err != nil {
+			return err
+		}
+		if pid == 0 {
+			return fmt.Errorf("%w: group %q: prefix %s not found", ErrBundleInvalid, bg.Name, nc)
+		}
+		want[pid] = true
+		var gp models.GroupPrefix
+		err = im.r.db.Where("prefix_id = ?", pid).First(&gp).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := im.r.db.Create(&models.GroupPrefix{GroupID: gid, PrefixID: pid}).Error; err != nil {
+				return err
+			}
+			st.Created++
+		case err != nil:
+			return err
+		case gp.GroupID != gid:
+			// префикс выдаётся одной сущности — переносим
+			if err := im.r.db.Model(&gp).Update("group_id", gid).Error; err != nil {
+				return err
+			}
+			st.Updated++
+		default:
+			st.Unchanged++
+		}
+	}
+	if !im.replace {
+		return nil
+	}
+	res := im.r.db.Unscoped().Where("group_id = ?", gid)
+	if len(want) > 0 {
+		keep := make([]uint, 0, len(want))
+		for id := range want {
+			keep = append(keep, id)
+		}
+		res = res.Where("prefix_id NOT IN ?", keep)
+	}
+	res = res.Delete(&models.GroupPrefix{})
+	st.Deleted += int(res.RowsAffected)
+	return res.Error
+}
+
+func (im *importer) devices() error {
+	var devs []models.Device
+	if err := im.r.db.Find(&devs).Error; err != nil {
+		return err
+	}
+	byUUID := make(map[string]models.Device, len(devs))
+	byMAC := make(map[string]models.Device, len(devs))
+	for _, d := range devs {
+		byUUID[d.UUID] = d
+		if m := hexOnly(d.MAC); len(m) == 12 {
+			byMAC[m] = d
+		}
+	}
+	for _, bd := range im.b.Devices {
+		d, ok := byUUID[bd.UUID]
+		if !ok {
+			if d, ok = byMAC[hexOnly(bd.MAC)]; ok && len(hexOnly(bd.MAC)) == 12 {
+				im.res.warnf("device %s matched by MAC %s as %s", bd.UUID, bd.MAC, d.UUID)
+			} else {
+				im.res.warnf("device %s (%s) not found, skipped", bd.UUID, bd.MAC)
+				continue
+			}
+		}
+		im.res.Devices++
+		if err := im.device(d.UUID, bd); err != nil {
+			return fmt.Errorf("device %s: %w", d.UUID, err)
+		}
+	}
+	return nil
+}
+
+func (im *importer) device(uuid string, bd BundleDevice) error {
+	cur, err := im.r.GetDeviceVars(uuid)
+	if err != nil {
+		return err
+	}
+	err = im.syncVars(cur, bd.Vars,
+		func(k, v string) error { return im.r.UpsertDeviceVar(uuid, k, v) },
+		func(k string) error { return im.r.DeleteDeviceVar(uuid, k) },
+		im.res.stat("device_vars"))
+	if err != nil {
+		return err
+	}
+
+	var as []models.DeviceTemplateAssignment
+	if err := im.r.db.Where("device_uuid = ?", uuid).Find(&as).Error; err != nil {
+		return err
+	}
+	rows := make([]assignRow, 0, len(as))
+	for _, a := range as {
+		rows = append(rows, assignRow{a.ID, a.TemplateID, a.Order, a.Enabled})
+	}
+	create := func(tid uint, order int, enabled bool) error {
+		a := models.DeviceTemplateAssignment{DeviceUUID: uuid, TemplateID: tid, Order: order}
+		return im.createAssignment(&a, enabled)
+	}
+	if err := im.syncAssignments(&models.DeviceTemplateAssignment{}, rows, bd.Templates, create, im.res.stat("device_templates")); err != nil {
+		return err
+	}
+
+	// blocks
+	st := im.res.stat("device_blocks")
+	blocks, err := im.r.ListDeviceTemplateBlocks(uuid)
+	if err != nil {
+		return err
+	}
+	want := map[uint]bool{}
+	for _, n := range bd.Blocks {
+		tid, err := im.templateID(n)
+		if err != nil {
+			return err
+		}
+		want[tid] = true
+		if _, ok := blocks[tid]; ok {
+			st.Unchanged++
+			continue
+		}
+		if err := im.r.BlockTemplateForDevice(uuid, tid); err != nil {
+			return err
+		}
+		st.Created++
+	}
+	if im.replace {
+		for tid := range blocks {
+			if !want[tid] {
+				if err := im.r.UnblockTemplateForDevice(uuid, tid); err != nil {
+					return err
+				}
+				st.Deleted++
+			}
+		}
+	}
+
+	// manual memberships
+	st = im.res.stat("device_groups")
+	var links []models.DeviceGroup
+	if err := im.r.db.Where("device_uuid = ?", uuid).Find(&links).Error; err != nil {
+		return err
+	}
+	have := map[uint]models.DeviceGroup{}
+	for _, l := range links {
+		have[l.GroupID] = l
+	}
+	keep := map[uint]bool{}
+	var primary uint
+	for _, m := range bd.Groups {
+		gid, err := im.groupID(m.Group)
+		if err != nil {
+			return err
+		}
+		if gid == 0 {
+			return fmt.Errorf("%w: group %q not found", ErrBundleInvalid, m.Group)
+		}
+		keep[gid] = true
+		if m.Primary {
+			primary = gid
+		}
+		if l, ok := have[gid]; ok && l.RuleID == nil {
+			st.Unchanged++
+			continue
+		}
+		if _, _, err := im.r.AddDeviceToGroup(uuid, gid); err != nil {
+			return err
+		}
+		st.Created++
+	}
+	if im.replace {
+		for gid, l := range have {
+			if !keep[gid] && l.RuleID == nil {
+				if err := im.r.RemoveDeviceFromGroup(uuid, gid); err != nil {
+					return err
+				}
+				st.Deleted++
+			}
+		}
+	}
+	curPrimary, err := im.r.PrimaryGroupID(uuid)
+	if err != nil {
+		return err
+	}
+	if primary != curPrimary && (primary != 0 || im.replace) {
+		if err := im.r.SetPrimaryGroup(uuid, primary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune — режим replace: удалить шаблоны, группы и префиксы, которых нет в бандле.
+func (im *importer) prune() error {
+	if !im.replace {
+		return nil
+	}
+	st := im.res.stat("templates")
+	tpls, err := im.r.ListTemplates()
+	if err != nil {
+		return err
+	}
+	for _, t := range tpls {
+		if _, ok := im.tpl[t.Name]; ok || t.Source != "" {
+			continue
+		}
+		for _, m := range []any{&models.DeviceTemplateAssignment{}, &models.GroupTemplateAssignment{}, &models.DeviceTemplateBlock{}} {
+			if err := im.r.db.Unscoped().Where("template_id = ?", t.ID).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		if err := im.r.DeleteTemplate(t.ID); err != nil {
+			return err
+		}
+		st.Deleted++
+	}
+
+	st = im.res.stat("groups")
+	gs, err := im.r.ListGroups()
+	if err != nil {
+		return err
+	}
+	for _, g := range gs {
+		if _, ok := im.grp[g.Name]; ok {
+			continue
+		}
+		if err := im.r.DeleteGroup(g.ID); err != nil {
+			return err
+		}
+		st.Deleted++
+	}
+
+	if im.b.VarDefs != nil {
+		st = im.res.stat("var_definitions")
+		want := make(map[string]bool, len(im.b.VarDefs))
+		for _, bd := range im.b.VarDefs {
+			want[bd.Key] = true
+		}
+		specs, err := im.r.ListVarDefinitions()
+		if err != nil {
+			return err
+		}
+		for _, s := range specs {
+			if want[s.Key] {
+				continue
+			}
+			if err := im.r.deleteVarDefinition(s.Key); err != nil {
+				return err
+			}
+			st.Deleted++
+		}
+	}
+
+	// префиксы: сначала самые длинные маски (дети раньше родителей); занятые не трогаем
+	st = im.res.stat("prefixes")
+	var ps []models.Prefix
+	if err := im.r.db.Find(&ps).Error; err != nil {
+		return err
+	}
+	sort.SliceStable(ps, func(i, j int) bool { return maskLen(ps[i].CIDR) > maskLen(ps[j].CIDR) })
+	for _, p := range ps {
+		if _, ok := im.pfx[p.CIDR]; ok {
+			continue
+		}
+		var ips, kids int64
+		if err := im.r.db.Model(&models.DeviceIP{}).Where("prefix_id = ?", p.ID).Count(&ips).Error; err != nil {
+			return err
+		}
+		if err := im.r.db.Model(&models.Prefix{}).Where("parent_id = ?", p.ID).Count(&kids).Error; err != nil {
+			return err
+		}
+		if ips > 0 || kids > 0 {
+			im.res.warnf("prefix %s kept: %d device addresses, %d child prefixes", p.CIDR, ips, kids)
+			continue
+		}
+		if err := im.r.db.Unscoped().Where("prefix_id = ?", p.ID).Delete(&models.GroupPrefix{}).Error; err != nil {
+			return err
+		}
+		if err := im.r.db.Unscoped().Delete(&models.Prefix{}, p.ID).Error; err != nil {
+			return err
+		}
+		st.Deleted++
+	}
+	return nil
+}
+
+// syncVars — общая часть для group/device переменных. Замаскированные значения
+// секретов (экспорт без секретов) означают «не менять» и в replace не удаляются.
+func (im *importer) syncVars(cur, want map[string]string, set func(k, v string) error, del func(k string) error, st *ImportStat) error {
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := want[k]
+		old, had := cur[k]
+		switch {
+		case v == varschema.Masked && im.r.isSecret(k):
+			st.Unchanged++
+			if !had {
+				im.res.warnf("var %s: masked secret in bundle and no value on target", k)
+			}
+		case had && old == v:
+			st.Unchanged++
+		default:
+			if err := set(k, v); err != nil {
+				return err
+			}
+			if had {
+				st.Updated++
+			} else {
+				st.Created++
+			}
+		}
+	}
+	if !im.replace {
+		return nil
+	}
+	for k := range cur {
+		if _, ok := want[k]; ok {
+			continue
+		}
+		if err := del(k); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		st.Deleted++
+	}
+	return nil
+}
+
+type assignRow struct {
+	id, templateID uint
+	order          int
+	enabled        bool
+}
+
+func groupAssignRows(as []models.GroupTemplateAssignment) []assignRow {
+	out := make([]assignRow, 0, len(as))
+	for _, a := range as {
+		out = append(out, assignRow{a.ID, a.TemplateID, a.Order, a.Enabled})
+	}
+	return out
+}
+
+// createAssignment — Enabled с default:true gorm при false не запишет, поэтому отдельным Update.
+func (im *importer) createAssignment(a any, enabled bool) error {
+	if err := im.r.db.Create(a).Error; err != nil {
+		return err
+	}
+	if enabled {
+		return nil
+	}
+	return im.r.db.Model(a).Update("enabled", false).Error
+}
+
+// syncAssignments — назначения шаблонов группы или устройства (model — тип строк).
+func (im *importer) syncAssignments(model any, cur []assignRow, want []BundleAssignment, create func(tid uint, order int, enabled bool) error, st *ImportStat) error {
+	have := map[uint]assignRow{}
+	for _, a := range cur {
+		have[a.templateID] = a
+	}
+	keep := map[uint]bool{}
+	for _, ba := range want {
+		tid, err := im.templateID(ba.Template)
+		if err != nil {
+			return err
+		}
+		keep[tid] = true
+		a, ok := have[tid]
+		switch {
+		case !ok:
+			if err := create(tid, ba.Order, ba.Enabled); err != nil {
+				return err
+			}
+			st.Created++
+		case a.order != ba.Order || a.enabled != ba.Enabled:
+			if err := im.r.db.Model(model).Where("id = ?", a.id).
+				Updates(map[string]any{"order": ba.Order, "enabled": ba.Enabled}).Error; err != nil {
+				return err
+			}
+			st.Updated++
+		default:
+			st.Unchanged++
+		}
+	}
+	if !im.replace {
+		return nil
+	}
+	for tid, a := range have {
+		if keep[tid] {
+			continue
+		}
+		if err := im.r.db.Unscoped().Where("id = ?", a.id).Delete(model).Error; err != nil {
+			return err
+		}
+		st.Deleted++
+	}
+	return nil
+}
+
+// templateID — шаблон по имени: из бандла или уже существующий в целевой БД.
+func (im *importer) templateID(name string) (uint, error) {
+	if id, ok := im.tpl[name]; ok {
+		return id, nil
+	}
+	var t models.Template
+	err := im.r.db.Where("name = ?", name).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("%w: template %q not found", ErrBundleInvalid, name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	im.tpl[name] = t.ID
+	return t.ID, nil
+}
+
+// groupID — 0, если группы нет ни в бандле, ни в БД.
+func (im *importer) groupID(name string) (uint, error) {
+	if id, ok := im.grp[name]; ok {
+		return id, nil
+	}
+	var g models.Group
+	err := im.r.db.Where("name = ?", name).First(&g).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	im.grp[name] = g.ID
+	return g.ID, nil
+}
+
+// prefixID — 0, если префикса нет ни в бандле, ни в БД.
+func (im *importer) prefixID(cidr string) (uint, error) {
+	if id, ok := im.pfx[cidr]; ok {
+		return id, nil
+	}
+	var p models.Prefix
+	err := im.r.db.Where(&models.Prefix{CIDR: cidr}).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	im.pfx[cidr] = p.ID
+	return p.ID, nil
+}
+
+func maskLen(cidr string) int {
+	if _, nw, err := net.ParseCIDR(cidr); err == nil {
+		ones, _ := nw.Mask.Size()
+		return ones
+	}
+	return 0
+}
+
+func prefixFamily(cidr string) string {
+	if ip, _, err := net.ParseCIDR(cidr); err == nil && ip.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+func sameUint(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,350 @@
+// internal/configsvc/filetemplates.go
+package configsvc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"wisp/internal/logs"
+	"wisp/internal/models"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// ── File-backed templates ───────────────────────────────────
+// controller.templates_dir — дерево файлов-шаблонов (удобно держать в git).
+// Файлы синхронизируются в таблицу templates (Template.Source = путь файла),
+// поэтому назначения, предпросмотр, ревизии и бандлы работают как с обычными
+// шаблонами. Изменения файлов подхватываются на лету (fsnotify); такие шаблоны
+// через API не редактируются и не удаляются.
+//
+// Файл — необязательный YAML front-matter между строками "---" и тело:
+//
+//	---
+//	name: system
+//	path: etc/config/system
+//	type: go            # go | netjson
+//	required: false
+//	default: true
+//	---
+//	config system 'system'
+//	    option hostname '{{ .vars.hostname }}'
+//
+// Без front-matter: name и path — путь файла без расширения, type — netjson
+// для *.json, иначе go. Учитываются *.tmpl, *.tpl и *.json; скрытые файлы
+// и каталоги (.git) пропускаются.
+
+// ErrTemplateManaged — шаблон загружен из файла и меняется только через файл.
+var ErrTemplateManaged = errors.New("template is managed by controller.templates_dir")
+
+var templateFileExts = map[string]bool{".tmpl": true, ".tpl": true, ".json": true}
+
+// fileFrontMatter — поля front-matter; отсутствующие берутся по умолчанию.
+type fileFrontMatter struct {
+	Name     string `yaml:"name"`
+	Path     string `yaml:"path"`
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required"`
+	Default  bool   `yaml:"default"`
+}
+
+// parseTemplateFile — шаблон из файла rel (путь относительно каталога, через "/").
+func parseTemplateFile(rel string, data []byte) (models.Template, error) {
+	base := strings.TrimSuffix(rel, filepath.Ext(rel))
+	fm := fileFrontMatter{Name: base, Path: base}
+	if strings.EqualFold(filepath.Ext(rel), ".json") {
+		fm.Type = "netjson"
+	}
+	body := data
+	if head, rest, ok := splitFrontMatter(data); ok {
+		dec := yaml.NewDecoder(bytes.NewReader(head))
+		dec.KnownFields(true)
+		if err := dec.Decode(&fm); err != nil && !errors.Is(err, io.EOF) { // io.EOF — пустой front-matter
+			return models.Template{}, fmt.Errorf("front-matter: %w", err)
+		}
+		body = rest
+	}
+	fm.Name, fm.Path = strings.TrimSpace(fm.Name), strings.TrimSpace(fm.Path)
+	fm.Type = strings.ToLower(strings.TrimSpace(fm.Type))
+	if fm.Type == "" {
+		fm.Type = "go"
+	}
+	if fm.Type != "go" && fm.Type != "netjson" {
+		return models.Template{}, fmt.Errorf("type must be go|netjson, got %q", fm.Type)
+	}
+	if fm.Name == "" {
+		return models.Template{}, errors.New("empty name")
+	}
+	return models.Template{Name: fm.Name, Path: fm.Path, Type: fm.Type, Required: fm.Required,
+		Default: fm.Default, Body: string(body), Source: rel}, nil
+}
+
+// splitFrontMatter — (yaml, тело, true), если файл начинается со строки "---".
+func splitFrontMatter(data []byte) ([]byte, []byte, bool) {
+	d := bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	first, rest, ok := bytes.Cut(d, []byte("\n"))
+	if !ok || strings.TrimSpace(string(first)) != "---" {
+		return nil, nil, false
+	}
+	for off := 0; off < len(rest); {
+		line, _, _ := bytes.Cut(rest[off:], []byte("\n"))
+		end := off + len(line) + 1
+		if strings.TrimSpace(string(line)) == "---" {
+			if end > len(rest) {
+				end = len(rest)
+			}
+			return rest[:off], rest[end:], true
+		}
+		off = end
+	}
+	return nil, nil, false
+}
+
+// FileTemplates синхронизирует каталог шаблонов с БД.
+type FileTemplates struct {
+	repo *Repo
+	dir  string
+	mu   sync.Mutex // одна синхронизация за раз
+}
+
+func NewFileTemplates(repo *Repo, dir string) *FileTemplates {
+	return &FileTemplates{repo: repo, dir: dir}
+}
+
+// Dir — каталог шаблонов.
+func (f *FileTemplates) Dir() string { return f.dir }
+
+// FileSyncResult — итог одной синхронизации.
+type FileSyncResult struct {
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Deleted   int               `json:"deleted"`
+	Unchanged int               `json:"unchanged"`
+	Errors    map[string]string `json:"errors"` // файл → ошибка (прежняя версия в БД остаётся)
+}
+
+// Sync читает каталог и приводит файловые шаблоны в БД к нему одной транзакцией.
+// Файл с ошибкой пропускается, его шаблон в БД не трогается.
+func (f *FileTemplates) Sync() (*FileSyncResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := &FileSyncResult{Errors: map[string]string{}}
+	var files []models.Template
+	byName := map[string]string{}
+	err := filepath.WalkDir(f.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != f.dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() || !templateFileExts[strings.ToLower(filepath.Ext(p))] {
+			return nil
+		}
+		rel, err := filepath.Rel(f.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		data, err := os.ReadFile(p)
+		if err != nil {
+			res.Errors[rel] = err.Error()
+			return nil
+		}
+		t, err := parseTemplateFile(rel, data)
+		if err != nil {
+			res.Errors[rel] = err.Error()
+			return nil
+		}
+		if other, dup := byName[t.Name]; dup {
+			res.Errors[rel] = fmt.Sprintf("name %q already used by %s", t.Name, other)
+			return nil
+		}
+		byName[t.Name] = rel
+		files = append(files, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.repo.db.Transaction(func(tx *gorm.DB) error {
+		t := &Repo{db: tx, keys: f.repo.keys}
+		// остаются строки (source, name) из текущих файлов и все строки файлов с ошибкой;
+		// прочие управляемые — удалённые или переименованные через front-matter файлы
+		type key struct{ source, name string }
+		keep := map[key]bool{}
+		for _, ft := range files {
+			keep[key{ft.Source, ft.Name}] = true
+			if err := t.syncFileTemplate(ft, res); err != nil {
+				return fmt.Errorf("%s: %w", ft.Source, err)
+			}
+		}
+		var managed []models.Template
+		if err := tx.Where("source <> ''").Find(&managed).Error; err != nil {
+			return err
+		}
+		for _, mt := range managed {
+			if _, failed := res.Errors[mt.Source]; failed || keep[key{mt.Source, mt.Name}] {
+				continue
+			}
+			if err := tx.Delete(&models.Template{}, mt.ID).Error; err != nil {
+				return err
+			}
+			res.Deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// syncFileTemplate — строка шаблона по имени; если такого имени нет, а шаблон этого
+// файла уже есть под другим именем (сменили name во front-matter) — переименовываем
+// его, сохраняя id, назначения и историю ревизий. Имя живого шаблона из API —
+// ошибка файла (res.Errors), шаблон не трогается.
+func (r *Repo) syncFileTemplate(ft models.Template, res *FileSyncResult) error {
+	meta := RevisionMeta{Author: "file", Message: ft.Source}
+	var cur models.Template
+	err := r.db.Unscoped().Where("name = ?", ft.Name).First(&cur).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = r.db.Where("source = ?", ft.Source).Order("id").First(&cur).Error
+		if err == nil {
+			logs.Logger.Infof("template %q (id %d) renamed to %q by file %s", cur.Name, cur.ID, ft.Name, ft.Source)
+		}
+	}
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if err := r.CreateTemplateRev(&ft, meta); err != nil {
+			return err
+		}
+		res.Created++
+		return nil
+	case err != nil:
+		return err
+	}
+	if !cur.DeletedAt.Valid && cur.Name == ft.Name && cur.Source == ft.Source && cur.Path == ft.Path && cur.Type == ft.Type &&
+		cur.Body == ft.Body && cur.Required == ft.Required && cur.Default == ft.Default {
+		res.Unchanged++
+		return nil
+	}
+	if cur.Source == "" && !cur.DeletedAt.Valid {
+		// шаблон из API не перехватываем: при удалении файла Sync удалил бы и его
+		res.Errors[ft.Source] = fmt.Sprintf("name %q belongs to template %d created via API", cur.Name, cur.ID)
+		return nil
+	}
+	if cur.DeletedAt.Valid {
+		if err := r.db.Unscoped().Model(&models.Template{}).Where("id = ?", cur.ID).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+	}
+	ft.Model = cur.Model
+	ft.DeletedAt = gorm.DeletedAt{}
+	if err := r.UpdateTemplateRev(&ft, meta); err != nil {
+		return err
+	}
+	res.Updated++
+	return nil
+}
+
+// SyncAndLog — Sync с отчётом в лог (старт и перезагрузка по событиям).
+func (f *FileTemplates) SyncAndLog() {
+	res, err := f.Sync()
+	if err != nil {
+		logs.Logger.Errorf("templates_dir %s: %v", f.dir, err)
+		return
+	}
+	files := make([]string, 0, len(res.Errors))
+	for rel := range res.Errors {
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+	for _, rel := range files {
+		logs.Logger.Warnf("templates_dir: %s skipped: %s", rel, res.Errors[rel])
+	}
+	if res.Created+res.Updated+res.Deleted > 0 {
+		logs.Logger.Infof("templates_dir %s: %d created, %d updated, %d deleted, %d unchanged",
+			f.dir, res.Created, res.Updated, res.Deleted, res.Unchanged)
+	}
+}
+
+// fileReloadDelay — пауза после последнего события: git checkout и редакторы
+// меняют файлы пачкой (временный файл + rename).
+const fileReloadDelay = 300 * time.Millisecond
+
+// Watch следит за каталогом (включая подкаталоги) до отмены ctx и пересинхронизирует
+// шаблоны после изменений.
+func (f *FileTemplates) Watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := watchTree(w, f.dir); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(fileReloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Has(fsnotify.Create) {
+				if st, err := os.Stat(ev.Name); err == nil && st.IsDir() {
+					if err := watchTree(w, ev.Name); err != nil {
+						logs.Logger.Warnf("templates_dir watch %s: %v", ev.Name, err)
+					}
+				}
+			}
+			if ev.Has(fsnotify.Chmod) && !ev.Has(fsnotify.Write) {
+				continue
+			}
+			timer.Reset(fileReloadDelay)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			logs.Logger.Warnf("templates_dir watch: %v", err)
+		case <-timer.C:
+			f.SyncAndLog()
+		}
+	}
+}
+
+// watchTree — fsnotify не рекурсивен: подписываемся на каждый каталог.
+func watchTree(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != root {
+			return filepath.SkipDir
+		}
+		return w.Add(p)
+	})
+}
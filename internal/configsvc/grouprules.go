@@ -0,0 +1,372 @@
+package configsvc
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"wisp/internal/logs"
+	"wisp/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ── Dynamic group membership ────────────────────────────────
+// Группа с правилами (GroupRule) получает устройства автоматически: совпало хотя бы
+// одно правило — есть строка DeviceGroup с RuleID, перестало совпадать — строка
+// удаляется. Ручное членство (RuleID == nil) правилами не трогается и имеет приоритет.
+
+var ErrInvalidRule = errors.New("invalid group rule")
+
+// Поля правил.
+const (
+	RuleName    = "name"
+	RuleBackend = "backend"
+	RuleMACOUI  = "mac_oui"
+	RuleStatus  = "status"
+	RuleFact    = "fact"
+)
+
+// RuleSubject — то, с чем сравниваются правила.
+type RuleSubject struct {
+	Name, Backend, MAC, Status string
+	Facts                      map[string]any
+}
+
+// ruleMatcher — скомпилированное правило.
+type ruleMatcher func(s RuleSubject) bool
+
+// normalizeRule — проверка и умолчания (op: regex для name, иначе eq).
+func normalizeRule(gr *models.GroupRule) error {
+	gr.Field = strings.ToLower(strings.TrimSpace(gr.Field))
+	gr.Op = strings.ToLower(strings.TrimSpace(gr.Op))
+	gr.FactKey = strings.TrimSpace(gr.FactKey)
+	switch gr.Field {
+	case RuleName, RuleBackend, RuleStatus:
+	case RuleMACOUI:
+		oui := hexOnly(gr.Value)
+		if len(oui) != 6 || gr.Op == "regex" {
+			return fmt.Errorf("%w: mac_oui must be 3 octets, e.g. 00:11:22", ErrInvalidRule)
+		}
+		gr.Value = oui[0:2] + ":" + oui[2:4] + ":" + oui[4:6]
+	case RuleFact:
+		if gr.FactKey == "" {
+			return fmt.Errorf("%w: fact_key required for field=fact", ErrInvalidRule)
+		}
+	default:
+		return fmt.Errorf("%w: field must be name|backend|mac_oui|status|fact", ErrInvalidRule)
+	}
+	if gr.Op == "" {
+		gr.Op = "eq"
+		if gr.Field == RuleName {
+			gr.Op = "regex"
+		}
+	}
+	switch gr.Op {
+	case "eq":
+	case "regex":
+		if _, err := regexp.Compile(gr.Value); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	default:
+		return fmt.Errorf("%w: op must be eq|regex", ErrInvalidRule)
+	}
+	return nil
+}
+
+func compileRule(gr models.GroupRule) (ruleMatcher, error) {
+	if err := normalizeRule(&gr); err != nil {
+		return nil, err
+	}
+	get := func(s RuleSubject) (string, bool) {
+		switch gr.Field {
+		case RuleName:
+			return s.Name, true
+		case RuleBackend:
+			return s.Backend, true
+		case RuleStatus:
+			return s.Status, true
+		case RuleMACOUI:
+			h := hexOnly(s.MAC)
+			if len(h) < 6 {
+				return "", false
+			}
+			return h[0:2] + ":" + h[2:4] + ":" + h[4:6], true
+		default:
+			return factValue(s.Facts, gr.FactKey)
+		}
+	}
+	if gr.Op == "regex" {
+		re := regexp.MustCompile(gr.Value)
+		return func(s RuleSubject) bool {
+			v, ok := get(s)
+			return ok && re.MatchString(v)
+		}, nil
+	}
+	return func(s RuleSubject) bool {
+		v, ok := get(s)
+		return ok && v == gr.Value
+	}, nil
+}
+
+func hexOnly(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(s) {
+		if (c >= '0' && c <= '9') || (c >= 'A' && c <= 'F') {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// factValue — значение по пути "a.b.c" во вложенных объектах фактов.
+func factValue(facts map[string]any, path string) (string, bool) {
+	var cur any = facts
+	for _, p := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if cur, ok = m[p]; !ok {
+			return "", false
+		}
+	}
+	switch v := cur.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case map[string]any, []any:
+		return "", false
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// ── Rules CRUD ──────────────────────────────────────────────
+
+func (r *Repo) ListGroupRules(groupID uint) ([]models.GroupRule, error) {
+	var out []models.GroupRule
+	q := r.db.Order("group_id, id")
+	if groupID != 0 {
+		q = q.Where("group_id = ?", groupID)
+	}
+	return out, q.Find(&out).Error
+}
+
+func (r *Repo) CreateGroupRule(gr *models.GroupRule) error {
+	if err := normalizeRule(gr); err != nil {
+		return err
+	}
+	return r.db.Create(gr).Error
+}
+
+// DeleteGroupRule удаляет правило и вычисленное им членство.
+func (r *Repo) DeleteGroupRule(groupID, ruleID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Where("id = ? AND group_id = ?", ruleID, groupID).Delete(&models.GroupRule{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Unscoped().Where("rule_id = ?", ruleID).Delete(&models.DeviceGroup{}).Error
+	})
+}
+
+// ── Evaluation ──────────────────────────────────────────────
+
+// Membership пересчитывает динамические группы; реализует owctrl.DeviceObserver.
+type Membership struct {
+	repo    *Repo
+	devices DeviceLookup
+	facts   FactsProvider
+}
+
+func NewMembership(repo *Repo, devices DeviceLookup, facts FactsProvider) *Membership {
+	return &Membership{repo: repo, devices: devices, facts: facts}
+}
+
+// RuleMatch — группа, в которую устройство попало по правилу.
+type RuleMatch struct {
+	GroupID uint   `json:"group_id"`
+	Group   string `json:"group"`
+	RuleID  uint   `json:"rule_id"`
+	Rule    string `json:"rule"` // человекочитаемо: field op value
+}
+
+func describeRule(gr models.GroupRule) string {
+	f := gr.Field
+	if gr.Field == RuleFact {
+		f = "fact." + gr.FactKey
+	}
+	return fmt.Sprintf("%s %s %q", f, gr.Op, gr.Value)
+}
+
+type compiledRule struct {
+	models.GroupRule
+	match ruleMatcher
+}
+
+func (m *Membership) rules() ([]compiledRule, error) {
+	rows, err := m.repo.ListGroupRules(0)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]compiledRule, 0, len(rows))
+	for _, gr := range rows {
+		fn, err := compileRule(gr)
+		if err != nil {
+			logs.Logger.Warnf("group rule %d skipped: %v", gr.ID, err)
+			continue
+		}
+		out = append(out, compiledRule{GroupRule: gr, match: fn})
+	}
+	return out, nil
+}
+
+// DeviceChanged — owctrl.DeviceObserver: ошибки только в лог.
+func (m *Membership) DeviceChanged(uuid string) {
+	if _, err := m.Evaluate(uuid); err != nil {
+		logs.Logger.Warnf("dynamic groups for %s: %v", uuid, err)
+	}
+}
+
+// Evaluate пересчитывает динамическое членство устройства и возвращает совпадения.
+func (m *Membership) Evaluate(uuid string) ([]RuleMatch, error) {
+	rules, err := m.rules()
+	if err != nil {
+		return nil, err
+	}
+	return m.evaluate(uuid, rules)
+}
+
+// EvaluateAll — пересчёт для всех устройств (после изменения правил).
+func (m *Membership) EvaluateAll() (int, error) {
+	rules, err := m.rules()
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	if err := m.repo.db.Model(&models.Device{}).Order("id").Pluck("uuid", &ids).Error; err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if _, err := m.evaluate(id, rules); err != nil {
+			return 0, fmt.Errorf("device %s: %w", id, err)
+		}
+	}
+	return len(ids), nil
+}
+
+func (m *Membership) evaluate(uuid string, rules []compiledRule) ([]RuleMatch, error) {
+	dev, ok := m.devices.FindByUUID(uuid)
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	subj := RuleSubject{Name: dev.Name, Backend: dev.Backend, MAC: dev.MAC, Status: dev.Status}
+	if m.facts != nil {
+		if f, err := m.facts.GetDeviceFacts(uuid); err == nil {
+			subj.Facts = f
+		}
+	}
+
+	// первое совпавшее правило группы (по id) — «причина» членства
+	want := map[uint]compiledRule{}
+	for _, cr := range rules {
+		if _, done := want[cr.GroupID]; !done && cr.match(subj) {
+			want[cr.GroupID] = cr
+		}
+	}
+
+	err := m.repo.db.Transaction(func(tx *gorm.DB) error {
+		var links []models.DeviceGroup
+		if err := tx.Where("device_uuid = ?", uuid).Find(&links).Error; err != nil {
+			return err
+		}
+		have := map[uint]models.DeviceGroup{}
+		for _, l := range links {
+			have[l.GroupID] = l
+		}
+		for gid, cr := range want {
+			l, exists := have[gid]
+			switch {
+			case !exists:
+				rid := cr.ID
+				if err := tx.Create(&models.DeviceGroup{DeviceUUID: uuid, GroupID: gid, RuleID: &rid}).Error; err != nil {
+					return err
+				}
+			case l.RuleID != nil && *l.RuleID != cr.ID:
+				if err := tx.Model(&l).Update("rule_id", cr.ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+		for gid, l := range have {
+			if _, keep := want[gid]; !keep && l.RuleID != nil {
+				if err := tx.Unscoped().Delete(&l).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names, err := m.repo.groupIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]RuleMatch, 0, len(want))
+	for gid, cr := range want {
+		out = append(out, RuleMatch{GroupID: gid, Group: names[gid].Name, RuleID: cr.ID, Rule: describeRule(cr.GroupRule)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GroupID < out[j].GroupID })
+	return out, nil
+}
+
+// MembershipInfo — членство устройства с причиной.
+type MembershipInfo struct {
+	GroupID uint   `json:"group_id"`
+	Group   string `json:"group"`
+	Source  string `json:"source"` // manual | rule
+	Primary bool   `json:"primary,omitempty"`
+	RuleID  *uint  `json:"rule_id,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+}
+
+// DeviceMemberships — прямое членство устройства: вручную или каким правилом.
+func (r *Repo) DeviceMemberships(uuid string) ([]MembershipInfo, error) {
+	var links []models.DeviceGroup
+	if err := r.db.Where("device_uuid = ?", uuid).Order("group_id").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	idx, err := r.groupIndex()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := r.ListGroupRules(0)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.GroupRule, len(rules))
+	for _, gr := range rules {
+		byID[gr.ID] = gr
+	}
+	out := make([]MembershipInfo, 0, len(links))
+	for _, l := range links {
+		mi := MembershipInfo{GroupID: l.GroupID, Group: idx[l.GroupID].Name, Source: "manual", Primary: l.IsPrimary}
+		if l.RuleID != nil {
+			mi.Source, mi.RuleID = "rule", l.RuleID
+			if gr, ok := byID[*l.RuleID]; ok {
+				mi.Rule = describeRule(gr)
+			}
+		}
+		out = append(out, mi)
+	}
+	return out, nil
+}
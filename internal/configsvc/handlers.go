@@ -2,6 +2,8 @@ package configsvc
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -9,6 +11,7 @@ import (
 	"wisp/internal/models"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
 type HTTP struct{ repo *Repo }
@@ -24,6 +27,12 @@ func (h *HTTP) RegisterRoutes(r *mux.Router) {
 	api.HandleFunc("/templates/{id}", h.updateTemplate).Methods(http.MethodPut, http.MethodPatch)
 	api.HandleFunc("/templates/{id}", h.deleteTemplate).Methods(http.MethodDelete)
 
+	// template revisions
+	api.HandleFunc("/templates/{id}/revisions", h.listRevisions).Methods(http.MethodGet)
+	api.HandleFunc("/templates/{id}/revisions/{rev}", h.getRevision).Methods(http.MethodGet)
+	api.HandleFunc("/templates/{id}/revisions/{rev}/diff", h.diffRevision).Methods(http.MethodGet)
+	api.HandleFunc("/templates/{id}/revisions/{rev}/restore", h.restoreRevision).Methods(http.MethodPost)
+
 	// device vars
 	api.HandleFunc("/devices/{uuid}/vars", h.upsertVar).Methods(http.MethodPost)
 	api.HandleFunc("/devices/{uuid}/vars", h.getVars).Methods(http.MethodGet)
@@ -35,6 +44,11 @@ func (h *HTTP) RegisterRoutes(r *mux.Router) {
 	api.HandleFunc("/devices/{uuid}/templates/order", h.reorderTemplates).Methods(http.MethodPut, http.MethodPost)
 
 	api.HandleFunc("/groups/{id}/templates", h.assignTemplateToGroup).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{id}/templates", h.listGroupTemplates).Methods(http.MethodGet)
+	api.HandleFunc("/groups/{id}/templates/order", h.reorderGroupTemplates).Methods(http.MethodPut, http.MethodPost)
+	// {"enabled": bool, "order": int} — оба необязательны
+	api.HandleFunc("/groups/{id}/templates/{tid}", h.updateGroupTemplate).Methods(http.MethodPatch)
+	api.HandleFunc("/groups/{id}/templates/{tid}", h.unassignGroupTemplate).Methods(http.MethodDelete)
 
 	// DEVICE BLOCKS
 	api.HandleFunc("/devices/{uuid}/templates/{id}/block", h.blockTpl).Methods(http.MethodDelete, http.MethodPost)
@@ -67,6 +81,77 @@ func (h *HTTP) assignTemplateToGroup(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (h *HTTP) listGroupTemplates(w http.ResponseWriter, r *http.Request) {
+	gidU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	as, err := h.repo.ListGroupTemplateAssignments(uint(gidU))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(as)
+}
+
+func (h *HTTP) updateGroupTemplate(w http.ResponseWriter, r *http.Request) {
+	gidU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	tidU, _ := strconv.ParseUint(mux.Vars(r)["tid"], 10, 64)
+	var in struct {
+		Enabled *bool `json:"enabled"`
+		Order   *int  `json:"order"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	err := h.repo.UpdateGroupAssignment(uint(gidU), uint(tidU), in.Enabled, in.Order)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "assignment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTP) unassignGroupTemplate(w http.ResponseWriter, r *http.Request) {
+	gidU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	tidU, _ := strconv.ParseUint(mux.Vars(r)["tid"], 10, 64)
+	err := h.repo.UnassignTemplateFromGroup(uint(gidU), uint(tidU))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "assignment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTP) reorderGroupTemplates(w http.ResponseWriter, r *http.Request) {
+	gidU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	var in struct {
+		Items []struct {
+			ID    uint `json:"id"`
+			Order int  `json:"order"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	items := make([]ReorderItem, 0, len(in.Items))
+	for _, it := range in.Items {
+		items = append(items, ReorderItem{ID: it.ID, Order: it.Order})
+	}
+	if err := h.repo.ReorderGroupTemplates(uint(gidU), items); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *HTTP) blockTpl(w http.ResponseWriter, r *http.Request) {
 	uuid := mux.Vars(r)["uuid"]
 	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
@@ -87,86 +172,40 @@ func (h *HTTP) unblockTpl(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// resolved list на чтение (без рендера), полезно для UI
+// resolved list на чтение (без рендера), полезно для UI; тот же порядок, блокировки
+// и дедупликация, что и в сборке (Repo.ResolvedTemplatesForDevice)
 func (h *HTTP) resolvedTemplates(w http.ResponseWriter, r *http.Request) {
 	uuid := mux.Vars(r)["uuid"]
-
-	// required
-	req, _ := h.repo.ListRequiredTemplates()
-
-	// default
-	def, _ := h.repo.ListDefaultTemplates()
-
-	// group (с учётом блоков)
-	gids, _ := h.repo.GetGroupIDs(uuid) // этот метод у тебя есть
-	gas, _ := h.repo.ListGroupTemplates(gids)
-	blocks, _ := h.repo.ListDeviceTemplateBlocks(uuid)
-	gTplIDs := make([]uint, 0, len(gas))
-	for _, a := range gas {
-		if _, blocked := blocks[a.TemplateID]; blocked {
-			continue
-		}
-		gTplIDs = append(gTplIDs, a.TemplateID)
-	}
-
-	// device
-	das, _ := h.repo.ListAssignments(uuid)
-
-	// собрать карты
-	ids := make([]uint, 0, len(req)+len(def)+len(gTplIDs)+len(das))
-	for _, t := range req {
-		ids = append(ids, t.ID)
-	}
-	for _, t := range def {
-		ids = append(ids, t.ID)
-	}
-	ids = append(ids, gTplIDs...)
-	for _, a := range das {
-		ids = append(ids, a.TemplateID)
-	}
-	byID, _ := h.repo.GetTemplatesByIDs(ids)
-
-	type item struct {
-		Source   string          `json:"source"` // required|default|group|device
-		Order    int             `json:"order"`
-		Template models.Template `json:"template"`
-	}
-	out := make([]item, 0, len(ids))
-
-	for _, t := range req {
-		out = append(out, item{Source: "required", Order: 0, Template: t})
-	}
-	for _, t := range def {
-		out = append(out, item{Source: "default", Order: 0, Template: t})
+	gids, err := h.repo.EffectiveGroupIDs(uuid) // с унаследованными от родительских групп
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
 	}
-	for _, a := range gas {
-		if _, blocked := blocks[a.TemplateID]; blocked {
-			continue
-		}
-		if t, ok := byID[a.TemplateID]; ok {
-			out = append(out, item{Source: "group", Order: a.Order, Template: t})
-		}
+	out, err := h.repo.ResolvedTemplatesWithSource(uuid, gids)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
 	}
-	for _, a := range das {
-		if t, ok := byID[a.TemplateID]; ok {
-			out = append(out, item{Source: "device", Order: a.Order, Template: t})
-		}
+	if out == nil {
+		out = []ResolvedTemplate{}
 	}
-	_ = json.NewEncoder(w).Encode(out)
+	models.WriteJSON(w, http.StatusOK, out)
 }
 
 func (h *HTTP) createTemplate(w http.ResponseWriter, r *http.Request) {
 	var in struct {
-		Name string `json:"name"`
-		Path string `json:"path"`
-		Body string `json:"body"`
+		Name    string `json:"name"`
+		Path    string `json:"path"`
+		Body    string `json:"body"`
+		Author  string `json:"author"`
+		Message string `json:"message"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
 	t := &models.Template{Name: in.Name, Path: in.Path, Body: in.Body}
-	if err := h.repo.CreateTemplate(t); err != nil {
+	if err := h.repo.CreateTemplateRev(t, revisionMeta(r, in.Author, in.Message)); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
@@ -189,10 +228,15 @@ func (h *HTTP) updateTemplate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 404)
 		return
 	}
+	if writeManaged(w, t) {
+		return
+	}
 	var in struct {
-		Name *string `json:"name"`
-		Path *string `json:"path"`
-		Body *string `json:"body"`
+		Name    *string `json:"name"`
+		Path    *string `json:"path"`
+		Body    *string `json:"body"`
+		Author  string  `json:"author"`
+		Message string  `json:"message"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
 		http.Error(w, err.Error(), 400)
@@ -207,15 +251,36 @@ func (h *HTTP) updateTemplate(w http.ResponseWriter, r *http.Request) {
 	if in.Body != nil {
 		t.Body = *in.Body
 	}
-	if err := h.repo.UpdateTemplate(t); err != nil {
+	if err := h.repo.UpdateTemplateRev(t, revisionMeta(r, in.Author, in.Message)); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	_ = json.NewEncoder(w).Encode(t)
 }
 
+// revisionMeta — автор из тела запроса или заголовка X-User.
+func revisionMeta(r *http.Request, author, message string) RevisionMeta {
+	author = strings.TrimSpace(author)
+	if author == "" {
+		author = strings.TrimSpace(r.Header.Get("X-User"))
+	}
+	return RevisionMeta{Author: author, Message: strings.TrimSpace(message)}
+}
+
+// writeManaged — 409 для шаблона из controller.templates_dir; true — ответ уже записан.
+func writeManaged(w http.ResponseWriter, t *models.Template) bool {
+	if t.Source == "" {
+		return false
+	}
+	http.Error(w, fmt.Sprintf("%v: edit %s instead", ErrTemplateManaged, t.Source), http.StatusConflict)
+	return true
+}
+
 func (h *HTTP) deleteTemplate(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if t, err := h.repo.GetTemplate(uint(id)); err == nil && writeManaged(w, t) {
+		return
+	}
 	if err := h.repo.DeleteTemplate(uint(id)); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -235,6 +300,10 @@ func (h *HTTP) upsertVar(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "key required", 400)
 		return
 	}
+	if varschema.Unchanged(in.Key, in.Value) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	// validate/normalize
 	val, err := varschema.ValidateOne(in.Key, in.Value)
 	if err != nil {
@@ -262,6 +331,9 @@ func (h *HTTP) bulkUpsertVars(w http.ResponseWriter, r *http.Request) {
 	norm := map[string]string{}
 	for k, v := range obj {
 		k2 := strings.TrimSpace(k)
+		if varschema.Unchanged(k2, v) {
+			continue
+		}
 		val, err := varschema.ValidateOne(k2, v)
 		if err != nil {
 			errs = append(errs, verr{Key: k2, Error: err.Error()})
@@ -275,12 +347,10 @@ func (h *HTTP) bulkUpsertVars(w http.ResponseWriter, r *http.Request) {
 		_ = json.NewEncoder(w).Encode(map[string]any{"errors": errs})
 		return
 	}
-	// upsert
-	for k, v := range norm {
-		if err := h.repo.UpsertDeviceVar(uuid, k, v); err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
+	// upsert — всё или ничего
+	if err := h.repo.UpsertDeviceVars(uuid, norm); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -315,7 +385,7 @@ func (h *HTTP) getVars(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	_ = json.NewEncoder(w).Encode(m)
+	_ = json.NewEncoder(w).Encode(varschema.MaskMap(m))
 }
 
 func (h *HTTP) assignTemplate(w http.ResponseWriter, r *http.Request) {
@@ -349,7 +419,7 @@ func (h *HTTP) listAssignments(w http.ResponseWriter, r *http.Request) {
 func (r *Repo) ListDefaultTemplates() ([]models.Template, error) {
 	var out []models.Template
 	err := r.db.
-		Where("`default` = ?", true).
+		Where(colEq("default", true)).
 		Order("id ASC").
 		Find(&out).Error
 	return out, err
@@ -0,0 +1,51 @@
+package configsvc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// BulkHTTP — массовые изменения по селектору устройств.
+type BulkHTTP struct{ bulk *Bulk }
+
+func NewBulkHTTP(b *Bulk) *BulkHTTP { return &BulkHTTP{bulk: b} }
+
+func (h *BulkHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// см. BulkRequest; "dry_run": true — только отчёт, без записи
+	api.HandleFunc("/bulk", h.apply).Methods(http.MethodPost)
+}
+
+func (h *BulkHTTP) apply(w http.ResponseWriter, r *http.Request) {
+	var req BulkRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields() // опечатка в имени операции не должна молча превращаться в no-op
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := r.URL.Query().Get("dry_run"); v == "1" || v == "true" {
+		req.DryRun = true
+	}
+	res, err := h.bulk.Apply(req)
+	var verr *BulkValidationError
+	switch {
+	case errors.As(err, &verr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"errors": verr.Errors})
+		return
+	case errors.Is(err, ErrBulkSelector), errors.Is(err, ErrBulkInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,106 @@
+package configsvc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// BundleHTTP — экспорт/импорт конфигурации (см. Bundle).
+// После импорта динамическое членство пересчитывается (правила групп могли измениться).
+type BundleHTTP struct {
+	repo *Repo
+	m    *Membership
+}
+
+func NewBundleHTTP(r *Repo, m *Membership) *BundleHTTP { return &BundleHTTP{repo: r, m: m} }
+
+func (h *BundleHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// ?format=json|yaml, ?secrets=1 — секреты открытым текстом (по умолчанию маскируются)
+	api.HandleFunc("/bundle/export", h.export).Methods(http.MethodGet)
+	// ?mode=merge|replace, ?dry_run=1; формат — по ?format или Content-Type
+	api.HandleFunc("/bundle/import", h.importBundle).Methods(http.MethodPost)
+}
+
+// bundleYAML — запрошен YAML: ?format=yaml или соответствующий заголовок.
+func bundleYAML(r *http.Request, header string) bool {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "yaml", "yml":
+		return true
+	case "json":
+		return false
+	}
+	return strings.Contains(strings.ToLower(r.Header.Get(header)), "yaml")
+}
+
+func (h *BundleHTTP) export(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query().Get("secrets")
+	b, err := h.repo.ExportBundle(v == "1" || v == "true")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	ext := "json"
+	if bundleYAML(r, "Accept") {
+		ext = "yaml"
+	}
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"wisp-bundle.%s\"", ext))
+	if ext == "yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		_ = enc.Encode(b)
+		_ = enc.Close()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(b)
+}
+
+func (h *BundleHTTP) importBundle(w http.ResponseWriter, r *http.Request) {
+	var b Bundle
+	if bundleYAML(r, "Content-Type") {
+		dec := yaml.NewDecoder(r.Body)
+		dec.KnownFields(true)
+		if err := dec.Decode(&b); err != nil {
+			http.Error(w, "invalid yaml: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&b); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	q := r.URL.Query()
+	dry := q.Get("dry_run") == "1" || q.Get("dry_run") == "true"
+	res, err := h.repo.ImportBundle(&b, q.Get("mode"), dry)
+	switch {
+	case errors.Is(err, ErrBundleVersion), errors.Is(err, ErrBundleInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !dry && h.m != nil {
+		if _, err := h.m.EvaluateAll(); err != nil {
+			http.Error(w, "bundle imported, evaluation failed: "+err.Error(), 500)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
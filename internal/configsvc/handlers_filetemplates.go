@@ -0,0 +1,28 @@
+package configsvc
+
+import (
+	"net/http"
+	"wisp/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// FileTemplatesHTTP — ручная пересинхронизация controller.templates_dir
+// (например, если fsnotify не видит изменений на сетевой ФС).
+type FileTemplatesHTTP struct{ files *FileTemplates }
+
+func NewFileTemplatesHTTP(f *FileTemplates) *FileTemplatesHTTP { return &FileTemplatesHTTP{files: f} }
+
+func (h *FileTemplatesHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/templates/files/sync", h.sync).Methods(http.MethodPost)
+}
+
+func (h *FileTemplatesHTTP) sync(w http.ResponseWriter, r *http.Request) {
+	res, err := h.files.Sync()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{"dir": h.files.Dir(), "result": res})
+}
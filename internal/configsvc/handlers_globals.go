@@ -0,0 +1,119 @@
+package configsvc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"wisp/internal/configsvc/varschema"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type GlobalsHTTP struct{ g *Globals }
+
+func NewGlobalsHTTP(g *Globals) *GlobalsHTTP { return &GlobalsHTTP{g: g} }
+
+func (h *GlobalsHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// GET — действующие значения (config + db) с источником
+	api.HandleFunc("/globals", h.list).Methods(http.MethodGet)
+	// POST {key, value} / POST /bulk {"k":"v",...} — в БД, перекрывают config
+	api.HandleFunc("/globals", h.upsert).Methods(http.MethodPost)
+	api.HandleFunc("/globals/bulk", h.bulkUpsert).Methods(http.MethodPost)
+	// DELETE — только db-значение (значение из конфига, если есть, снова станет действующим)
+	api.HandleFunc("/globals/{key}", h.delete).Methods(http.MethodDelete)
+}
+
+func (h *GlobalsHTTP) list(w http.ResponseWriter, r *http.Request) {
+	items, err := h.g.List()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+func (h *GlobalsHTTP) upsert(w http.ResponseWriter, r *http.Request) {
+	var in struct{ Key, Value string }
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	in.Key = strings.TrimSpace(in.Key)
+	if in.Key == "" {
+		http.Error(w, "key required", 400)
+		return
+	}
+	if varschema.Unchanged(in.Key, in.Value) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	val, err := varschema.ValidateOne(in.Key, in.Value)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := h.g.repo.UpsertGlobalVar(in.Key, val); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *GlobalsHTTP) bulkUpsert(w http.ResponseWriter, r *http.Request) {
+	var obj map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	type verr struct{ Key, Error string }
+	var errs []verr
+	norm := map[string]string{}
+	for k, v := range obj {
+		k2 := strings.TrimSpace(k)
+		if varschema.Unchanged(k2, v) {
+			continue
+		}
+		val, err := varschema.ValidateOne(k2, v)
+		if err != nil {
+			errs = append(errs, verr{Key: k2, Error: err.Error()})
+			continue
+		}
+		norm[k2] = val
+	}
+	if len(errs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"errors": errs})
+		return
+	}
+	for k, v := range norm {
+		if err := h.g.repo.UpsertGlobalVar(k, v); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *GlobalsHTTP) delete(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	err := h.g.repo.DeleteGlobalVar(key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if h.g.InConfig(key) {
+			http.Error(w, "variable is set in config (global_vars), not in db", http.StatusConflict)
+			return
+		}
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,143 @@
+package configsvc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"wisp/internal/models"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// GroupRulesHTTP — правила динамического членства и пересчёт.
+type GroupRulesHTTP struct {
+	repo *Repo
+	m    *Membership
+}
+
+func NewGroupRulesHTTP(r *Repo, m *Membership) *GroupRulesHTTP {
+	return &GroupRulesHTTP{repo: r, m: m}
+}
+
+func (h *GroupRulesHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// {field: name|backend|mac_oui|status|fact, op: eq|regex, value, fact_key}
+	api.HandleFunc("/groups/{id}/rules", h.list).Methods(http.MethodGet)
+	api.HandleFunc("/groups/{id}/rules", h.create).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{id}/rules/{rid}", h.delete).Methods(http.MethodDelete)
+
+	// пересчёт: всех устройств / одного (обычно — сам при регистрации и отчёте агента)
+	api.HandleFunc("/group-rules/evaluate", h.evaluateAll).Methods(http.MethodPost)
+	api.HandleFunc("/devices/{uuid}/memberships/evaluate", h.evaluate).Methods(http.MethodPost)
+	// членство с причиной: manual | rule (какое)
+	api.HandleFunc("/devices/{uuid}/memberships", h.memberships).Methods(http.MethodGet)
+}
+
+type ruleDTO struct {
+	ID      uint   `json:"id"`
+	GroupID uint   `json:"group_id"`
+	Field   string `json:"field"`
+	FactKey string `json:"fact_key,omitempty"`
+	Op      string `json:"op"`
+	Value   string `json:"value"`
+}
+
+func ruleOut(gr models.GroupRule) ruleDTO {
+	return ruleDTO{ID: gr.ID, GroupID: gr.GroupID, Field: gr.Field, FactKey: gr.FactKey, Op: gr.Op, Value: gr.Value}
+}
+
+func (h *GroupRulesHTTP) list(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	rows, err := h.repo.ListGroupRules(uint(idU))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	out := make([]ruleDTO, 0, len(rows))
+	for _, gr := range rows {
+		out = append(out, ruleOut(gr))
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{"items": out})
+}
+
+func (h *GroupRulesHTTP) create(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if idU == 0 || !h.repo.GroupExists(uint(idU)) {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	var in ruleDTO
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	gr := models.GroupRule{GroupID: uint(idU), Field: in.Field, FactKey: in.FactKey, Op: in.Op, Value: in.Value}
+	if err := h.repo.CreateGroupRule(&gr); err != nil {
+		if errors.Is(err, ErrInvalidRule) {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	n, err := h.m.EvaluateAll()
+	if err != nil {
+		http.Error(w, "rule saved, evaluation failed: "+err.Error(), 500)
+		return
+	}
+	models.WriteJSON(w, http.StatusCreated, map[string]any{"rule": ruleOut(gr), "evaluated": n})
+}
+
+func (h *GroupRulesHTTP) delete(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	ridU, _ := strconv.ParseUint(mux.Vars(r)["rid"], 10, 64)
+	err := h.repo.DeleteGroupRule(uint(idU), uint(ridU))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// другое правило той же группы могло тоже совпадать
+	if _, err := h.m.EvaluateAll(); err != nil {
+		http.Error(w, "rule deleted, evaluation failed: "+err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *GroupRulesHTTP) evaluateAll(w http.ResponseWriter, _ *http.Request) {
+	n, err := h.m.EvaluateAll()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{"evaluated": n})
+}
+
+func (h *GroupRulesHTTP) evaluate(w http.ResponseWriter, r *http.Request) {
+	matches, err := h.m.Evaluate(mux.Vars(r)["uuid"])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "device not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{"matches": matches})
+}
+
+func (h *GroupRulesHTTP) memberships(w http.ResponseWriter, r *http.Request) {
+	ms, err := h.repo.DeviceMemberships(mux.Vars(r)["uuid"])
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{"items": ms})
+}
@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"wisp/internal/configsvc/varschema"
 	"wisp/internal/models"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
 type GroupHTTP struct{ repo *Repo }
@@ -18,30 +20,48 @@ func NewGroupHTTP(r *Repo) *GroupHTTP { return &GroupHTTP{repo: r} }
 func (h *GroupHTTP) RegisterRoutes(r *mux.Router) {
 	api := r.PathPrefix("/api/v1").Subrouter()
 
-	// groups CRUD (минимум — create + list)
+	// groups CRUD
 	api.HandleFunc("/groups", h.createGroup).Methods(http.MethodPost)
 	api.HandleFunc("/groups", h.listGroups).Methods(http.MethodGet)
+	api.HandleFunc("/groups/{id}", h.getGroup).Methods(http.MethodGet)
+	// {"name": "...", "parent_id": N | null, "priority": N} — все поля необязательны
+	api.HandleFunc("/groups/{id}", h.updateGroup).Methods(http.MethodPut, http.MethodPatch)
+	// каскад — см. Repo.DeleteGroup
+	api.HandleFunc("/groups/{id}", h.deleteGroup).Methods(http.MethodDelete)
+	// дерево: {"parent_id": N | null}
+	api.HandleFunc("/groups/{id}/parent", h.setParent).Methods(http.MethodPut)
 
 	// membership
 	api.HandleFunc("/devices/{uuid}/groups/{id}", h.addMembership).Methods(http.MethodPost)
 	api.HandleFunc("/devices/{uuid}/groups/{id}", h.removeMembership).Methods(http.MethodDelete)
+	// основная группа: IPAM и перевес при равном priority
+	api.HandleFunc("/devices/{uuid}/groups/{id}/primary", h.setPrimary).Methods(http.MethodPut, http.MethodPost)
+	api.HandleFunc("/devices/{uuid}/groups/{id}/primary", h.unsetPrimary).Methods(http.MethodDelete)
+	// ?inherited=1 — вместе с родительскими группами, в порядке применения
 	api.HandleFunc("/devices/{uuid}/groups", h.deviceGroups).Methods(http.MethodGet)
 
 	// group vars
 	api.HandleFunc("/groups/{id}/vars", h.upsertGroupVar).Methods(http.MethodPost)
 	api.HandleFunc("/groups/{id}/vars", h.getGroupVars).Methods(http.MethodGet)
+	api.HandleFunc("/groups/{id}/vars/{key}", h.deleteGroupVar).Methods(http.MethodDelete)
 }
 
 func (h *GroupHTTP) createGroup(w http.ResponseWriter, r *http.Request) {
 	var in struct {
-		Name string `json:"name"`
+		Name     string `json:"name"`
+		ParentID *uint  `json:"parent_id"`
+		Priority int    `json:"priority"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || strings.TrimSpace(in.Name) == "" {
 		http.Error(w, "invalid json or empty name", 400)
 		return
 	}
-	g := &models.Group{Name: in.Name}
+	g := &models.Group{Name: in.Name, ParentID: in.ParentID, Priority: in.Priority}
 	err := h.repo.CreateGroup(g)
+	if isGroupTreeErr(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	switch {
 	case err == nil:
@@ -56,6 +76,123 @@ func (h *GroupHTTP) createGroup(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *GroupHTTP) getGroup(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	g, err := h.repo.GetGroup(uint(idU))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(g)
+}
+
+func (h *GroupHTTP) updateGroup(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	id := uint(idU)
+	// сырые поля: отличаем "parent_id": null (в корень) от отсутствия поля
+	var in map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	if !h.repo.GroupExists(id) {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	if raw, ok := in["parent_id"]; ok {
+		var parent *uint
+		if err := json.Unmarshal(raw, &parent); err != nil {
+			http.Error(w, "parent_id must be a number or null", 400)
+			return
+		}
+		if err := h.repo.SetGroupParent(id, parent); err != nil {
+			writeGroupTreeErr(w, err)
+			return
+		}
+	}
+	if raw, ok := in["priority"]; ok {
+		var prio int
+		if err := json.Unmarshal(raw, &prio); err != nil {
+			http.Error(w, "priority must be an integer", 400)
+			return
+		}
+		if err := h.repo.SetGroupPriority(id, prio); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if raw, ok := in["name"]; ok {
+		var name string
+		if err := json.Unmarshal(raw, &name); err != nil || strings.TrimSpace(name) == "" {
+			http.Error(w, "name must be a non-empty string", 400)
+			return
+		}
+		err := h.repo.RenameGroup(id, strings.TrimSpace(name))
+		if errors.Is(err, ErrDuplicate) {
+			http.Error(w, "group name already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	h.getGroup(w, r)
+}
+
+func (h *GroupHTTP) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	err := h.repo.DeleteGroup(uint(idU))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *GroupHTTP) setParent(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	var in struct {
+		ParentID *uint `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	if err := h.repo.SetGroupParent(uint(idU), in.ParentID); err != nil {
+		writeGroupTreeErr(w, err)
+		return
+	}
+	h.getGroup(w, r)
+}
+
+// writeGroupTreeErr — ошибка SetGroupParent в HTTP-статус.
+func writeGroupTreeErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "group not found", http.StatusNotFound)
+	case errors.Is(err, ErrGroupCycle):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case isGroupTreeErr(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+func isGroupTreeErr(err error) bool {
+	return errors.Is(err, ErrGroupParent) || errors.Is(err, ErrGroupCycle) || errors.Is(err, ErrGroupDepth)
+}
+
 func (h *GroupHTTP) listGroups(w http.ResponseWriter, _ *http.Request) {
 	gs, err := h.repo.ListGroups()
 	if err != nil {
@@ -93,9 +230,44 @@ func (h *GroupHTTP) removeMembership(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (h *GroupHTTP) setPrimary(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	err := h.repo.SetPrimaryGroup(uuid, uint(idU))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "device is not a member of this group", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unsetPrimary снимает флаг, только если основной была именно эта группа.
+func (h *GroupHTTP) unsetPrimary(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	cur, err := h.repo.PrimaryGroupID(uuid)
+	if err == nil && cur != 0 && cur == uint(idU) {
+		err = h.repo.SetPrimaryGroup(uuid, 0)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *GroupHTTP) deviceGroups(w http.ResponseWriter, r *http.Request) {
 	uuid := mux.Vars(r)["uuid"]
-	gs, err := h.repo.GetDeviceGroups(uuid)
+	get := h.repo.GetDeviceGroups
+	if v := r.URL.Query().Get("inherited"); v == "1" || v == "true" {
+		get = h.repo.EffectiveDeviceGroups
+	}
+	gs, err := get(uuid)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -110,6 +282,10 @@ func (h *GroupHTTP) upsertGroupVar(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid json", 400)
 		return
 	}
+	if varschema.Unchanged(in.Key, in.Value) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err := h.repo.UpsertGroupVar(uint(idU), in.Key, in.Value); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -123,5 +299,18 @@ func (h *GroupHTTP) getGroupVars(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	_ = json.NewEncoder(w).Encode(m)
+	_ = json.NewEncoder(w).Encode(varschema.MaskMap(m))
+}
+func (h *GroupHTTP) deleteGroupVar(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	err := h.repo.DeleteGroupVar(uint(idU), mux.Vars(r)["key"])
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
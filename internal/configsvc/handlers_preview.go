@@ -0,0 +1,126 @@
+package configsvc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+	"wisp/internal/owctrl"
+
+	"github.com/gorilla/mux"
+)
+
+// DeviceLookup — поиск устройства для предпросмотра (реализуется repo.DeviceStore).
+type DeviceLookup interface {
+	FindByUUID(id string) (owctrl.DeviceFields, bool)
+}
+
+// PreviewHTTP — админский рендер конфигурации без ключа устройства.
+type PreviewHTTP struct {
+	devices DeviceLookup
+	builder *Builder
+}
+
+func NewPreviewHTTP(devices DeviceLookup, builder *Builder) *PreviewHTTP {
+	return &PreviewHTTP{devices: devices, builder: builder}
+}
+
+func (h *PreviewHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	// GET — как увидит устройство; POST — с overrides в теле.
+	// Значения секретов вырезаны (********), ?secrets=1 — без маскирования.
+	api.HandleFunc("/devices/{uuid}/render", h.render).Methods(http.MethodGet, http.MethodPost)
+}
+
+type previewRequest struct {
+	Vars            map[string]string `json:"vars"`
+	AddTemplates    []uint            `json:"add_templates"`
+	RemoveTemplates []uint            `json:"remove_templates"`
+	Drafts          []DraftTemplate   `json:"drafts"`
+}
+
+type previewOut struct {
+	UUID   string            `json:"uuid"`
+	SHA256 string            `json:"sha256"`
+	Paths  []string          `json:"paths"`
+	Files  map[string]string `json:"files"`
+}
+
+func (h *PreviewHTTP) render(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["uuid"]
+	dev, ok := h.devices.FindByUUID(id)
+	if !ok {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	var ov *Overrides
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		var in previewRequest
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ov = &Overrides{
+			Vars:            in.Vars,
+			AddTemplates:    in.AddTemplates,
+			RemoveTemplates: in.RemoveTemplates,
+			Drafts:          in.Drafts,
+		}
+	}
+
+	files, modes, err := h.builder.BuildConfigWith(dev, ov)
+	if err != nil {
+		models.WriteProblem(w, http.StatusUnprocessableEntity, "Build failed", err.Error(), map[string]string{"uuid": dev.UUID})
+		return
+	}
+	tgz, shaHex, err := owctrl.TarGz(files, modes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Openwisp-Archive-Sha256", shaHex)
+
+	// секреты вырезаны, если не ?secrets=1; sha — всё равно реального архива
+	if !owctrl.WantsSecrets(r) {
+		values, err := h.builder.SecretValuesWith(dev, ov)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		files = varschema.Redact(files, values)
+		if tgz, _, err = owctrl.TarGz(files, modes); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Secrets-Masked", "true")
+	}
+
+	if wantsTarGz(r) {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", "attachment; filename=configuration.tar.gz")
+		_, _ = w.Write(tgz)
+		return
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	models.WriteJSON(w, http.StatusOK, previewOut{UUID: dev.UUID, SHA256: shaHex, Paths: paths, Files: files})
+}
+
+// wantsTarGz — ?format=tar|tar.gz|tgz или Accept: application/gzip.
+func wantsTarGz(r *http.Request) bool {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "tar", "tar.gz", "tgz", "gzip":
+		return true
+	case "json":
+		return false
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/gzip") || strings.Contains(accept, "application/x-gzip")
+}
@@ -0,0 +1,207 @@
+package configsvc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"wisp/internal/models"
+	"wisp/internal/textdiff"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type revisionSummary struct {
+	Revision  int       `json:"revision"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	Size      int       `json:"size"`
+	Author    string    `json:"author,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type revisionOut struct {
+	revisionSummary
+	TemplateID uint   `json:"template_id"`
+	Body       string `json:"body"`
+}
+
+func summarize(rv models.TemplateRevision) revisionSummary {
+	return revisionSummary{
+		Revision:  rv.Revision,
+		Name:      rv.Name,
+		Path:      rv.Path,
+		Type:      rv.Type,
+		Size:      len(rv.Body),
+		Author:    rv.Author,
+		Message:   rv.Message,
+		CreatedAt: rv.CreatedAt,
+	}
+}
+
+// parseTplRev — {id} и {rev} из пути.
+func parseTplRev(r *http.Request) (uint, int, error) {
+	idU, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil || idU == 0 {
+		return 0, 0, errors.New("invalid template id")
+	}
+	rev, err := strconv.Atoi(mux.Vars(r)["rev"])
+	if err != nil || rev <= 0 {
+		return 0, 0, errors.New("invalid revision")
+	}
+	return uint(idU), rev, nil
+}
+
+func (h *HTTP) listRevisions(w http.ResponseWriter, r *http.Request) {
+	idU, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil || idU == 0 {
+		http.Error(w, "invalid template id", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.repo.GetTemplate(uint(idU)); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	revs, err := h.repo.ListTemplateRevisions(uint(idU))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := make([]revisionSummary, 0, len(revs))
+	for _, rv := range revs {
+		out = append(out, summarize(rv))
+	}
+	models.WriteJSON(w, http.StatusOK, out)
+}
+
+func (h *HTTP) getRevision(w http.ResponseWriter, r *http.Request) {
+	id, rev, err := parseTplRev(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rv, err := h.repo.GetTemplateRevision(id, rev)
+	if err != nil {
+		writeRevisionErr(w, err)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, revisionOut{revisionSummary: summarize(*rv), TemplateID: rv.TemplateID, Body: rv.Body})
+}
+
+// GET /templates/{id}/revisions/{rev}/diff?against=<N|current>
+// по умолчанию — против предыдущей ревизии (для ревизии 1 — против пустого шаблона).
+func (h *HTTP) diffRevision(w http.ResponseWriter, r *http.Request) {
+	id, rev, err := parseTplRev(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := h.repo.GetTemplateRevision(id, rev)
+	if err != nil {
+		writeRevisionErr(w, err)
+		return
+	}
+
+	var (
+		from     models.TemplateRevision
+		fromName string
+	)
+	switch against := r.URL.Query().Get("against"); against {
+	case "":
+		fromName = "/dev/null"
+		if rev > 1 {
+			prev, err := h.repo.GetTemplateRevision(id, rev-1)
+			if err != nil {
+				writeRevisionErr(w, err)
+				return
+			}
+			from, fromName = *prev, fmt.Sprintf("revision %d", prev.Revision)
+		}
+	case "current":
+		t, err := h.repo.GetTemplate(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		from = models.TemplateRevision{Name: t.Name, Path: t.Path, Body: t.Body, Type: t.Type}
+		fromName = "current"
+	default:
+		n, err := strconv.Atoi(against)
+		if err != nil || n <= 0 {
+			http.Error(w, "against must be a revision number or \"current\"", http.StatusBadRequest)
+			return
+		}
+		prev, err := h.repo.GetTemplateRevision(id, n)
+		if err != nil {
+			writeRevisionErr(w, err)
+			return
+		}
+		from, fromName = *prev, fmt.Sprintf("revision %d", prev.Revision)
+	}
+
+	type change struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	out := struct {
+		From    string            `json:"from"`
+		To      string            `json:"to"`
+		Changes map[string]change `json:"changes,omitempty"` // name/path/type
+		Diff    string            `json:"diff"`
+	}{From: fromName, To: fmt.Sprintf("revision %d", to.Revision), Changes: map[string]change{}}
+
+	for k, pair := range map[string][2]string{
+		"name": {from.Name, to.Name},
+		"path": {from.Path, to.Path},
+		"type": {from.Type, to.Type},
+	} {
+		if pair[0] != pair[1] {
+			out.Changes[k] = change{From: pair[0], To: pair[1]}
+		}
+	}
+	out.Diff = textdiff.Unified(fromName, out.To, from.Body, to.Body)
+	models.WriteJSON(w, http.StatusOK, out)
+}
+
+func (h *HTTP) restoreRevision(w http.ResponseWriter, r *http.Request) {
+	id, rev, err := parseTplRev(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var in struct {
+		Author  string `json:"author"`
+		Message string `json:"message"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&in) // тело опционально
+	if t, err := h.repo.GetTemplate(id); err == nil && writeManaged(w, t) {
+		return
+	}
+
+	t, rv, err := h.repo.RestoreTemplateRevision(id, rev, revisionMeta(r, in.Author, in.Message))
+	if errors.Is(err, ErrNoChanges) {
+		http.Error(w, "template already matches this revision", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeRevisionErr(w, err)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{
+		"template": t,
+		"revision": summarize(*rv),
+	})
+}
+
+func writeRevisionErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "template or revision not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,33 @@
+package configsvc
+
+import (
+	"errors"
+	"net/http"
+	"wisp/internal/models"
+	"wisp/internal/secrets"
+
+	"github.com/gorilla/mux"
+)
+
+type SecretsHTTP struct{ repo *Repo }
+
+func NewSecretsHTTP(r *Repo) *SecretsHTTP { return &SecretsHTTP{repo: r} }
+
+func (h *SecretsHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	// после смены secrets.master_key (старый — в previous_keys) или пометки переменной секретной
+	api.HandleFunc("/secrets/rotate", h.rotate).Methods(http.MethodPost)
+}
+
+func (h *SecretsHTTP) rotate(w http.ResponseWriter, r *http.Request) {
+	n, err := h.repo.RotateSecrets()
+	if errors.Is(err, secrets.ErrNoKey) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{"rotated": n, "key_id": h.repo.keys.KeyID()})
+}
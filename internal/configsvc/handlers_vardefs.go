@@ -0,0 +1,139 @@
+package configsvc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"wisp/internal/configsvc/varschema"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type VarDefsHTTP struct{ repo *Repo }
+
+func NewVarDefsHTTP(r *Repo) *VarDefsHTTP { return &VarDefsHTTP{repo: r} }
+
+func (h *VarDefsHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// встроенный каталог + определения из БД
+	api.HandleFunc("/var-definitions", h.list).Methods(http.MethodGet)
+	api.HandleFunc("/var-definitions", h.create).Methods(http.MethodPost)
+	api.HandleFunc("/var-definitions/{key}", h.get).Methods(http.MethodGet)
+	api.HandleFunc("/var-definitions/{key}", h.put).Methods(http.MethodPut)
+	api.HandleFunc("/var-definitions/{key}", h.delete).Methods(http.MethodDelete)
+}
+
+// varDefOut — определение в API; у встроенных есть только базовые поля.
+type varDefOut struct {
+	varschema.Spec
+	Source string `json:"source"` // builtin | db
+}
+
+func builtinOut(d varschema.VarDef) varDefOut {
+	return varDefOut{
+		Spec: varschema.Spec{
+			Key:         d.Key,
+			Type:        d.Type,
+			Required:    d.Required,
+			Secret:      d.Secret,
+			Description: d.Description,
+			Example:     d.Example,
+		},
+		Source: "builtin",
+	}
+}
+
+func (h *VarDefsHTTP) list(w http.ResponseWriter, r *http.Request) {
+	specs, err := h.repo.ListVarDefinitions()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	out := make([]varDefOut, 0, len(varschema.Catalog)+len(specs))
+	for _, d := range varschema.Catalog {
+		out = append(out, builtinOut(d))
+	}
+	for _, s := range specs {
+		out = append(out, varDefOut{Spec: s, Source: "db"})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": out})
+}
+
+func (h *VarDefsHTTP) get(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if d, ok := varschema.Def(key); ok && !d.Custom {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(builtinOut(d))
+		return
+	}
+	s, err := h.repo.GetVarDefinition(key)
+	if err != nil {
+		writeVarDefErr(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(varDefOut{Spec: s, Source: "db"})
+}
+
+func (h *VarDefsHTTP) create(w http.ResponseWriter, r *http.Request) {
+	var in varschema.Spec
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	in.Key = strings.TrimSpace(in.Key)
+	if _, err := h.repo.GetVarDefinition(in.Key); err == nil {
+		http.Error(w, "definition already exists", http.StatusConflict)
+		return
+	}
+	h.save(w, in)
+}
+
+func (h *VarDefsHTTP) put(w http.ResponseWriter, r *http.Request) {
+	var in varschema.Spec
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	in.Key = mux.Vars(r)["key"]
+	h.save(w, in)
+}
+
+func (h *VarDefsHTTP) save(w http.ResponseWriter, in varschema.Spec) {
+	created, err := h.repo.SaveVarDefinition(in)
+	if err != nil {
+		writeVarDefErr(w, err)
+		return
+	}
+	s, _ := h.repo.GetVarDefinition(in.Key)
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	_ = json.NewEncoder(w).Encode(varDefOut{Spec: s, Source: "db"})
+}
+
+func (h *VarDefsHTTP) delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.repo.DeleteVarDefinition(mux.Vars(r)["key"]); err != nil {
+		writeVarDefErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeVarDefErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "not found", 404)
+	case errors.Is(err, ErrBuiltinVar):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidVarDef):
+		http.Error(w, err.Error(), 400)
+	default:
+		http.Error(w, err.Error(), 500)
+	}
+}
@@ -0,0 +1,78 @@
+package configsvc
+
+import (
+	"net/http"
+	"strconv"
+	"wisp/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// VarsReportHTTP — диагностика переменных: что нужно шаблонам устройства
+// и откуда взялось каждое итоговое значение.
+type VarsReportHTTP struct {
+	devices DeviceLookup
+	builder *Builder
+}
+
+func NewVarsReportHTTP(devices DeviceLookup, builder *Builder) *VarsReportHTTP {
+	return &VarsReportHTTP{devices: devices, builder: builder}
+}
+
+func (h *VarsReportHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/devices/{uuid}/vars/report", h.report).Methods(http.MethodGet)
+	// ?key=dns_servers&key=... — только эти ключи
+	api.HandleFunc("/devices/{uuid}/vars/effective", h.effective).Methods(http.MethodGet)
+	api.HandleFunc("/templates/{id}/vars", h.templateVars).Methods(http.MethodGet)
+}
+
+func (h *VarsReportHTTP) report(w http.ResponseWriter, r *http.Request) {
+	dev, ok := h.devices.FindByUUID(mux.Vars(r)["uuid"])
+	if !ok {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	rep, err := h.builder.VarsReport(dev)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, rep)
+}
+
+func (h *VarsReportHTTP) effective(w http.ResponseWriter, r *http.Request) {
+	dev, ok := h.devices.FindByUUID(mux.Vars(r)["uuid"])
+	if !ok {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	ev, err := h.builder.EffectiveVars(dev, r.URL.Query()["key"]...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, ev)
+}
+
+// templateVars — ссылки .vars.* одного шаблона (без привязки к устройству).
+func (h *VarsReportHTTP) templateVars(w http.ResponseWriter, r *http.Request) {
+	idU, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	id := uint(idU)
+	byID, err := h.builder.repo.GetTemplatesByIDs([]uint{id})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t, ok := byID[id]
+	if !ok {
+		http.Error(w, "template not found", http.StatusNotFound)
+		return
+	}
+	refs, err := TemplateVarRefs(t.Body)
+	if err != nil {
+		models.WriteProblem(w, http.StatusUnprocessableEntity, "Template parse failed", err.Error(), map[string]string{"name": t.Name})
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, TemplateRefs{ID: t.ID, Name: t.Name, Type: t.Type, Vars: refs})
+}
@@ -0,0 +1,533 @@
+// internal/configsvc/netjson/openwrt.go
+//
+// Нативная (без python netjsonconfig) реализация бэкенда OpenWrt:
+// NetJSON DeviceConfiguration → набор файлов etc/config/* (+ произвольные files).
+// Поддерживаются: general, ntp, led, interfaces, routes, dns_servers/dns_search,
+// radios, wireless-интерфейсы, files и «сырые» UCI-пакеты (config_name/config_value).
+package netjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// known — ключи DeviceConfiguration, которые обрабатываются явно.
+var known = map[string]bool{
+	"type": true, "general": true, "ntp": true, "led": true,
+	"interfaces": true, "routes": true,
+	"dns_servers": true, "dns_search": true,
+	"radios": true, "files": true,
+}
+
+// Render разбирает NetJSON и возвращает карту путь → содержимое и права
+// файлов из files[], у которых задан mode (по тем же путям).
+func Render(body []byte) (map[string]string, map[string]fs.FileMode, error) {
+	var cfg map[string]any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid netjson: %w", err)
+	}
+	if cfg == nil {
+		return nil, nil, errors.New("invalid netjson: expected object")
+	}
+	return RenderConfig(cfg)
+}
+
+// RenderConfig — то же для уже разобранного DeviceConfiguration.
+func RenderConfig(cfg map[string]any) (map[string]string, map[string]fs.FileMode, error) {
+	if t := str(cfg, "type"); t != "" && t != "DeviceConfiguration" {
+		return nil, nil, fmt.Errorf("unsupported netjson type: %s", t)
+	}
+
+	system := &pkg{name: "system"}
+	network := &pkg{name: "network"}
+	wireless := &pkg{name: "wireless"}
+
+	renderGeneral(system, cfg)
+	renderNTP(system, cfg)
+	if err := renderLEDs(system, cfg); err != nil {
+		return nil, nil, err
+	}
+	// wifi-device идут перед wifi-iface
+	if err := renderRadios(wireless, cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := renderInterfaces(network, wireless, cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := renderRoutes(network, cfg); err != nil {
+		return nil, nil, err
+	}
+	pkgs := []*pkg{system, network, wireless}
+	byName := map[string]*pkg{"system": system, "network": network, "wireless": wireless}
+
+	// «сырые» UCI-пакеты: { "firewall": [ {"config_name": "zone", "name": "lan", ...} ] }.
+	// С пакетами, сгенерированными выше, они сливаются, как в netjsonconfig:
+	// секция того же типа с тем же config_value дополняется (совпавшие опции
+	// перекрываются), остальные добавляются в конец пакета.
+	for _, key := range sortedKeys(cfg) {
+		if known[key] {
+			continue
+		}
+		blocks := objects(cfg[key])
+		if len(blocks) == 0 {
+			continue
+		}
+		p, ok := byName[key]
+		if !ok {
+			p = &pkg{name: key}
+			byName[key] = p
+			pkgs = append(pkgs, p)
+		}
+		for i, b := range blocks {
+			typ := str(b, "config_name")
+			if typ == "" {
+				return nil, nil, fmt.Errorf("%s[%d]: config_name required", key, i)
+			}
+			name := str(b, "config_value")
+			s := p.find(typ, name)
+			if s == nil {
+				s = p.add(typ, name)
+			}
+			for _, k := range sortedKeys(b) {
+				if k == "config_name" || k == "config_value" {
+					continue
+				}
+				s.del(k)
+				if l := strs(b[k]); isList(b[k]) {
+					s.list(k, l)
+				} else {
+					s.set(k, b[k])
+				}
+			}
+		}
+	}
+
+	out := map[string]string{}
+	for _, p := range pkgs {
+		if len(p.sections) > 0 {
+			out["etc/config/"+p.name] = p.String()
+		}
+	}
+
+	// files: [{ "path": "/etc/dropbear/authorized_keys", "mode": "0600", "contents": "..." }]
+	modes := map[string]fs.FileMode{}
+	for i, f := range objects(cfg["files"]) {
+		path := strings.TrimLeft(strings.TrimSpace(str(f, "path")), "/")
+		if path == "" {
+			return nil, nil, fmt.Errorf("files[%d]: path required", i)
+		}
+		out[path] = str(f, "contents")
+		if m := str(f, "mode"); m != "" {
+			v, err := strconv.ParseInt(m, 8, 64)
+			if err != nil || v < 0 || v > 0o7777 {
+				return nil, nil, fmt.Errorf("files[%d]: invalid mode %q (octal, e.g. \"0600\")", i, m)
+			}
+			modes[path] = fileMode(v)
+		} else {
+			delete(modes, path) // путь повторён без mode
+		}
+	}
+	return out, modes, nil
+}
+
+// fileMode — восьмеричный режим вида 04755 в fs.FileMode.
+func fileMode(v int64) fs.FileMode {
+	m := fs.FileMode(v & 0o777)
+	if v&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if v&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if v&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+func isList(v any) bool { _, ok := v.([]any); return ok }
+
+/* ——— general / ntp / led ——— */
+
+func renderGeneral(p *pkg, cfg map[string]any) {
+	g, _ := object(cfg["general"])
+	s := p.add("system", "system")
+	hostname := str(g, "hostname")
+	if hostname == "" {
+		hostname = "OpenWrt"
+	}
+	s.set("hostname", hostname)
+
+	zone := str(g, "timezone")
+	if zone == "" {
+		zone = "UTC"
+	}
+	s.set("timezone", posixTZ(zone))
+	s.set("zonename", zone)
+
+	for _, k := range sortedKeys(g) {
+		switch k {
+		case "hostname", "timezone":
+		default:
+			s.set(k, g[k])
+		}
+	}
+}
+
+func renderNTP(p *pkg, cfg map[string]any) {
+	n, ok := object(cfg["ntp"])
+	if !ok {
+		return
+	}
+	s := p.add("timeserver", "ntp")
+	s.set("enabled", boolean(n, "enabled", true))
+	s.set("enable_server", boolean(n, "enable_server", false))
+	s.list("server", strs(n["server"]))
+}
+
+func renderLEDs(p *pkg, cfg map[string]any) error {
+	for i, l := range objects(cfg["led"]) {
+		name := str(l, "name")
+		if name == "" {
+			return fmt.Errorf("led[%d]: name required", i)
+		}
+		s := p.add("led", "led_"+logicalName(name))
+		s.set("name", name)
+		for _, k := range sortedKeys(l) {
+			if k != "name" {
+				s.set(k, l[k])
+			}
+		}
+	}
+	return nil
+}
+
+/* ——— interfaces ——— */
+
+// logicalName — имя UCI-секции из имени интерфейса (eth0.1 → eth0_1).
+func logicalName(name string) string {
+	r := strings.NewReplacer(".", "_", "-", "_", ":", "_")
+	return r.Replace(name)
+}
+
+func renderInterfaces(nw, wl *pkg, cfg map[string]any) error {
+	dns := strs(cfg["dns_servers"])
+	search := strs(cfg["dns_search"])
+
+	for i, iface := range objects(cfg["interfaces"]) {
+		name := str(iface, "name")
+		if name == "" {
+			return fmt.Errorf("interfaces[%d]: name required", i)
+		}
+		typ := str(iface, "type")
+		logical := logicalName(name)
+		if v := str(iface, "network"); v != "" {
+			logical = logicalName(v)
+		}
+
+		addrs := objects(iface["addresses"])
+		if typ == "loopback" && len(addrs) == 0 {
+			addrs = []map[string]any{{"proto": "static", "family": "ipv4", "address": "127.0.0.1", "mask": float64(8)}}
+		}
+
+		// wireless без адресов и не в бридже — только wifi-iface
+		if typ == "wireless" {
+			if err := renderWifiIface(wl, iface, logical, len(addrs) > 0); err != nil {
+				return fmt.Errorf("interfaces[%d]: %w", i, err)
+			}
+			if len(addrs) == 0 {
+				continue
+			}
+		}
+
+		base := func(secName string, alias bool) *section {
+			s := nw.add("interface", secName)
+			switch {
+			case alias:
+				// дополнительные адреса — алиасы основной секции
+				s.set("ifname", "@"+logical)
+			case typ != "wireless":
+				if typ == "bridge" {
+					s.set("type", "bridge")
+					members := strs(iface["bridge_members"])
+					s.set("ifname", strings.Join(members, " "))
+				} else {
+					s.set("ifname", name)
+				}
+			}
+			if !boolean(iface, "autostart", true) {
+				s.set("auto", "0")
+			}
+			if boolean(iface, "disabled", false) {
+				s.set("enabled", "0")
+			}
+			if !alias {
+				s.set("mtu", iface["mtu"])
+				s.set("macaddr", iface["mac"])
+			}
+			return s
+		}
+
+		if len(addrs) == 0 {
+			s := base(logical, false)
+			s.set("proto", "none")
+			continue
+		}
+		for j, a := range addrs {
+			secName := logical
+			if j > 0 {
+				secName = fmt.Sprintf("%s_%d", logical, j+1)
+			}
+			s := base(secName, j > 0)
+			if err := renderAddress(s, a); err != nil {
+				return fmt.Errorf("interfaces[%d].addresses[%d]: %w", i, j, err)
+			}
+			if str(a, "proto") == "static" {
+				s.list("dns", dns)
+				s.list("dns_search", search)
+			}
+		}
+	}
+	return nil
+}
+
+func renderAddress(s *section, a map[string]any) error {
+	proto := str(a, "proto")
+	family := str(a, "family")
+	if family == "" {
+		family = "ipv4"
+	}
+	switch proto {
+	case "dhcp":
+		if family == "ipv6" {
+			s.set("proto", "dhcpv6")
+		} else {
+			s.set("proto", "dhcp")
+		}
+		return nil
+	case "static":
+	default:
+		return fmt.Errorf("unsupported proto %q", proto)
+	}
+
+	s.set("proto", "static")
+	addr := str(a, "address")
+	mask, ok := number(a, "mask")
+	if addr == "" || !ok {
+		return errors.New("static address requires address and mask")
+	}
+	switch family {
+	case "ipv4":
+		if net.ParseIP(addr).To4() == nil || mask < 0 || mask > 32 {
+			return fmt.Errorf("invalid ipv4 address %s/%d", addr, mask)
+		}
+		s.set("ipaddr", addr)
+		s.set("netmask", net.IP(net.CIDRMask(mask, 32)).String())
+		s.set("gateway", a["gateway"])
+	case "ipv6":
+		if ip := net.ParseIP(addr); ip == nil || ip.To4() != nil || mask < 0 || mask > 128 {
+			return fmt.Errorf("invalid ipv6 address %s/%d", addr, mask)
+		}
+		s.set("ip6addr", addr+"/"+strconv.Itoa(mask))
+		s.set("ip6gw", a["gateway"])
+	default:
+		return fmt.Errorf("unsupported family %q", family)
+	}
+	return nil
+}
+
+/* ——— routes ——— */
+
+func renderRoutes(p *pkg, cfg map[string]any) error {
+	n4, n6 := 0, 0
+	for i, r := range objects(cfg["routes"]) {
+		dst := str(r, "destination")
+		_, nw, err := net.ParseCIDR(dst)
+		if err != nil {
+			return fmt.Errorf("routes[%d]: invalid destination %q", i, dst)
+		}
+		ifname := logicalName(str(r, "device"))
+		if ifname == "" {
+			return fmt.Errorf("routes[%d]: device required", i)
+		}
+		var s *section
+		if nw.IP.To4() != nil {
+			n4++
+			s = p.add("route", fmt.Sprintf("route%d", n4))
+			s.set("interface", ifname)
+			s.set("target", nw.IP.String())
+			s.set("netmask", net.IP(nw.Mask).String())
+		} else {
+			n6++
+			s = p.add("route6", fmt.Sprintf("route6_%d", n6))
+			s.set("interface", ifname)
+			s.set("target", nw.String())
+		}
+		s.set("gateway", r["next"])
+		s.set("metric", r["cost"])
+		s.set("source", r["source"])
+	}
+	return nil
+}
+
+/* ——— wireless ——— */
+
+func renderRadios(p *pkg, cfg map[string]any) error {
+	for i, r := range objects(cfg["radios"]) {
+		name := str(r, "name")
+		if name == "" {
+			return fmt.Errorf("radios[%d]: name required", i)
+		}
+		s := p.add("wifi-device", name)
+		s.set("type", firstNonEmpty(str(r, "driver"), "mac80211"))
+
+		ch, _ := number(r, "channel")
+		if ch == 0 {
+			s.set("channel", "auto")
+		} else {
+			s.set("channel", ch)
+		}
+		band := str(r, "band")
+		if band == "" {
+			if ch == 0 || ch <= 14 {
+				band = "2g"
+			} else {
+				band = "5g"
+			}
+		}
+		s.set("band", band)
+		width, ok := number(r, "channel_width")
+		if !ok {
+			width = 20
+		}
+		s.set("htmode", htmode(str(r, "protocol"), width))
+		s.set("country", r["country"])
+		s.set("txpower", r["tx_power"])
+		s.set("phy", r["phy"])
+		if boolean(r, "disabled", false) {
+			s.set("disabled", "1")
+		}
+	}
+	return nil
+}
+
+func htmode(protocol string, width int) string {
+	switch protocol {
+	case "802.11ac":
+		return "VHT" + strconv.Itoa(width)
+	case "802.11ax":
+		return "HE" + strconv.Itoa(width)
+	case "802.11n":
+		return "HT" + strconv.Itoa(width)
+	default:
+		return "NOHT"
+	}
+}
+
+var wifiModes = map[string]string{
+	"access_point": "ap",
+	"station":      "sta",
+	"adhoc":        "adhoc",
+	"wds":          "wds",
+	"monitor":      "monitor",
+	"802.11s":      "mesh",
+}
+
+var encProtocols = map[string]string{
+	"none":                 "none",
+	"wep_open":             "wep-open",
+	"wep_shared":           "wep-shared",
+	"wpa_personal":         "psk",
+	"wpa2_personal":        "psk2",
+	"wpa_personal_mixed":   "psk-mixed",
+	"wpa3_personal":        "sae",
+	"wpa2_wpa3_personal":   "sae-mixed",
+	"wpa_enterprise":       "wpa",
+	"wpa2_enterprise":      "wpa2",
+	"wpa3_enterprise":      "wpa3",
+	"wpa_enterprise_mixed": "wpa-mixed",
+}
+
+func renderWifiIface(p *pkg, iface map[string]any, logical string, hasAddr bool) error {
+	w, ok := object(iface["wireless"])
+	if !ok {
+		return errors.New("wireless interface requires \"wireless\" object")
+	}
+	radio := str(w, "radio")
+	if radio == "" {
+		return errors.New("wireless.radio required")
+	}
+	mode := str(w, "mode")
+	uciMode, ok := wifiModes[firstNonEmpty(mode, "access_point")]
+	if !ok {
+		return fmt.Errorf("unsupported wireless mode %q", mode)
+	}
+
+	s := p.add("wifi-iface", "wifi_"+logical)
+	s.set("device", radio)
+	s.set("ifname", str(iface, "name"))
+	s.set("mode", uciMode)
+
+	// в какие сети подключён интерфейс: явный список или собственная (если есть адреса)
+	nets := strs(w["network"])
+	for i := range nets {
+		nets[i] = logicalName(nets[i])
+	}
+	if len(nets) == 0 && hasAddr {
+		nets = []string{logical}
+	}
+	s.set("network", strings.Join(nets, " "))
+
+	if uciMode == "mesh" {
+		s.set("mesh_id", w["mesh_id"])
+	} else {
+		s.set("ssid", w["ssid"])
+	}
+	s.set("bssid", w["bssid"])
+	if boolean(w, "hidden", false) {
+		s.set("hidden", "1")
+	}
+	if boolean(iface, "disabled", false) {
+		s.set("disabled", "1")
+	}
+	s.set("wds", w["wds"])
+	s.set("isolate", w["isolate"])
+
+	enc, _ := object(w["encryption"])
+	proto := firstNonEmpty(str(enc, "protocol"), "none")
+	uciEnc, ok := encProtocols[proto]
+	if !ok {
+		return fmt.Errorf("unsupported encryption protocol %q", proto)
+	}
+	if c := str(enc, "cipher"); c != "" && c != "auto" && uciEnc != "none" {
+		uciEnc += "+" + c
+	}
+	s.set("encryption", uciEnc)
+	if uciEnc != "none" {
+		s.set("key", enc["key"])
+	}
+	if strings.HasPrefix(proto, "wpa") && strings.Contains(proto, "enterprise") {
+		s.set("server", enc["server"])
+		s.set("port", enc["port"])
+		s.set("identity", enc["identity"])
+		s.set("password", enc["password"])
+	}
+	s.set("ieee80211w", enc["ieee80211w"])
+	return nil
+}
+
+func firstNonEmpty(vs ...string) string {
+	for _, v := range vs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
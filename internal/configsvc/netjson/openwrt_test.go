@@ -0,0 +1,329 @@
+package netjson
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+const systemDefault = `package system
+
+config system 'system'
+	option hostname 'OpenWrt'
+	option timezone 'UTC0'
+	option zonename 'UTC'
+`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		files map[string]string // ожидаемые файлы целиком (остальные не проверяются)
+		modes map[string]fs.FileMode
+		count int // ожидаемое число файлов
+	}{
+		{
+			name: "empty config — only system",
+			in:   `{"type": "DeviceConfiguration"}`,
+			files: map[string]string{
+				"etc/config/system": systemDefault,
+			},
+			count: 1,
+		},
+		{
+			name: "general and ntp",
+			in: `{"general": {"hostname": "r1", "timezone": "Europe/Berlin", "description": "lab"},
+			      "ntp": {"enabled": true, "server": ["0.pool.ntp.org", "1.pool.ntp.org"]}}`,
+			files: map[string]string{
+				"etc/config/system": `package system
+
+config system 'system'
+	option hostname 'r1'
+	option timezone 'CET-1CEST,M3.5.0,M10.5.0/3'
+	option zonename 'Europe/Berlin'
+	option description 'lab'
+
+config timeserver 'ntp'
+	option enabled '1'
+	option enable_server '0'
+	list server '0.pool.ntp.org'
+	list server '1.pool.ntp.org'
+`,
+			},
+			count: 1,
+		},
+		{
+			name: "interfaces: static, aliases, loopback, bridge",
+			in: `{"interfaces": [
+			        {"name": "eth0", "type": "ethernet", "mtu": 1500, "addresses": [
+			            {"proto": "static", "family": "ipv4", "address": "192.168.1.1", "mask": 24, "gateway": "192.168.1.254"},
+			            {"proto": "dhcp", "family": "ipv6"}]},
+			        {"name": "lo", "type": "loopback"},
+			        {"name": "br-lan", "network": "lan", "type": "bridge", "bridge_members": ["eth1", "eth2"],
+			         "addresses": [{"proto": "static", "family": "ipv6", "address": "fd00::1", "mask": 64}]},
+			        {"name": "eth3", "type": "ethernet", "autostart": false}],
+			      "dns_servers": ["1.1.1.1"], "dns_search": ["lan"]}`,
+			files: map[string]string{
+				"etc/config/network": `package network
+
+config interface 'eth0'
+	option ifname 'eth0'
+	option mtu '1500'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+	option gateway '192.168.1.254'
+	list dns '1.1.1.1'
+	list dns_search 'lan'
+
+config interface 'eth0_2'
+	option ifname '@eth0'
+	option proto 'dhcpv6'
+
+config interface 'lo'
+	option ifname 'lo'
+	option proto 'static'
+	option ipaddr '127.0.0.1'
+	option netmask '255.0.0.0'
+	list dns '1.1.1.1'
+	list dns_search 'lan'
+
+config interface 'lan'
+	option type 'bridge'
+	option ifname 'eth1 eth2'
+	option proto 'static'
+	option ip6addr 'fd00::1/64'
+	list dns '1.1.1.1'
+	list dns_search 'lan'
+
+config interface 'eth3'
+	option ifname 'eth3'
+	option auto '0'
+	option proto 'none'
+`,
+			},
+			count: 2,
+		},
+		{
+			name: "routes",
+			in: `{"routes": [
+			        {"device": "eth0", "destination": "10.0.0.0/8", "next": "192.168.1.254", "cost": 10},
+			        {"device": "eth0", "destination": "fd10::/48", "next": "fd00::fe"}]}`,
+			files: map[string]string{
+				"etc/config/network": `package network
+
+config route 'route1'
+	option interface 'eth0'
+	option target '10.0.0.0'
+	option netmask '255.0.0.0'
+	option gateway '192.168.1.254'
+	option metric '10'
+
+config route6 'route6_1'
+	option interface 'eth0'
+	option target 'fd10::/48'
+	option gateway 'fd00::fe'
+`,
+			},
+			count: 2,
+		},
+		{
+			name: "wireless: radio and access point",
+			in: `{"radios": [{"name": "radio0", "protocol": "802.11ac", "channel": 36, "channel_width": 80, "country": "DE"}],
+			      "interfaces": [{"name": "wlan0", "type": "wireless", "wireless": {
+			          "radio": "radio0", "mode": "access_point", "ssid": "office", "network": ["lan"], "hidden": true,
+			          "encryption": {"protocol": "wpa2_personal", "key": "secret123", "cipher": "ccmp"}}}]}`,
+			files: map[string]string{
+				"etc/config/wireless": `package wireless
+
+config wifi-device 'radio0'
+	option type 'mac80211'
+	option channel '36'
+	option band '5g'
+	option htmode 'VHT80'
+	option country 'DE'
+
+config wifi-iface 'wifi_wlan0'
+	option device 'radio0'
+	option ifname 'wlan0'
+	option mode 'ap'
+	option network 'lan'
+	option ssid 'office'
+	option hidden '1'
+	option encryption 'psk2+ccmp'
+	option key 'secret123'
+`,
+			},
+			count: 2,
+		},
+		{
+			name: "wireless with addresses gets its own network",
+			in: `{"radios": [{"name": "radio0", "channel": 0}],
+			      "interfaces": [{"name": "wlan0", "type": "wireless",
+			          "wireless": {"radio": "radio0", "mode": "station", "ssid": "uplink"},
+			          "addresses": [{"proto": "dhcp", "family": "ipv4"}]}]}`,
+			files: map[string]string{
+				"etc/config/wireless": `package wireless
+
+config wifi-device 'radio0'
+	option type 'mac80211'
+	option channel 'auto'
+	option band '2g'
+	option htmode 'NOHT'
+
+config wifi-iface 'wifi_wlan0'
+	option device 'radio0'
+	option ifname 'wlan0'
+	option mode 'sta'
+	option network 'wlan0'
+	option ssid 'uplink'
+	option encryption 'none'
+`,
+				"etc/config/network": `package network
+
+config interface 'wlan0'
+	option proto 'dhcp'
+`,
+			},
+			count: 3,
+		},
+		{
+			name: "raw package merges into generated one",
+			in: `{"general": {"hostname": "r1"},
+			      "interfaces": [{"name": "eth0", "type": "ethernet", "addresses": [{"proto": "dhcp", "family": "ipv4"}]}],
+			      "network": [
+			          {"config_name": "interface", "config_value": "eth0", "proto": "static", "mtu": 1400},
+			          {"config_name": "globals", "config_value": "globals", "ula_prefix": "fd00::/48"}],
+			      "system": [{"config_name": "system", "config_value": "system", "hostname": "override", "log_size": 64}]}`,
+			files: map[string]string{
+				"etc/config/network": `package network
+
+config interface 'eth0'
+	option ifname 'eth0'
+	option mtu '1400'
+	option proto 'static'
+
+config globals 'globals'
+	option ula_prefix 'fd00::/48'
+`,
+				"etc/config/system": `package system
+
+config system 'system'
+	option timezone 'UTC0'
+	option zonename 'UTC'
+	option hostname 'override'
+	option log_size '64'
+`,
+			},
+			count: 2,
+		},
+		{
+			name: "raw package of its own, lists and anonymous sections",
+			in: `{"firewall": [
+			        {"config_name": "defaults", "syn_flood": true, "input": "ACCEPT"},
+			        {"config_name": "zone", "config_value": "lan", "name": "lan", "network": ["lan", "guest"]},
+			        {"config_name": "zone", "config_value": "lan", "input": "REJECT"}]}`,
+			files: map[string]string{
+				"etc/config/firewall": `package firewall
+
+config defaults
+	option input 'ACCEPT'
+	option syn_flood '1'
+
+config zone 'lan'
+	option name 'lan'
+	list network 'lan'
+	list network 'guest'
+	option input 'REJECT'
+`,
+			},
+			count: 2,
+		},
+		{
+			name: "files with mode",
+			in: `{"files": [
+			        {"path": "/etc/dropbear/authorized_keys", "mode": "0600", "contents": "ssh-ed25519 AAA\n"},
+			        {"path": "etc/rc.local", "mode": "0755", "contents": "exit 0\n"},
+			        {"path": "etc/motd", "contents": "hi\n"}]}`,
+			files: map[string]string{
+				"etc/dropbear/authorized_keys": "ssh-ed25519 AAA\n",
+				"etc/rc.local":                 "exit 0\n",
+				"etc/motd":                     "hi\n",
+			},
+			modes: map[string]fs.FileMode{"etc/dropbear/authorized_keys": 0o600, "etc/rc.local": 0o755},
+			count: 4,
+		},
+		{
+			name: "files — repeated path keeps the last mode",
+			in: `{"files": [{"path": "/usr/bin/x", "mode": "0755", "contents": "a"},
+			        {"path": "/usr/bin/x", "contents": "b"},
+			        {"path": "/usr/bin/y", "mode": "4755", "contents": "c"}]}`,
+			files: map[string]string{"usr/bin/x": "b"},
+			modes: map[string]fs.FileMode{"usr/bin/y": fs.ModeSetuid | 0o755},
+			count: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, modes, err := Render([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if len(files) != tt.count {
+				t.Errorf("got %d files, want %d: %v", len(files), tt.count, keys(files))
+			}
+			for path, want := range tt.files {
+				got, ok := files[path]
+				if !ok {
+					t.Errorf("missing %s (have %v)", path, keys(files))
+					continue
+				}
+				if got != want {
+					t.Errorf("%s:\n--- got\n%s--- want\n%s", path, got, want)
+				}
+			}
+			if len(modes) != len(tt.modes) {
+				t.Errorf("modes = %v, want %v", modes, tt.modes)
+			}
+			for path, want := range tt.modes {
+				if modes[path] != want {
+					t.Errorf("mode %s = %o, want %o", path, modes[path], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"not an object", `[1, 2]`, "invalid netjson"},
+		{"wrong type", `{"type": "DeviceMonitoring"}`, "unsupported netjson type"},
+		{"interface without name", `{"interfaces": [{"type": "ethernet"}]}`, "interfaces[0]: name required"},
+		{"bad proto", `{"interfaces": [{"name": "eth0", "addresses": [{"proto": "ppp"}]}]}`, `unsupported proto "ppp"`},
+		{"bad ipv4", `{"interfaces": [{"name": "eth0", "addresses": [{"proto": "static", "address": "999.1.1.1", "mask": 24}]}]}`, "invalid ipv4 address"},
+		{"wireless without radio", `{"interfaces": [{"name": "wlan0", "type": "wireless", "wireless": {"ssid": "x"}}]}`, "wireless.radio required"},
+		{"bad encryption", `{"interfaces": [{"name": "wlan0", "type": "wireless", "wireless": {"radio": "radio0", "encryption": {"protocol": "rot13"}}}]}`, "unsupported encryption protocol"},
+		{"bad route", `{"routes": [{"device": "eth0", "destination": "10.0.0.0"}]}`, "invalid destination"},
+		{"raw without config_name", `{"firewall": [{"name": "lan"}]}`, "firewall[0]: config_name required"},
+		{"file without path", `{"files": [{"contents": "x"}]}`, "files[0]: path required"},
+		{"bad file mode", `{"files": [{"path": "etc/x", "mode": "rwx"}]}`, "files[0]: invalid mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Render([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func keys(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+// internal/configsvc/netjson/tz.go
+package netjson
+
+// posixZones — POSIX TZ-строки для распространённых зон (как в netjsonconfig/timezones).
+// OpenWrt использует option timezone (POSIX) и option zonename (Olson) одновременно.
+var posixZones = map[string]string{
+	"UTC":                 "UTC0",
+	"Etc/UTC":             "UTC0",
+	"GMT":                 "GMT0",
+	"Europe/London":       "GMT0BST,M3.5.0/1,M10.5.0",
+	"Europe/Dublin":       "IST-1GMT0,M10.5.0,M3.5.0/1",
+	"Europe/Lisbon":       "WET0WEST,M3.5.0/1,M10.5.0",
+	"Europe/Rome":         "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Berlin":       "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Paris":        "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Madrid":       "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Amsterdam":    "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Vienna":       "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Warsaw":       "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Prague":       "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Stockholm":    "CET-1CEST,M3.5.0,M10.5.0/3",
+	"Europe/Helsinki":     "EET-2EEST,M3.5.0/3,M10.5.0/4",
+	"Europe/Kiev":         "EET-2EEST,M3.5.0/3,M10.5.0/4",
+	"Europe/Kyiv":         "EET-2EEST,M3.5.0/3,M10.5.0/4",
+	"Europe/Athens":       "EET-2EEST,M3.5.0/3,M10.5.0/4",
+	"Europe/Istanbul":     "<+03>-3",
+	"Europe/Minsk":        "<+03>-3",
+	"Europe/Moscow":       "MSK-3",
+	"Asia/Dubai":          "<+04>-4",
+	"Asia/Tashkent":       "<+05>-5",
+	"Asia/Almaty":         "<+05>-5",
+	"Asia/Kolkata":        "IST-5:30",
+	"Asia/Novosibirsk":    "<+07>-7",
+	"Asia/Bangkok":        "<+07>-7",
+	"Asia/Shanghai":       "CST-8",
+	"Asia/Singapore":      "<+08>-8",
+	"Asia/Tokyo":          "JST-9",
+	"Asia/Vladivostok":    "<+10>-10",
+	"Australia/Sydney":    "AEST-10AEDT,M10.1.0,M4.1.0/3",
+	"America/New_York":    "EST5EDT,M3.2.0,M11.1.0",
+	"America/Chicago":     "CST6CDT,M3.2.0,M11.1.0",
+	"America/Denver":      "MST7MDT,M3.2.0,M11.1.0",
+	"America/Los_Angeles": "PST8PDT,M3.2.0,M11.1.0",
+	"America/Sao_Paulo":   "<-03>3",
+}
+
+// posixTZ — POSIX-строка для Olson-зоны; неизвестные зоны отдаём как UTC0
+// (zonename всё равно сохраняется, и на устройстве с zoneinfo она применится).
+func posixTZ(zone string) string {
+	if tz, ok := posixZones[zone]; ok {
+		return tz
+	}
+	return "UTC0"
+}
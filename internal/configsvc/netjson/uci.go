@@ -0,0 +1,199 @@
+// internal/configsvc/netjson/uci.go
+package netjson
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* ——— минимальная модель UCI ——— */
+
+type option struct {
+	key    string
+	values []string
+	list   bool
+}
+
+// section — один блок "config <type> '<name>'".
+type section struct {
+	typ  string
+	name string
+	opts []option
+}
+
+func (s *section) set(key string, v any) {
+	str, ok := scalar(v)
+	if !ok || str == "" {
+		return
+	}
+	s.opts = append(s.opts, option{key: key, values: []string{str}})
+}
+
+func (s *section) list(key string, vs []string) {
+	if len(vs) == 0 {
+		return
+	}
+	s.opts = append(s.opts, option{key: key, values: vs, list: true})
+}
+
+// del — убирает опцию/список key (перед перекрытием значения).
+func (s *section) del(key string) {
+	opts := s.opts[:0]
+	for _, o := range s.opts {
+		if o.key != key {
+			opts = append(opts, o)
+		}
+	}
+	s.opts = opts
+}
+
+// pkg — содержимое одного файла etc/config/<name>.
+type pkg struct {
+	name     string
+	sections []*section
+}
+
+func (p *pkg) add(typ, name string) *section {
+	s := &section{typ: typ, name: name}
+	p.sections = append(p.sections, s)
+	return s
+}
+
+// find — именованная секция typ/name (nil для анонимных и отсутствующих).
+func (p *pkg) find(typ, name string) *section {
+	if name == "" {
+		return nil
+	}
+	for _, s := range p.sections {
+		if s.typ == typ && s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (p *pkg) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "package %s\n", p.name)
+	for _, s := range p.sections {
+		b.WriteString("\n")
+		if s.name != "" {
+			fmt.Fprintf(&b, "config %s %s\n", s.typ, quote(s.name))
+		} else {
+			fmt.Fprintf(&b, "config %s\n", s.typ)
+		}
+		for _, o := range s.opts {
+			kw := "option"
+			if o.list {
+				kw = "list"
+			}
+			for _, v := range o.values {
+				fmt.Fprintf(&b, "\t%s %s %s\n", kw, o.key, quote(v))
+			}
+		}
+	}
+	return b.String()
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+/* ——— приведение JSON-значений ——— */
+
+// scalar — строковое представление JSON-значения в терминах UCI (bool → 1/0).
+func scalar(v any) (string, bool) {
+	switch x := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return x, true
+	case bool:
+		if x {
+			return "1", true
+		}
+		return "0", true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(x), true
+	}
+	return "", false
+}
+
+func str(m map[string]any, key string) string {
+	s, _ := scalar(m[key])
+	return s
+}
+
+func boolean(m map[string]any, key string, def bool) bool {
+	switch x := m[key].(type) {
+	case bool:
+		return x
+	case string:
+		switch strings.ToLower(x) {
+		case "1", "true", "yes", "on":
+			return true
+		case "0", "false", "no", "off":
+			return false
+		}
+	case float64:
+		return x != 0
+	}
+	return def
+}
+
+func number(m map[string]any, key string) (int, bool) {
+	switch x := m[key].(type) {
+	case float64:
+		return int(x), true
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(x))
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func object(v any) (map[string]any, bool) {
+	m, ok := v.(map[string]any)
+	return m, ok
+}
+
+func objects(v any) []map[string]any {
+	arr, _ := v.([]any)
+	out := make([]map[string]any, 0, len(arr))
+	for _, it := range arr {
+		if m, ok := it.(map[string]any); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func strs(v any) []string {
+	switch x := v.(type) {
+	case []any:
+		out := make([]string, 0, len(x))
+		for _, it := range x {
+			if s, ok := scalar(it); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	case string:
+		return strings.Fields(x)
+	}
+	return nil
+}
+
+// sortedKeys — детерминированный обход произвольных map.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -4,75 +4,55 @@ package configsvc
 import (
 	"bytes"
 	"fmt"
-	"os"
-	"os/exec"
+	"io/fs"
 	"strings"
 	"text/template"
+	"wisp/internal/configsvc/netjson"
 	"wisp/internal/models"
 )
 
-// TemplateRenderer — общий интерфейс рендеринга.
+// TemplateRenderer — рендер одного шаблона. data — общие данные сборки
+// ({device, vars, groups, facts}, см. Builder.render).
 type TemplateRenderer interface {
-	// Построить финальные файлы для устройства (с учётом порядка).
-	RenderAll(deviceUUID string, vars map[string]string) (map[string]string, error)
-	// Построить файлы по одному шаблону (может вернуть несколько путей для netjson).
-	RenderOneFiles(t models.Template, vars map[string]string) (map[string]string, error)
+	// Построить файлы по одному шаблону (может вернуть несколько путей для netjson)
+	// и права тех из них, что отличны от 0644.
+	RenderOneFiles(t models.Template, data map[string]any) (map[string]string, map[string]fs.FileMode, error)
 }
 
 // NewTemplateRenderer возвращает композитный рендерер (go + netjson).
-func NewTemplateRenderer(r *Repo) TemplateRenderer {
+func NewTemplateRenderer() TemplateRenderer {
 	return &compositeRenderer{
-		repo: r,
-		goR:  &goRenderer{},
-		njR:  &netjsonRenderer{bin: os.Getenv("NETJSONCONFIG_BIN")},
+		goR: &goRenderer{},
+		njR: &netjsonRenderer{},
 	}
 }
 
 /* ───────────────────────── composite ───────────────────────── */
 
 type compositeRenderer struct {
-	repo *Repo
-	goR  *goRenderer
-	njR  *netjsonRenderer
+	goR *goRenderer
+	njR *netjsonRenderer
 }
 
-func (c *compositeRenderer) RenderAll(deviceUUID string, vars map[string]string) (map[string]string, error) {
-	tpls, err := c.repo.ResolvedTemplatesForDevice(deviceUUID)
-	if err != nil {
-		return nil, fmt.Errorf("resolve templates: %w", err)
-	}
-	out := make(map[string]string, len(tpls))
-	for _, t := range tpls {
-		m, err := c.RenderOneFiles(t, vars)
-		if err != nil {
-			return nil, fmt.Errorf("template %d (%s): %w", t.ID, t.Name, err)
-		}
-		for p, v := range m {
-			out[p] = v // более поздний перезапишет предыдущий путь
-		}
-	}
-	return out, nil
-}
-
-func (c *compositeRenderer) RenderOneFiles(t models.Template, vars map[string]string) (map[string]string, error) {
+func (c *compositeRenderer) RenderOneFiles(t models.Template, data map[string]any) (map[string]string, map[string]fs.FileMode, error) {
 	tt := strings.ToLower(strings.TrimSpace(t.Type))
 	switch tt {
 	case "", "go":
-		s, err := c.goR.render(t.Body, map[string]any{"vars": vars})
+		s, err := c.goR.render(t.Body, data)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		path := strings.TrimLeft(strings.TrimSpace(t.Path), "/")
 		if path == "" {
-			return nil, fmt.Errorf("go-template %d has empty path", t.ID)
+			return nil, nil, fmt.Errorf("go-template %d has empty path", t.ID)
 		}
-		return map[string]string{path: s}, nil
+		return map[string]string{path: s}, nil, nil
 
 	case "netjson":
-		return c.njR.render(t, vars)
+		return c.njR.render(t, data)
 
 	default:
-		return nil, fmt.Errorf("unknown template type: %s", t.Type)
+		return nil, nil, fmt.Errorf("unknown template type: %s", t.Type)
 	}
 }
 
@@ -94,41 +74,22 @@ func (g *goRenderer) render(body string, data any) (string, error) {
 	return buf.String(), nil
 }
 
-/* ───────────────────────── netjson renderer (минимально) ─────────────────────────
-   В проде лучше вызывать netjsonconfig и распаковывать вывод в набор файлов.
-   Здесь: 1) подставим vars в тело как go-template; 2) если бинарь недоступен — вернём один файл по t.Path.
+/* ───────────────────────── netjson renderer ─────────────────────────
+   1) подставляем vars как go-template (NetJSON часто содержит плейсхолдеры {{ .vars.* }}, {{ .facts.* }});
+   2) нативно конвертируем DeviceConfiguration в etc/config/* (пакет netjson, бэкенд OpenWrt).
 */
 
-type netjsonRenderer struct{ bin string }
+type netjsonRenderer struct{}
 
-func (n *netjsonRenderer) render(t models.Template, vars map[string]string) (map[string]string, error) {
-	// сначала подставим vars как go-template (часто NetJSON содержит плейсхолдеры {{ .vars.* }})
+func (n *netjsonRenderer) render(t models.Template, data map[string]any) (map[string]string, map[string]fs.FileMode, error) {
 	gr := &goRenderer{}
-	netjsonBody, err := gr.render(t.Body, map[string]any{"vars": vars})
+	netjsonBody, err := gr.render(t.Body, data)
 	if err != nil {
-		return nil, fmt.Errorf("netjson preprocess: %w", err)
+		return nil, nil, fmt.Errorf("netjson preprocess: %w", err)
 	}
-
-	// если netjsonconfig не настроен — отдадим один файл в t.Path (достаточно для system/простых кейсов)
-	bin := n.bin
-	if bin == "" {
-		bin = "netjsonconfig"
-	}
-	if _, err := exec.LookPath(bin); err != nil || strings.TrimSpace(t.Path) != "" {
-		path := strings.TrimLeft(strings.TrimSpace(t.Path), "/")
-		if path == "" {
-			return nil, fmt.Errorf("netjson: no binary and empty path; set template.path or install netjsonconfig")
-		}
-		return map[string]string{path: netjsonBody}, nil
+	files, modes, err := netjson.Render([]byte(netjsonBody))
+	if err != nil {
+		return nil, nil, fmt.Errorf("netjson: %w", err)
 	}
-
-	// TODO: полный пайплайн через netjsonconfig (опционально)
-	// Пример идеи:
-	//   cmd := exec.Command(bin, "--backend", "openwrt", "--method", "generate")
-	//   cmd.Stdin = strings.NewReader(netjsonBody)
-	//   out, err := cmd.Output()
-	//   // out -> распарсить/распаковать в map[path]content
-	// Пока опустим.
-
-	return nil, fmt.Errorf("netjson renderer is not fully configured")
+	return files, modes, nil
 }
@@ -3,14 +3,40 @@ package configsvc
 import (
 	"errors"
 	"wisp/internal/models"
+	"wisp/internal/secrets"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type Repo struct{ db *gorm.DB }
+type Repo struct {
+	db   *gorm.DB
+	keys *secrets.Keyring // nil — секретные переменные хранятся открыто
+	// secret — какие ключи секретные; nil — по реестру varschema (импорт бандла
+	// подставляет определения из бандла, пока они не закоммичены)
+	secret func(key string) bool
+}
 
 func NewRepo(db *gorm.DB) *Repo { return &Repo{db: db} }
 
+// WithSecrets включает шифрование секретных переменных (varschema Secret) в БД.
+func (r *Repo) WithSecrets(k *secrets.Keyring) *Repo {
+	r.keys = k
+	return r
+}
+
+// orderAsc — "ORDER BY order ASC, id ASC" с экранированием зарезервированного
+// имени колонки под текущий диалект (MySQL/Postgres/SQLite).
+var orderAsc = clause.OrderBy{Columns: []clause.OrderByColumn{
+	{Column: clause.Column{Name: "order"}},
+	{Column: clause.Column{Name: "id"}},
+}}
+
+// colEq — условие по колонке с зарезервированным именем (`default`, `order`).
+func colEq(name string, v any) clause.Eq {
+	return clause.Eq{Column: clause.Column{Name: name}, Value: v}
+}
+
 // ── Templates CRUD ───────────────────────────────────────────
 
 func (r *Repo) CreateTemplate(t *models.Template) error { return r.db.Create(t).Error }
@@ -32,6 +58,10 @@ func (r *Repo) ListTemplates() ([]models.Template, error) {
 // ── Device variables ────────────────────────────────────────
 
 func (r *Repo) UpsertDeviceVar(uuid, key, value string) error {
+	value, err := r.sealVar(key, value)
+	if err != nil {
+		return err
+	}
 	var dv models.DeviceVariable
 	tx := r.db.Where(&models.DeviceVariable{DeviceUUID: uuid, VarKey: key}).First(&dv)
 	if tx.Error != nil {
@@ -45,6 +75,31 @@ func (r *Repo) UpsertDeviceVar(uuid, key, value string) error {
 	return r.db.Save(&dv).Error
 }
 
+// UpsertDeviceVars — несколько переменных устройства одной транзакцией.
+func (r *Repo) UpsertDeviceVars(uuid string, vars map[string]string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		t := &Repo{db: tx, keys: r.keys}
+		for k, v := range vars {
+			if err := t.UpsertDeviceVar(uuid, k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteDeviceVar — gorm.ErrRecordNotFound, если переменной нет.
+func (r *Repo) DeleteDeviceVar(uuid, key string) error {
+	res := r.db.Unscoped().Where("device_uuid = ? AND var_key = ?", uuid, key).Delete(&models.DeviceVariable{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (r *Repo) GetDeviceVars(uuid string) (map[string]string, error) {
 	var list []models.DeviceVariable
 	if err := r.db.Where(&models.DeviceVariable{DeviceUUID: uuid}).
@@ -56,7 +111,7 @@ func (r *Repo) GetDeviceVars(uuid string) (map[string]string, error) {
 	for _, v := range list {
 		out[v.VarKey] = v.Value
 	}
-	return out, nil
+	return out, r.openVars(out)
 }
 
 // ── Assignments ────────────────────────────────────────────
@@ -66,7 +121,7 @@ func (r *Repo) ListAssignments(uuid string) ([]models.DeviceTemplateAssignment,
 	var as []models.DeviceTemplateAssignment
 	err := r.db.
 		Where("device_uuid = ? AND enabled = ?", uuid, true).
-		Order("`order` ASC, id ASC").
+		Order(orderAsc).
 		Find(&as).Error
 	return as, err
 }
@@ -107,6 +162,12 @@ func (r *Repo) AssignTemplate(uuid string, templateID uint, enabled bool) error
 	return r.db.Save(&a).Error
 }
 
+// UnassignTemplate снимает назначение шаблона с устройства (в отличие от enabled=false).
+func (r *Repo) UnassignTemplate(uuid string, templateID uint) error {
+	return r.db.Where("device_uuid = ? AND template_id = ?", uuid, templateID).
+		Delete(&models.DeviceTemplateAssignment{}).Error
+}
+
 func (r *Repo) TemplatesByIDs(ids []uint) ([]models.Template, error) {
 	if len(ids) == 0 {
 		return []models.Template{}, nil
@@ -129,8 +190,8 @@ func (r *Repo) ListGroupTemplates(groupIDs []uint) ([]models.GroupTemplateAssign
 	}
 	var as []models.GroupTemplateAssignment
 	err := r.db.
-		Where("enabled = 1 AND group_id IN ?", groupIDs).
-		Order("`order` ASC, id ASC").
+		Where("enabled = ? AND group_id IN ?", true, groupIDs).
+		Order(orderAsc).
 		Find(&as).Error
 	return as, err
 }
@@ -0,0 +1,123 @@
+package configsvc
+
+import (
+	"errors"
+	"sort"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ── Global variables ────────────────────────────────────────
+
+// GetGlobalVars — переменные из таблицы; без БД (in-memory режим) — пусто,
+// действуют только global_vars конфига.
+func (r *Repo) GetGlobalVars() (map[string]string, error) {
+	if r.db == nil {
+		return map[string]string{}, nil
+	}
+	var list []models.GlobalVariable
+	if err := r.db.Order("var_key").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(list))
+	for _, v := range list {
+		out[v.VarKey] = v.Value
+	}
+	return out, r.openVars(out)
+}
+
+func (r *Repo) UpsertGlobalVar(key, value string) error {
+	value, err := r.sealVar(key, value)
+	if err != nil {
+		return err
+	}
+	var gv models.GlobalVariable
+	tx := r.db.Where("var_key = ?", key).First(&gv)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			gv = models.GlobalVariable{VarKey: key, Value: value}
+			return r.db.Create(&gv).Error
+		}
+		return tx.Error
+	}
+	gv.Value = value
+	return r.db.Save(&gv).Error
+}
+
+// DeleteGlobalVar удаляет физически (уникальный индекс по var_key); gorm.ErrRecordNotFound, если нет.
+func (r *Repo) DeleteGlobalVar(key string) error {
+	res := r.db.Unscoped().Where("var_key = ?", key).Delete(&models.GlobalVariable{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Globals — глобальные переменные: global_vars из конфига + таблица global_variables
+// (БД перекрывает конфиг). Реализует GlobalVarsProvider.
+type Globals struct {
+	repo *Repo
+	base map[string]string
+}
+
+func NewGlobals(repo *Repo, base map[string]string) *Globals {
+	cp := make(map[string]string, len(base))
+	for k, v := range base {
+		cp[k] = v
+	}
+	return &Globals{repo: repo, base: cp}
+}
+
+// GlobalVar — значение с источником (config | db).
+type GlobalVar struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	Overrides bool   `json:"overrides,omitempty"` // db-значение перекрывает config
+}
+
+func (g *Globals) GlobalVars() (map[string]string, error) {
+	db, err := g.repo.GetGlobalVars()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(g.base)+len(db))
+	for k, v := range g.base {
+		out[k] = v
+	}
+	for k, v := range db {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// List — действующие глобальные переменные с источником, по ключу (секреты замаскированы).
+func (g *Globals) List() ([]GlobalVar, error) {
+	db, err := g.repo.GetGlobalVars()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]GlobalVar, 0, len(g.base)+len(db))
+	for k, v := range db {
+		_, over := g.base[k]
+		out = append(out, GlobalVar{Key: k, Value: varschema.Mask(k, v), Source: "db", Overrides: over})
+	}
+	for k, v := range g.base {
+		if _, ok := db[k]; !ok {
+			out = append(out, GlobalVar{Key: k, Value: varschema.Mask(k, v), Source: "config"})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// InConfig — задана ли переменная в global_vars конфига.
+func (g *Globals) InConfig(key string) bool {
+	_, ok := g.base[key]
+	return ok
+}
@@ -2,7 +2,6 @@ package configsvc
 
 import (
 	"errors"
-	"sort"
 	"strings"
 	"wisp/internal/models"
 
@@ -10,7 +9,60 @@ import (
 )
 
 // ── Groups CRUD ─────────────────────────────────────────────
-func (r *Repo) DeleteGroup(id uint) error { return r.db.Delete(&models.Group{}, id).Error }
+
+// DeleteGroup удаляет группу физически (имя снова свободно) вместе с членством,
+// переменными, назначениями шаблонов, правилами и привязками IPAM-префиксов
+// (сами префиксы остаются выделенными — освобождаются через IPAM).
+// Дочерние группы поднимаются на уровень выше — к родителю удаляемой.
+func (r *Repo) DeleteGroup(id uint) error {
+	g, err := r.GetGroup(id)
+	if err != nil {
+		return err
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Group{}).Where("parent_id = ?", id).
+			Update("parent_id", g.ParentID).Error; err != nil {
+			return err
+		}
+		for _, m := range []any{
+			&models.DeviceGroup{},
+			&models.GroupVariable{},
+			&models.GroupTemplateAssignment{},
+			&models.GroupRule{},
+			&models.GroupPrefix{}, // уникальный индекс по prefix_id — только физически
+		} {
+			if err := tx.Unscoped().Where("group_id = ?", id).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&models.Group{}, id).Error
+	})
+}
+
+// SetGroupPriority — см. models.Group.Priority.
+func (r *Repo) SetGroupPriority(id uint, prio int) error {
+	return r.db.Model(&models.Group{}).Where("id = ?", id).Update("priority", prio).Error
+}
+
+// RenameGroup — ErrDuplicate, если имя занято другой группой.
+func (r *Repo) RenameGroup(id uint, name string) error {
+	var n int64
+	if err := r.db.Model(&models.Group{}).Where("name = ? AND id <> ?", name, id).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrDuplicate
+	}
+	res := r.db.Model(&models.Group{}).Where("id = ?", id).Update("name", name)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (r *Repo) ListGroups() ([]models.Group, error) {
 	var out []models.Group
 	err := r.db.Order("id").Find(&out).Error
@@ -37,6 +89,15 @@ func isDuplicateErr(err error) bool {
 }
 
 func (r *Repo) CreateGroup(g *models.Group) error {
+	if g.ParentID != nil {
+		idx, err := r.groupIndex()
+		if err != nil {
+			return err
+		}
+		if err := checkParent(idx, 0, *g.ParentID); err != nil {
+			return err
+		}
+	}
 	if err := r.db.Create(g).Error; err != nil {
 		if isDuplicateErr(err) {
 			var ex models.Group
@@ -60,20 +121,65 @@ func (r *Repo) AddDeviceToGroup(uuid string, groupID uint) (models.DeviceGroup,
 		}
 		return models.DeviceGroup{}, false, tx.Error
 	}
+	if link.RuleID != nil {
+		// было вычислено правилом — теперь закреплено вручную
+		link.RuleID = nil
+		if err := r.db.Model(&link).Update("rule_id", nil).Error; err != nil {
+			return models.DeviceGroup{}, false, err
+		}
+	}
 	return link, false, nil
 }
 
+// GetDeviceGroups — прямые группы устройства в порядке применения (поздние перекрывают
+// ранние): priority ASC, при равном priority основная группа последней, затем id ASC.
 func (r *Repo) GetDeviceGroups(uuid string) ([]models.Group, error) {
 	var gs []models.Group
 	err := r.db.
 		Table("groups").
 		Joins("JOIN device_groups dg ON dg.group_id = groups.id AND dg.deleted_at IS NULL").
-		Where("dg.device_uuid = ?", uuid).
-		Order("groups.id").
+		Where("dg.device_uuid = ? AND groups.deleted_at IS NULL", uuid).
+		Order("groups.priority, dg.is_primary, groups.id").
 		Find(&gs).Error
 	return gs, err
 }
 
+// PrimaryGroupID — основная группа устройства; 0, если не задана.
+func (r *Repo) PrimaryGroupID(uuid string) (uint, error) {
+	var link models.DeviceGroup
+	err := r.db.Where("device_uuid = ? AND is_primary = ?", uuid, true).Order("id").First(&link).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return link.GroupID, err
+}
+
+// SetPrimaryGroup делает группу основной (у остальных членств флаг снимается);
+// groupID == 0 — снять основную. gorm.ErrRecordNotFound — устройство не в группе.
+func (r *Repo) SetPrimaryGroup(uuid string, groupID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if groupID != 0 {
+			var n int64
+			if err := tx.Model(&models.DeviceGroup{}).
+				Where("device_uuid = ? AND group_id = ?", uuid, groupID).Count(&n).Error; err != nil {
+				return err
+			}
+			if n == 0 {
+				return gorm.ErrRecordNotFound
+			}
+		}
+		if err := tx.Model(&models.DeviceGroup{}).Where("device_uuid = ?", uuid).
+			Update("is_primary", false).Error; err != nil {
+			return err
+		}
+		if groupID == 0 {
+			return nil
+		}
+		return tx.Model(&models.DeviceGroup{}).Where("device_uuid = ? AND group_id = ?", uuid, groupID).
+			Update("is_primary", true).Error
+	})
+}
+
 func (r *Repo) GroupExists(id uint) bool {
 	var g models.Group
 	return r.db.Select("id").First(&g, id).Error == nil
@@ -82,6 +188,10 @@ func (r *Repo) GroupExists(id uint) bool {
 // ── Group variables ─────────────────────────────────────────
 
 func (r *Repo) UpsertGroupVar(groupID uint, key, value string) error {
+	value, err := r.sealVar(key, value)
+	if err != nil {
+		return err
+	}
 	var gv models.GroupVariable
 	tx := r.db.Where(&models.GroupVariable{GroupID: groupID, VarKey: key}).First(&gv)
 	if tx.Error != nil {
@@ -95,19 +205,53 @@ func (r *Repo) UpsertGroupVar(groupID uint, key, value string) error {
 	return r.db.Save(&gv).Error
 }
 
+// DeleteGroupVar — gorm.ErrRecordNotFound, если переменной нет.
+func (r *Repo) DeleteGroupVar(groupID uint, key string) error {
+	res := r.db.Unscoped().Where("group_id = ? AND var_key = ?", groupID, key).Delete(&models.GroupVariable{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (r *Repo) GetGroupVars(groupIDs []uint) (map[string]string, error) {
+	byGroup, err := r.GetGroupVarsByGroup(groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	// мердж в порядке groupIDs (порядок применения, см. ExpandGroups): поздние перекрывают ранние
+	for _, id := range groupIDs {
+		for k, v := range byGroup[id] {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+// GetGroupVarsByGroup — переменные каждой группы отдельно (для provenance).
+func (r *Repo) GetGroupVarsByGroup(groupIDs []uint) (map[uint]map[string]string, error) {
+	out := map[uint]map[string]string{}
 	if len(groupIDs) == 0 {
-		return map[string]string{}, nil
+		return out, nil
 	}
 	var list []models.GroupVariable
 	if err := r.db.Where("group_id IN ?", groupIDs).Find(&list).Error; err != nil {
 		return nil, err
 	}
-	out := map[string]string{}
-	// детерминированный мердж по group_id
-	sort.Slice(list, func(i, j int) bool { return list[i].GroupID < list[j].GroupID })
 	for _, v := range list {
-		out[v.VarKey] = v.Value
+		if out[v.GroupID] == nil {
+			out[v.GroupID] = map[string]string{}
+		}
+		out[v.GroupID][v.VarKey] = v.Value
+	}
+	for _, m := range out {
+		if err := r.openVars(m); err != nil {
+			return nil, err
+		}
 	}
 	return out, nil
 }
@@ -138,9 +282,65 @@ func (r *Repo) AssignTemplateToGroup(groupID, tplID uint, order int, enabled boo
 	return r.db.Create(&as).Error
 }
 
+// ListGroupTemplateAssignments — все назначения группы, включая выключенные (order,id ASC).
+func (r *Repo) ListGroupTemplateAssignments(groupID uint) ([]models.GroupTemplateAssignment, error) {
+	var out []models.GroupTemplateAssignment
+	err := r.db.Where("group_id = ?", groupID).Order(orderAsc).Find(&out).Error
+	return out, err
+}
+
+// UpdateGroupAssignment — включить/выключить и/или сменить порядок (nil — не трогать).
+func (r *Repo) UpdateGroupAssignment(groupID, tplID uint, enabled *bool, order *int) error {
+	upd := map[string]any{}
+	if enabled != nil {
+		upd["enabled"] = *enabled
+	}
+	if order != nil {
+		upd["order"] = *order
+	}
+	var a models.GroupTemplateAssignment
+	if err := r.db.Where("group_id = ? AND template_id = ?", groupID, tplID).First(&a).Error; err != nil {
+		return err
+	}
+	if len(upd) == 0 {
+		return nil
+	}
+	return r.db.Model(&a).Updates(upd).Error
+}
+
+// UnassignTemplateFromGroup — gorm.ErrRecordNotFound, если назначения нет.
+func (r *Repo) UnassignTemplateFromGroup(groupID, tplID uint) error {
+	res := r.db.Unscoped().Where("group_id = ? AND template_id = ?", groupID, tplID).
+		Delete(&models.GroupTemplateAssignment{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ReorderGroupTemplates — как ReorderDeviceTemplates, для назначений группы.
+func (r *Repo) ReorderGroupTemplates(groupID uint, items []ReorderItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, it := range items {
+			if err := tx.Model(&models.GroupTemplateAssignment{}).
+				Where("group_id = ? AND template_id = ?", groupID, it.ID).
+				Update("order", it.Order).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (r *Repo) ListGroupAssignments(groupID uint) ([]models.GroupTemplateAssignment, error) {
 	var out []models.GroupTemplateAssignment
-	err := r.db.Where("group_id = ? AND enabled = TRUE", groupID).Order("id").Find(&out).Error
+	err := r.db.Where("group_id = ? AND enabled = ?", groupID, true).Order("id").Find(&out).Error
 	return out, err
 }
 
@@ -150,7 +350,7 @@ func (r *Repo) TemplatesByGroupIDs(groupIDs []uint) ([]models.Template, error) {
 	}
 	// Соберём все template_id из включённых привязок
 	var gas []models.GroupTemplateAssignment
-	if err := r.db.Where("group_id IN ? AND enabled = TRUE", groupIDs).Find(&gas).Error; err != nil {
+	if err := r.db.Where("group_id IN ? AND enabled = ?", groupIDs, true).Find(&gas).Error; err != nil {
 		return nil, err
 	}
 	if len(gas) == 0 {
@@ -0,0 +1,162 @@
+package configsvc
+
+import (
+	"errors"
+	"fmt"
+	"wisp/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ── Group tree ──────────────────────────────────────────────
+// Устройство состоит в группах напрямую; действующие группы — прямые плюс все их
+// предки. Порядок применения (поздние перекрывают ранние): прямые группы в порядке
+// GetDeviceGroups (priority, основная, id), каждая раскрыта от корня к себе,
+// уже встреченные предки не повторяются.
+// Так общий регион всегда раньше своих площадок, площадка — раньше этажа.
+
+var (
+	ErrGroupParent = errors.New("parent group not found")
+	ErrGroupCycle  = errors.New("group cannot be its own ancestor")
+	ErrGroupDepth  = fmt.Errorf("group tree deeper than %d levels", models.MaxGroupDepth)
+)
+
+// groupIndex — все (неудалённые) группы по id.
+func (r *Repo) groupIndex() (map[uint]models.Group, error) {
+	gs, err := r.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	idx := make(map[uint]models.Group, len(gs))
+	for _, g := range gs {
+		idx[g.ID] = g
+	}
+	return idx, nil
+}
+
+// lineage — цепочка от корня до id включительно; обрывается на удалённом
+// родителе, цикле (битые данные) или MaxGroupDepth.
+func lineage(idx map[uint]models.Group, id uint) []models.Group {
+	var rev []models.Group
+	seen := map[uint]bool{}
+	for cur, ok := idx[id]; ok && !seen[cur.ID] && len(rev) < models.MaxGroupDepth; {
+		seen[cur.ID] = true
+		rev = append(rev, cur)
+		if cur.ParentID == nil {
+			break
+		}
+		cur, ok = idx[*cur.ParentID]
+	}
+	out := make([]models.Group, len(rev))
+	for i, g := range rev {
+		out[len(rev)-1-i] = g
+	}
+	return out
+}
+
+// ExpandGroups — прямые группы (в порядке применения) → действующие с предками, в порядке применения.
+func (r *Repo) ExpandGroups(direct []models.Group) ([]models.Group, error) {
+	if len(direct) == 0 {
+		return direct, nil
+	}
+	idx, err := r.groupIndex()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Group, 0, len(direct))
+	seen := map[uint]bool{}
+	for _, d := range direct {
+		chain := lineage(idx, d.ID)
+		if len(chain) == 0 {
+			chain = []models.Group{d}
+		}
+		for _, g := range chain {
+			if !seen[g.ID] {
+				seen[g.ID] = true
+				out = append(out, g)
+			}
+		}
+	}
+	return out, nil
+}
+
+// EffectiveDeviceGroups — группы устройства вместе с унаследованными.
+func (r *Repo) EffectiveDeviceGroups(uuid string) ([]models.Group, error) {
+	direct, err := r.GetDeviceGroups(uuid)
+	if err != nil {
+		return nil, err
+	}
+	return r.ExpandGroups(direct)
+}
+
+// EffectiveGroupIDs — id действующих групп устройства в порядке применения.
+func (r *Repo) EffectiveGroupIDs(uuid string) ([]uint, error) {
+	gs, err := r.EffectiveDeviceGroups(uuid)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(gs))
+	for _, g := range gs {
+		ids = append(ids, g.ID)
+	}
+	return ids, nil
+}
+
+// checkParent — можно ли сделать parent родителем id (id == 0 — новая группа).
+func checkParent(idx map[uint]models.Group, id, parent uint) error {
+	if _, ok := idx[parent]; !ok {
+		return ErrGroupParent
+	}
+	up := lineage(idx, parent)
+	for _, g := range up {
+		if g.ID == id {
+			return ErrGroupCycle
+		}
+	}
+	if len(up)+subtreeHeight(idx, id) > models.MaxGroupDepth {
+		return ErrGroupDepth
+	}
+	return nil
+}
+
+// subtreeHeight — число уровней поддерева с корнем id (1 — лист; 1 для новой группы).
+func subtreeHeight(idx map[uint]models.Group, id uint) int {
+	children := map[uint][]uint{}
+	for _, g := range idx {
+		if g.ParentID != nil {
+			children[*g.ParentID] = append(children[*g.ParentID], g.ID)
+		}
+	}
+	var walk func(id uint, depth int) int
+	walk = func(id uint, depth int) int {
+		h := depth
+		if depth > models.MaxGroupDepth {
+			return h // цикл в данных — дальше не идём
+		}
+		for _, c := range children[id] {
+			h = max(h, walk(c, depth+1))
+		}
+		return h
+	}
+	if id == 0 {
+		return 1
+	}
+	return walk(id, 1)
+}
+
+// SetGroupParent — перенести группу в дереве; parent == nil — сделать корневой.
+func (r *Repo) SetGroupParent(id uint, parent *uint) error {
+	idx, err := r.groupIndex()
+	if err != nil {
+		return err
+	}
+	if _, ok := idx[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	if parent != nil {
+		if err := checkParent(idx, id, *parent); err != nil {
+			return err
+		}
+	}
+	return r.db.Model(&models.Group{}).Where("id = ?", id).Update("parent_id", parent).Error
+}
@@ -0,0 +1,115 @@
+package configsvc
+
+import (
+	"errors"
+	"fmt"
+	"wisp/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ── Template revisions ──────────────────────────────────────
+
+// RevisionMeta — кто и зачем сохранил шаблон.
+type RevisionMeta struct {
+	Author  string
+	Message string
+}
+
+var ErrNoChanges = errors.New("no changes")
+
+// addRevision — снимок текущего состояния шаблона следующим номером.
+func addRevision(tx *gorm.DB, t *models.Template, meta RevisionMeta) (*models.TemplateRevision, error) {
+	var last models.TemplateRevision
+	next := 1
+	err := tx.Where("template_id = ?", t.ID).Order("revision DESC").First(&last).Error
+	switch {
+	case err == nil:
+		next = last.Revision + 1
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	rev := &models.TemplateRevision{
+		TemplateID: t.ID,
+		Revision:   next,
+		Name:       t.Name,
+		Path:       t.Path,
+		Body:       t.Body,
+		Type:       t.Type,
+		Author:     meta.Author,
+		Message:    meta.Message,
+	}
+	return rev, tx.Create(rev).Error
+}
+
+// CreateTemplateRev — создать шаблон и его ревизию 1.
+func (r *Repo) CreateTemplateRev(t *models.Template, meta RevisionMeta) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(t).Error; err != nil {
+			return err
+		}
+		_, err := addRevision(tx, t, meta)
+		return err
+	})
+}
+
+// UpdateTemplateRev — сохранить шаблон и записать новую ревизию.
+func (r *Repo) UpdateTemplateRev(t *models.Template, meta RevisionMeta) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(t).Error; err != nil {
+			return err
+		}
+		_, err := addRevision(tx, t, meta)
+		return err
+	})
+}
+
+// ListTemplateRevisions — ревизии шаблона, новые сверху.
+func (r *Repo) ListTemplateRevisions(templateID uint) ([]models.TemplateRevision, error) {
+	var out []models.TemplateRevision
+	err := r.db.Where("template_id = ?", templateID).Order("revision DESC").Find(&out).Error
+	return out, err
+}
+
+// GetTemplateRevision — ревизия N шаблона (gorm.ErrRecordNotFound, если нет).
+func (r *Repo) GetTemplateRevision(templateID uint, rev int) (*models.TemplateRevision, error) {
+	var out models.TemplateRevision
+	if err := r.db.Where("template_id = ? AND revision = ?", templateID, rev).First(&out).Error; err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RestoreTemplateRevision — вернуть шаблон к ревизии N; восстановление само становится новой ревизией.
+func (r *Repo) RestoreTemplateRevision(templateID uint, rev int, meta RevisionMeta) (*models.Template, *models.TemplateRevision, error) {
+	var (
+		t   models.Template
+		out *models.TemplateRevision
+	)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&t, templateID).Error; err != nil {
+			return err
+		}
+		var src models.TemplateRevision
+		if err := tx.Where("template_id = ? AND revision = ?", templateID, rev).First(&src).Error; err != nil {
+			return err
+		}
+		if t.Name == src.Name && t.Path == src.Path && t.Body == src.Body && t.Type == src.Type {
+			return ErrNoChanges
+		}
+		t.Name, t.Path, t.Body, t.Type = src.Name, src.Path, src.Body, src.Type
+		if err := tx.Save(&t).Error; err != nil {
+			return err
+		}
+		if meta.Message == "" {
+			meta.Message = fmt.Sprintf("restore revision %d", rev)
+		}
+		var err error
+		out, err = addRevision(tx, &t, meta)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &t, out, nil
+}
@@ -0,0 +1,96 @@
+package configsvc
+
+import (
+	"fmt"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+	"wisp/internal/secrets"
+
+	"gorm.io/gorm"
+)
+
+// ── Secret variables ────────────────────────────────────────
+// Значения секретных переменных (varschema Secret) шифруются при записи
+// в device/group/global_variables и расшифровываются в Get*Vars, т.е. в
+// конвейер сборки попадают уже открытыми. Маскирование — дело API (varschema.Mask).
+
+// isSecret — varschema.IsSecret или подменённое правило (см. Repo.secret).
+func (r *Repo) isSecret(key string) bool {
+	if r.secret != nil {
+		return r.secret(key)
+	}
+	return varschema.IsSecret(key)
+}
+
+// sealVar шифрует значение секретной переменной; без ключа — как есть.
+func (r *Repo) sealVar(key, value string) (string, error) {
+	if r.keys == nil || value == "" || !r.isSecret(key) {
+		return value, nil
+	}
+	return r.keys.Encrypt(value)
+}
+
+// openVars расшифровывает значения на месте.
+func (r *Repo) openVars(m map[string]string) error {
+	for k, v := range m {
+		if !secrets.IsEncrypted(v) {
+			continue
+		}
+		if r.keys == nil {
+			return fmt.Errorf("var %s: %w", k, secrets.ErrNoKey)
+		}
+		plain, err := r.keys.Decrypt(v)
+		if err != nil {
+			return fmt.Errorf("var %s: %w", k, err)
+		}
+		m[k] = plain
+	}
+	return nil
+}
+
+// RotateSecrets перешифровывает текущим ключом всё, что зашифровано старыми
+// ключами (secrets.previous_keys), и шифрует секреты, лежащие открыто (например,
+// переменная стала секретной после записи). Возвращает число изменённых строк.
+func (r *Repo) RotateSecrets() (int, error) {
+	if r.keys == nil {
+		return 0, secrets.ErrNoKey
+	}
+	n := 0
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range []any{&models.DeviceVariable{}, &models.GroupVariable{}, &models.GlobalVariable{}} {
+			var rows []struct {
+				ID     uint
+				VarKey string
+				Value  string
+			}
+			if err := tx.Model(m).Select("id", "var_key", "value").Find(&rows).Error; err != nil {
+				return err
+			}
+			for _, row := range rows {
+				if row.Value == "" || r.keys.Current(row.Value) {
+					continue
+				}
+				if !secrets.IsEncrypted(row.Value) && !r.isSecret(row.VarKey) {
+					continue
+				}
+				plain, err := r.keys.Decrypt(row.Value)
+				if err != nil {
+					return fmt.Errorf("var %s (id %d): %w", row.VarKey, row.ID, err)
+				}
+				sealed, err := r.keys.Encrypt(plain)
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(m).Where("id = ?", row.ID).Update("value", sealed).Error; err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,147 @@
+package configsvc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ── Variable definitions (runtime-расширение varschema) ─────
+
+var (
+	ErrBuiltinVar    = errors.New("built-in variable definition cannot be changed")
+	ErrInvalidVarDef = errors.New("invalid definition")
+)
+
+func specFromModel(m models.VarDefinition) varschema.Spec {
+	s := varschema.Spec{
+		Key:         m.VarKey,
+		Type:        varschema.VarType(m.Type),
+		Pattern:     m.Pattern,
+		Min:         m.Min,
+		Max:         m.Max,
+		Required:    m.Required,
+		Secret:      m.Secret,
+		RequiredIf:  m.RequiredIf,
+		Description: m.Description,
+		Example:     m.Example,
+	}
+	if m.Enum != "" {
+		_ = json.Unmarshal([]byte(m.Enum), &s.Enum)
+	}
+	return s
+}
+
+func (r *Repo) ListVarDefinitions() ([]varschema.Spec, error) {
+	var rows []models.VarDefinition
+	if err := r.db.Order("var_key").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]varschema.Spec, 0, len(rows))
+	for _, m := range rows {
+		out = append(out, specFromModel(m))
+	}
+	return out, nil
+}
+
+// GetVarDefinition — gorm.ErrRecordNotFound, если нет.
+func (r *Repo) GetVarDefinition(key string) (varschema.Spec, error) {
+	var m models.VarDefinition
+	if err := r.db.Where("var_key = ?", key).First(&m).Error; err != nil {
+		return varschema.Spec{}, err
+	}
+	return specFromModel(m), nil
+}
+
+// SaveVarDefinition — создать или заменить определение; Spec проверяется до записи,
+// после записи реестр varschema перечитывается. created=true, если определения не было.
+func (r *Repo) SaveVarDefinition(s varschema.Spec) (created bool, err error) {
+	if created, err = r.writeVarDefinition(s); err != nil {
+		return false, err
+	}
+	if err := r.ReloadVarSchema(); err != nil {
+		return created, err
+	}
+	if s.Secret && r.keys != nil {
+		// уже сохранённые значения ставшей секретной переменной — зашифровать
+		_, err = r.RotateSecrets()
+	}
+	return created, err
+}
+
+// writeVarDefinition — только запись в БД, без перечитывания реестра (импорт
+// бандла перечитывает его после коммита).
+func (r *Repo) writeVarDefinition(s varschema.Spec) (created bool, err error) {
+	if varschema.IsBuiltin(s.Key) {
+		return false, ErrBuiltinVar
+	}
+	if s.Type == "" {
+		s.Type = varschema.TString
+	}
+	if _, err := varschema.Compile(s); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidVarDef, err)
+	}
+	enum := ""
+	if len(s.Enum) > 0 {
+		b, _ := json.Marshal(s.Enum)
+		enum = string(b)
+	}
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var m models.VarDefinition
+		e := tx.Where("var_key = ?", s.Key).First(&m).Error
+		switch {
+		case errors.Is(e, gorm.ErrRecordNotFound):
+			created = true
+			m = models.VarDefinition{VarKey: s.Key}
+		case e != nil:
+			return e
+		}
+		m.Type, m.Pattern, m.Min, m.Max, m.Enum = string(s.Type), s.Pattern, s.Min, s.Max, enum
+		m.Required, m.RequiredIf, m.Description, m.Example = s.Required, s.RequiredIf, s.Description, s.Example
+		m.Secret = s.Secret
+		return tx.Save(&m).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// DeleteVarDefinition — удаляет определение (значения переменных не трогает).
+func (r *Repo) DeleteVarDefinition(key string) error {
+	if err := r.deleteVarDefinition(key); err != nil {
+		return err
+	}
+	return r.ReloadVarSchema()
+}
+
+func (r *Repo) deleteVarDefinition(key string) error {
+	if varschema.IsBuiltin(key) {
+		return ErrBuiltinVar
+	}
+	res := r.db.Unscoped().Where("var_key = ?", key).Delete(&models.VarDefinition{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ReloadVarSchema — загрузить пользовательские определения в varschema
+// (при старте и после каждого изменения через API). Без БД — только встроенный каталог.
+func (r *Repo) ReloadVarSchema() error {
+	if r.db == nil {
+		return varschema.SetCustom(nil)
+	}
+	specs, err := r.ListVarDefinitions()
+	if err != nil {
+		return err
+	}
+	return varschema.SetCustom(specs)
+}
@@ -2,88 +2,118 @@
 package configsvc
 
 import (
-	"slices"
+	"sort"
 	"wisp/internal/models"
 )
 
 // ResolvedTemplatesForDevice возвращает шаблоны в порядке применения:
-// 1) group-assignments (order,id ASC) для групп устройства
-// 2) device-assignments  (order,id ASC) — перекрывают group по одинаковым путям
-// 3) исключаются заблокированные (DeviceTemplateBlock)
-func (r *Repo) ResolvedTemplatesForDevice(uuid string) ([]models.Template, error) {
-	// блокировки
-	var blocks []models.DeviceTemplateBlock
-	_ = r.db.Where("device_uuid = ?", uuid).Find(&blocks).Error
-	blocked := map[uint]struct{}{}
-	for _, b := range blocks {
-		blocked[b.TemplateID] = struct{}{}
+//  1. required — всегда, блокировка на них не действует
+//  2. default  — всем устройствам, если не заблокирован
+//  3. group-assignments для групп gids: по позиции группы в gids (предки раньше
+//     потомков, см. ExpandGroups), внутри группы — order,id ASC
+//  4. device-assignments (order,id ASC) — перекрывают предыдущие по одинаковым путям
+//
+// Заблокированные (DeviceTemplateBlock) исключаются; каждый шаблон входит один раз,
+// на первой позиции.
+func (r *Repo) ResolvedTemplatesForDevice(uuid string, gids []uint) ([]models.Template, error) {
+	rs, err := r.ResolvedTemplatesWithSource(uuid, gids)
+	if err != nil {
+		return nil, err
 	}
+	out := make([]models.Template, 0, len(rs))
+	for _, rt := range rs {
+		out = append(out, rt.Template)
+	}
+	return out, nil
+}
 
-	// device assignments
-	var das []models.DeviceTemplateAssignment
-	_ = r.db.
-		Where("device_uuid = ? AND enabled = 1", uuid).
-		Order("`order` ASC, id ASC").
-		Find(&das).Error
+// ResolvedTemplate — шаблон сборки и откуда он взят.
+type ResolvedTemplate struct {
+	Source   string          `json:"source"` // required|default|group|device
+	Order    int             `json:"order"`  // order назначения; 0 у required/default
+	Template models.Template `json:"template"`
+}
 
-	// group assignments
-	gids, _ := r.GetGroupIDs(uuid)
-	var gas []models.GroupTemplateAssignment
-	if len(gids) > 0 {
-		_ = r.db.
-			Where("group_id IN ? AND enabled = 1", gids).
-			Order("`order` ASC, id ASC").
-			Find(&gas).Error
+// ResolvedTemplatesWithSource — как ResolvedTemplatesForDevice, с источником каждого шаблона.
+func (r *Repo) ResolvedTemplatesWithSource(uuid string, gids []uint) ([]ResolvedTemplate, error) {
+	blocked, err := r.ListDeviceTemplateBlocks(uuid)
+	if err != nil {
+		return nil, err
+	}
+	req, err := r.ListRequiredTemplates()
+	if err != nil {
+		return nil, err
+	}
+	defs, err := r.ListDefaultTemplates()
+	if err != nil {
+		return nil, err
+	}
+	gas, err := r.ListGroupTemplates(gids)
+	if err != nil {
+		return nil, err
+	}
+	sortByGroupPosition(gas, gids)
+	das, err := r.ListAssignments(uuid)
+	if err != nil {
+		return nil, err
 	}
 
-	// соберём итоговый список templateID: сначала группы, затем устройство
-	orderIDs := make([]uint, 0, len(gas)+len(das))
+	var out []ResolvedTemplate
 	seen := map[uint]struct{}{}
-
-	for _, a := range gas {
-		if _, off := blocked[a.TemplateID]; off {
-			continue
+	push := func(t models.Template, source string, order int) {
+		if _, ok := seen[t.ID]; ok {
+			return
 		}
-		if _, ok := seen[a.TemplateID]; !ok {
-			seen[a.TemplateID] = struct{}{}
-			orderIDs = append(orderIDs, a.TemplateID)
+		seen[t.ID] = struct{}{}
+		out = append(out, ResolvedTemplate{Source: source, Order: order, Template: t})
+	}
+
+	for _, t := range req {
+		push(t, "required", 0)
+	}
+	for _, t := range defs {
+		if _, off := blocked[t.ID]; !off {
+			push(t, "default", 0)
 		}
 	}
+
+	// назначения: сначала группы, затем устройство
+	type assigned struct {
+		id     uint
+		source string
+		order  int
+	}
+	as := make([]assigned, 0, len(gas)+len(das))
+	ids := make([]uint, 0, len(gas)+len(das))
+	for _, a := range gas {
+		as = append(as, assigned{a.TemplateID, "group", a.Order})
+		ids = append(ids, a.TemplateID)
+	}
 	for _, a := range das {
-		if _, off := blocked[a.TemplateID]; off {
+		as = append(as, assigned{a.TemplateID, "device", a.Order})
+		ids = append(ids, a.TemplateID)
+	}
+	byID, err := r.GetTemplatesByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range as {
+		if _, off := blocked[a.id]; off {
 			continue
 		}
-		if _, ok := seen[a.TemplateID]; !ok {
-			seen[a.TemplateID] = struct{}{}
-			orderIDs = append(orderIDs, a.TemplateID)
+		if t, ok := byID[a.id]; ok {
+			push(t, a.source, a.order)
 		}
 	}
+	return out, nil
+}
 
-	// загрузим шаблоны в сохранённом порядке
-	if len(orderIDs) == 0 {
-		return nil, nil
-	}
-	var ts []models.Template
-	_ = r.db.Where("id IN ?", orderIDs).Find(&ts).Error
-	// восстановим порядок как в orderIDs
-	slices.SortFunc(ts, func(a, b models.Template) int {
-		ia, ib := -1, -1
-		for i, id := range orderIDs {
-			if id == a.ID {
-				ia = i
-			}
-			if id == b.ID {
-				ib = i
-			}
-		}
-		switch {
-		case ia < ib:
-			return -1
-		case ia > ib:
-			return 1
-		default:
-			return 0
-		}
-	})
-	return ts, nil
+// sortByGroupPosition — устойчиво упорядочивает назначения по позиции группы в gids
+// (исходный порядок order,id внутри группы сохраняется).
+func sortByGroupPosition(gas []models.GroupTemplateAssignment, gids []uint) {
+	pos := make(map[uint]int, len(gids))
+	for i, id := range gids {
+		pos[id] = i
+	}
+	sort.SliceStable(gas, func(i, j int) bool { return pos[gas[i].GroupID] < pos[gas[j].GroupID] })
 }
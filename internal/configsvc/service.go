@@ -1,126 +1,461 @@
 package configsvc
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"net"
+	"io/fs"
 	"sort"
-	"text/template"
+	"strings"
+	"sync"
+	"wisp/internal/configsvc/varschema"
 	"wisp/internal/ipam"
+	"wisp/internal/logs"
 	"wisp/internal/models"
 	"wisp/internal/owctrl"
 )
 
+// Builder — единственный конвейер сборки конфигурации устройства.
+// Им пользуются и контроллер (/controller/download-config), и админские
+// ручки (render preview, config-diff), поэтому результат везде одинаков.
+//
+// Стадии (по порядку):
+//
+//  1. context — устройство и его группы с предками (BuildContext).
+//  2. vars — слои VarsLayer по порядку, поздние перекрывают ранние:
+//     global → ipam → group (по priority, от корня дерева к листу; равные
+//     priority — см. varConflicts) → device; затем overrides предпросмотра и
+//     предопределённые поля устройства (id, key, name, mac_address,
+//     hostname по умолчанию = имя устройства).
+//  3. validate — известные varschema ключи нормализуются, обязательные
+//     (в т.ч. условно) проверяются. По умолчанию ошибка только пишется в лог
+//     и сборка идёт дальше с неверными значениями как есть; WithStrictVars —
+//     ошибка валидации = ошибка сборки.
+//  4. templates — required → default → group → device, минус блокировки
+//     (Repo.ResolvedTemplatesForDevice), затем overrides.
+//  5. render — TemplateRenderer по каждому шаблону (go | netjson) с общими
+//     данными {device, vars, groups, facts}; поздние файлы перекрывают
+//     ранние по пути.
+//  6. finalize — fallback etc/config/system, если шаблонов нет, и служебные
+//     файлы etc/openwisp/*.
 type Builder struct {
-	repo *Repo
-	ipam *ipam.Repo       // опционально
-	tpl  TemplateRenderer // опционально, если не задан — будет создан дефолтный рендерер
+	repo   *Repo
+	tpl    TemplateRenderer
+	layers []VarsLayer   // в порядке приоритета (последний — главный)
+	facts  FactsProvider // опционально, данные для {{ .facts.* }}
+	strict bool          // ошибки валидации vars ломают сборку
+
+	warned sync.Map // uuid → последнее залогированное предупреждение валидации
+}
+
+// FactsProvider — источник последних фактов устройства (board, firmware, radios…).
+type FactsProvider interface {
+	GetDeviceFacts(uuid string) (map[string]any, error)
+}
+
+// WithFacts подключает источник фактов; без него .facts в шаблонах — пустая map.
+func (b *Builder) WithFacts(p FactsProvider) *Builder {
+	b.facts = p
+	return b
+}
+
+// WithGlobals добавляет слой глобальных переменных (самый низкий приоритет).
+func (b *Builder) WithGlobals(p GlobalVarsProvider) *Builder {
+	b.layers = append([]VarsLayer{globalLayer{p}}, b.layers...)
+	return b
+}
+
+// WithStrictVars — ошибка валидации переменных прерывает сборку (controller.strict_vars).
+func (b *Builder) WithStrictVars(on bool) *Builder {
+	b.strict = on
+	return b
 }
 
-func NewBuilder(repo *Repo) *Builder { return &Builder{repo: repo} }
+// WithLayer добавляет слой переменных поверх существующих.
+func (b *Builder) WithLayer(l VarsLayer) *Builder {
+	b.layers = append(b.layers, l)
+	return b
+}
+
+func NewBuilder(repo *Repo) *Builder { return NewBuilderWithIPAMAndRenderer(repo, nil, nil) }
 func NewBuilderWithIPAM(repo *Repo, ipam *ipam.Repo) *Builder {
-	// совместимость: если рендерер явно не передали — создадим дефолтный
-	return &Builder{
-		repo: repo,
-		ipam: ipam,
-		tpl:  NewTemplateRenderer(repo),
-	}
+	return NewBuilderWithIPAMAndRenderer(repo, ipam, nil)
 }
 
 // Явный конструктор, если хочешь подменять рендерер
-func NewBuilderWithIPAMAndRenderer(repo *Repo, ipam *ipam.Repo, tpl TemplateRenderer) *Builder {
+func NewBuilderWithIPAMAndRenderer(repo *Repo, ip *ipam.Repo, tpl TemplateRenderer) *Builder {
 	if tpl == nil {
-		tpl = NewTemplateRenderer(repo)
+		tpl = NewTemplateRenderer()
+	}
+	b := &Builder{repo: repo, tpl: tpl}
+	if ip != nil {
+		b.layers = append(b.layers, ipamLayer{ip})
 	}
-	return &Builder{repo: repo, ipam: ipam, tpl: tpl}
+	b.layers = append(b.layers, groupLayer{repo}, deviceLayer{repo})
+	return b
 }
 
-func (b *Builder) BuildConfig(d owctrl.DeviceFields) (map[string]string, error) {
-	files := map[string]string{}
+// BuildContext — то, что известно о сборке до переменных и шаблонов.
+type BuildContext struct {
+	Device       owctrl.DeviceFields
+	Groups       []models.Group // действующие (с предками), в порядке применения — см. ExpandGroups
+	GroupIDs     []uint
+	DirectGroups []uint // прямое членство, в порядке применения (см. GetDeviceGroups)
+	Primary      uint   // основная группа устройства; 0 — не задана
+}
 
-	// Groups
-	grps, err := b.repo.GetDeviceGroups(d.UUID)
+// IPAMGroup — группа, по префиксам которой выбирается адрес: основная, иначе
+// прямая группа с наибольшим приоритетом (последняя в DirectGroups); 0 — групп нет.
+func (bc *BuildContext) IPAMGroup() uint {
+	if bc.Primary != 0 {
+		return bc.Primary
+	}
+	if n := len(bc.DirectGroups); n > 0 {
+		return bc.DirectGroups[n-1]
+	}
+	return 0
+}
+
+// Overrides — «что если»: временные правки для предпросмотра, в БД не сохраняются.
+type Overrides struct {
+	Vars            map[string]string // поверх всех слоёв
+	AddTemplates    []uint            // добавить после device-назначений
+	RemoveTemplates []uint            // исключить из сборки
+	Drafts          []DraftTemplate   // черновики тел шаблонов
+}
+
+// DraftTemplate — черновик: TemplateID != 0 подменяет поля существующего шаблона,
+// TemplateID == 0 — новый шаблон, рендерится последним.
+type DraftTemplate struct {
+	TemplateID uint   `json:"template_id"`
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Body       string `json:"body"`
+}
+
+// BuildConfig satisfies owctrl.ConfigBuilder
+func (b *Builder) BuildConfig(d owctrl.DeviceFields) (map[string]string, map[string]fs.FileMode, error) {
+	return b.BuildConfigWith(d, nil)
+}
+
+// BuildConfigWith — сборка с необязательными overrides (nil — обычная сборка).
+func (b *Builder) BuildConfigWith(d owctrl.DeviceFields, ov *Overrides) (map[string]string, map[string]fs.FileMode, error) {
+	// 1) context
+	bc, err := b.Context(d)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	gids := make([]uint, 0, len(grps))
-	for _, g := range grps {
-		gids = append(gids, g.ID)
+	// 2) vars
+	vars, err := b.Vars(bc, ov)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 3) validate
+	if err := validateVars(vars); err != nil {
+		if b.strict {
+			return nil, nil, fmt.Errorf("config build error: %w", err)
+		}
+		b.warnVars(d.UUID, err)
+	} else {
+		b.warned.Delete(d.UUID)
+	}
+	// 4) templates
+	tpls, err := b.Templates(bc, ov)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 5) render
+	files, modes, err := b.render(bc, vars, tpls)
+	if err != nil {
+		return nil, nil, err
 	}
+	// 6) finalize
+	finalize(d, files)
+	return files, modes, nil
+}
 
-	// Vars: group -> device
-	groupVars, err := b.repo.GetGroupVars(gids)
+// Context — стадия 1.
+func (b *Builder) Context(d owctrl.DeviceFields) (*BuildContext, error) {
+	direct, err := b.repo.GetDeviceGroups(d.UUID)
 	if err != nil {
 		return nil, err
 	}
-	devVars, err := b.repo.GetDeviceVars(d.UUID)
+	grps, err := b.repo.ExpandGroups(direct)
 	if err != nil {
 		return nil, err
 	}
-	mergedVars := map[string]string{}
-	for k, v := range groupVars {
-		mergedVars[k] = v
-	}
-	for k, v := range devVars {
-		mergedVars[k] = v
-	}
-
-	// IPAM group prefix vars
-	var firstGroupPrefix *models.Prefix
-	if b.ipam != nil && len(grps) > 0 {
-		if pfx, err := b.ipam.FirstGroupPrefix(grps[0].ID); err == nil {
-			firstGroupPrefix = pfx
-			mergedVars["ipam_group_prefix_cidr"] = pfx.CIDR
-			if _, nw, e := net.ParseCIDR(pfx.CIDR); e == nil {
-				ones, _ := nw.Mask.Size()
-				mergedVars["ipam_group_prefix_len"] = fmt.Sprintf("%d", ones)
-				mergedVars["ipam_group_prefix_network"] = nw.IP.String()
-				if gw := firstUsableIPv4(nw); gw != "" {
-					mergedVars["ipam_group_prefix_gw"] = gw
-				}
-				mergedVars["ipam_group_prefix_netmask"] = net.IP(nw.Mask).String()
-			}
-		}
+	bc := &BuildContext{Device: d, Groups: grps}
+	for _, g := range grps {
+		bc.GroupIDs = append(bc.GroupIDs, g.ID)
+	}
+	for _, g := range direct {
+		bc.DirectGroups = append(bc.DirectGroups, g.ID)
+	}
+	if bc.Primary, err = b.repo.PrimaryGroupID(d.UUID); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// Источники переменных помимо слоёв (см. VarsLayer.Name).
+const (
+	SourceOverride     = "override"      // Overrides.Vars предпросмотра
+	SourceDeviceFields = "device_fields" // id, key, name, mac_address, hostname по умолчанию
+)
+
+// Vars — стадия 2: слои → overrides → предопределённые поля устройства.
+func (b *Builder) Vars(bc *BuildContext, ov *Overrides) (map[string]string, error) {
+	vars, _, err := b.VarsWithSources(bc, ov)
+	return vars, err
+}
+
+// VarValue — значение переменной из одного источника.
+type VarValue struct {
+	Layer   string `json:"layer"` // имя слоя | override | device_fields
+	GroupID uint   `json:"group_id,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Value   string `json:"value"`
+}
+
+// VarsWithSources — как Vars, плюс откуда взято итоговое значение каждого ключа.
+func (b *Builder) VarsWithSources(bc *BuildContext, ov *Overrides) (map[string]string, map[string]string, error) {
+	trace, err := b.TraceVars(bc, ov)
+	if err != nil {
+		return nil, nil, err
+	}
+	merged, src := mergeTrace(trace)
+	return merged, src, nil
+}
+
+// mergeTrace — итоговые значения и их слои из истории TraceVars.
+func mergeTrace(trace map[string][]VarValue) (map[string]string, map[string]string) {
+	merged := make(map[string]string, len(trace))
+	src := make(map[string]string, len(trace))
+	for k, hist := range trace {
+		last := hist[len(hist)-1]
+		merged[k], src[k] = last.Value, last.Layer
 	}
+	return merged, src
+}
 
-	// IPAM device IP (если есть в том же префиксе)
-	if b.ipam != nil && firstGroupPrefix != nil {
-		ips, _ := b.ipam.DeviceIPs(d.UUID)
-		for _, rec := range ips {
-			if rec.PrefixID == firstGroupPrefix.ID {
-				mergedVars["ipv4_address"] = rec.Address
-				if _, nw, e := net.ParseCIDR(firstGroupPrefix.CIDR); e == nil {
-					mergedVars["ipv4_netmask"] = net.IP(nw.Mask).String()
-					if gw := firstUsableIPv4(nw); gw != "" {
-						mergedVars["ipv4_gateway"] = gw
-					}
-				}
-				break
+// TraceVars — история значений каждого ключа в порядке применения
+// (последнее — действующее, до нормализации varschema).
+func (b *Builder) TraceVars(bc *BuildContext, ov *Overrides) (map[string][]VarValue, error) {
+	trace := map[string][]VarValue{}
+	set := func(k string, v VarValue) { trace[k] = append(trace[k], v) }
+	for _, l := range b.layers {
+		var sets []VarSet
+		if sl, ok := l.(splitLayer); ok {
+			s, err := sl.Sets(bc)
+			if err != nil {
+				return nil, fmt.Errorf("vars layer %s: %w", l.Name(), err)
+			}
+			sets = s
+		} else {
+			vs, err := l.Vars(bc)
+			if err != nil {
+				return nil, fmt.Errorf("vars layer %s: %w", l.Name(), err)
 			}
+			sets = []VarSet{{Vars: vs}}
+		}
+		for _, s := range sets {
+			for k, v := range s.Vars {
+				set(k, VarValue{Layer: l.Name(), GroupID: s.GroupID, Group: s.Group, Value: v})
+			}
+		}
+	}
+	if ov != nil {
+		for k, v := range ov.Vars {
+			set(k, VarValue{Layer: SourceOverride, Value: v})
 		}
 	}
 
-	// Templates: group then device (device overrides by path)
-	gTpls, err := b.repo.TemplatesByGroupIDs(gids)
+	// как в OpenWISP: поля устройства доступны в шаблонах всегда
+	d := bc.Device
+	field := func(k, v string) { set(k, VarValue{Layer: SourceDeviceFields, Value: v}) }
+	field("id", d.UUID)
+	field("key", d.Key)
+	field("name", d.Name)
+	if d.MAC != "" {
+		field("mac_address", d.MAC)
+	}
+	if h := trace["hostname"]; (len(h) == 0 || strings.TrimSpace(h[len(h)-1].Value) == "") && d.Name != "" {
+		field("hostname", d.Name)
+	}
+	return trace, nil
+}
+
+// SecretValues — значения секретных переменных устройства из всех слоёв
+// (не только действующие), для маскирования отрендеренных файлов. Реализует owctrl.SecretSource.
+func (b *Builder) SecretValues(d owctrl.DeviceFields) ([]string, error) {
+	return b.SecretValuesWith(d, nil)
+}
+
+// SecretValuesWith — как SecretValues, с учётом Overrides.Vars предпросмотра.
+func (b *Builder) SecretValuesWith(d owctrl.DeviceFields, ov *Overrides) ([]string, error) {
+	bc, err := b.Context(d)
 	if err != nil {
 		return nil, err
 	}
-	dAs, err := b.repo.ListAssignments(d.UUID)
+	trace, err := b.TraceVars(bc, ov)
 	if err != nil {
 		return nil, err
 	}
-	dTIDs := make([]uint, 0, len(dAs))
-	for _, a := range dAs {
-		dTIDs = append(dTIDs, a.TemplateID)
+	var out []string
+	for k, hist := range trace {
+		if !varschema.IsSecret(k) {
+			continue
+		}
+		for _, v := range hist {
+			out = append(out, v.Value)
+			// в файлы попадает нормализованное значение
+			if nv, err := varschema.ValidateOne(k, v.Value); err == nil && nv != v.Value {
+				out = append(out, nv)
+			}
+		}
 	}
-	dTpls, err := b.repo.TemplatesByIDs(dTIDs)
+	return out, nil
+}
+
+// validateVars — стадия 3: нормализация известных ключей + обязательные.
+// Неверные значения остаются как есть; ошибка перечисляет все проблемы.
+func validateVars(vars map[string]string) error {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // стабильный текст ошибки
+	var problems []string
+	for _, k := range keys {
+		v := vars[k]
+		if _, ok := varschema.Def(k); !ok || v == "" {
+			continue
+		}
+		nv, err := varschema.ValidateOne(k, v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s: %v", k, err))
+			continue
+		}
+		vars[k] = nv
+	}
+	if err := varschema.ValidateAll(func(k string) (string, bool) {
+		v, ok := vars[k]
+		return v, ok
+	}); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// warnVars — нестрогий режим: предупреждение в лог, повторно — только если
+// оно изменилось (агент опрашивает checksum постоянно).
+func (b *Builder) warnVars(uuid string, err error) {
+	msg := err.Error()
+	if prev, ok := b.warned.Load(uuid); ok && prev == msg {
+		return
+	}
+	b.warned.Store(uuid, msg)
+	logs.Logger.Warnf("device %s: %s (config served anyway, see /api/v1/devices/%s/vars/report)", uuid, msg, uuid)
+}
+
+// Templates — стадия 4: итоговый упорядоченный список шаблонов.
+func (b *Builder) Templates(bc *BuildContext, ov *Overrides) ([]models.Template, error) {
+	tpls, err := b.repo.ResolvedTemplatesForDevice(bc.Device.UUID, bc.GroupIDs)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("resolve templates: %w", err)
 	}
+	if ov == nil {
+		return tpls, nil
+	}
+	return b.applyTemplateOverrides(tpls, ov)
+}
 
-	sort.Slice(gTpls, func(i, j int) bool { return gTpls[i].ID < gTpls[j].ID })
-	sort.Slice(dTpls, func(i, j int) bool { return dTpls[i].ID < dTpls[j].ID })
+// applyTemplateOverrides — add → remove → drafts.
+func (b *Builder) applyTemplateOverrides(tpls []models.Template, ov *Overrides) ([]models.Template, error) {
+	present := map[uint]bool{}
+	for _, t := range tpls {
+		present[t.ID] = true
+	}
+	var extra []uint
+	for _, id := range ov.AddTemplates {
+		if !present[id] {
+			extra = append(extra, id)
+			present[id] = true
+		}
+	}
+	if len(extra) > 0 {
+		byID, err := b.repo.GetTemplatesByIDs(extra)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range extra {
+			t, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("add_templates: template %d not found", id)
+			}
+			tpls = append(tpls, t)
+		}
+	}
+	tpls = withoutTemplates(tpls, ov.RemoveTemplates)
 
+	for _, dr := range ov.Drafts {
+		if dr.TemplateID == 0 {
+			if strings.TrimSpace(dr.Path) == "" && !strings.EqualFold(dr.Type, "netjson") {
+				return nil, fmt.Errorf("draft without template_id requires path")
+			}
+			tpls = append(tpls, models.Template{Name: "draft", Path: dr.Path, Type: dr.Type, Body: dr.Body})
+			continue
+		}
+		found := false
+		for i := range tpls {
+			if tpls[i].ID != dr.TemplateID {
+				continue
+			}
+			found = true
+			tpls[i].Body = dr.Body
+			if dr.Path != "" {
+				tpls[i].Path = dr.Path
+			}
+			if dr.Type != "" {
+				tpls[i].Type = dr.Type
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("draft for template %d: template is not part of this build", dr.TemplateID)
+		}
+	}
+	return tpls, nil
+}
+
+func withoutTemplates(tpls []models.Template, ids []uint) []models.Template {
+	if len(ids) == 0 {
+		return tpls
+	}
+	drop := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+	out := tpls[:0:0]
+	for _, t := range tpls {
+		if !drop[t.ID] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// render — стадия 5.
+// Права файлов — по итоговому пути: шаблон, перезаписавший путь, задаёт и его права.
+func (b *Builder) render(bc *BuildContext, vars map[string]string, tpls []models.Template) (map[string]string, map[string]fs.FileMode, error) {
+	facts := map[string]any{}
+	if b.facts != nil {
+		if f, err := b.facts.GetDeviceFacts(bc.Device.UUID); err == nil && f != nil {
+			facts = f
+		}
+	}
+	d := bc.Device
 	data := map[string]any{
 		"device": map[string]any{
 			"uuid":    d.UUID,
@@ -128,62 +463,38 @@ func (b *Builder) BuildConfig(d owctrl.DeviceFields) (map[string]string, error)
 			"backend": d.Backend,
 			"mac":     d.MAC,
 		},
-		"vars":   mergedVars,
-		"groups": grps,
+		"vars":   vars,
+		"groups": bc.Groups,
+		"facts":  facts,
 	}
 
-	renderInto := func(tpls []models.Template) error {
-		for _, tpl := range tpls {
-			content, err := render(tpl.Body, data)
-			if err != nil {
-				return fmt.Errorf("template %d render: %w", tpl.ID, err)
+	files := make(map[string]string, len(tpls)+2)
+	modes := map[string]fs.FileMode{}
+	for _, t := range tpls {
+		m, mm, err := b.tpl.RenderOneFiles(t, data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("template %d (%s): %w", t.ID, t.Name, err)
+		}
+		for p, c := range m {
+			files[p] = c
+			if mode, ok := mm[p]; ok {
+				modes[p] = mode
+			} else {
+				delete(modes, p)
 			}
-			files[tpl.Path] = content
 		}
-		return nil
-	}
-
-	if err := renderInto(gTpls); err != nil {
-		return nil, err
-	}
-	if err := renderInto(dTpls); err != nil {
-		return nil, err
 	}
+	return files, modes, nil
+}
 
+// finalize — стадия 6.
+func finalize(d owctrl.DeviceFields, files map[string]string) {
 	if len(files) == 0 {
 		files["etc/config/system"] = fmt.Sprintf(
 			"config system 'system'\n  option hostname '%s'\n  option timezone 'UTC'\n",
-			safe(d.Name),
+			strings.ReplaceAll(d.Name, "'", ""),
 		)
 	}
-
 	files["etc/openwisp/device.meta"] = fmt.Sprintf("uuid=%s\nmac=%s\nbackend=%s\n", d.UUID, d.MAC, d.Backend)
 	files["etc/openwisp/managed_by_openwisp_go.md"] = "This device is managed by OpenWISP-Go controller.\n"
-
-	return files, nil
-}
-
-func render(body string, data any) (string, error) {
-	tpl, err := template.New("cfg").Option("missingkey=error").Parse(body)
-	if err != nil {
-		return "", err
-	}
-	var buf bytes.Buffer
-	if err := tpl.Execute(&buf, data); err != nil {
-		return "", err
-	}
-	return buf.String(), nil
-}
-
-func safe(s string) string { return bytes.NewBufferString(s).String() }
-
-// IPv4 helpers
-func firstUsableIPv4(nw *net.IPNet) string {
-	ip := nw.IP.To4()
-	if ip == nil {
-		return ""
-	}
-	u := uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
-	u++ // network + 1
-	return net.IPv4(byte(u>>24), byte(u>>16), byte(u>>8), byte(u)).String()
 }
@@ -0,0 +1,122 @@
+// internal/configsvc/varrefs.go
+package configsvc
+
+import (
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateVarRefs — ключи vars, на которые ссылается тело шаблона:
+// {{ .vars.x }}, {{ $.vars.x }}, {{ index .vars "x" }}, {{ with .vars }}{{ .x }}.
+// Обращения внутри with/range по другим данным (точка — не корень) не учитываются.
+func TemplateVarRefs(body string) ([]string, error) {
+	tpl, err := template.New("tpl").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			walkRefs(t.Tree.Root, dotRoot, seen)
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for k := range seen {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// dot — на что указывает точка в текущей области шаблона.
+type dot int
+
+const (
+	dotRoot  dot = iota // корень данных {device, vars, …}
+	dotVars             // внутри {{ with .vars }}
+	dotOther            // range/with по чему-то ещё
+)
+
+func walkRefs(n parse.Node, d dot, seen map[string]struct{}) {
+	switch x := n.(type) {
+	case *parse.ListNode:
+		if x == nil {
+			return
+		}
+		for _, c := range x.Nodes {
+			walkRefs(c, d, seen)
+		}
+	case *parse.ActionNode:
+		walkRefs(x.Pipe, d, seen)
+	case *parse.PipeNode:
+		if x == nil {
+			return
+		}
+		for _, c := range x.Cmds {
+			walkRefs(c, d, seen)
+		}
+	case *parse.CommandNode:
+		// index .vars "key"
+		if len(x.Args) >= 3 {
+			if id, ok := x.Args[0].(*parse.IdentifierNode); ok && id.Ident == "index" && isVarsNode(x.Args[1], d) {
+				if s, ok := x.Args[2].(*parse.StringNode); ok {
+					seen[s.Text] = struct{}{}
+				}
+			}
+		}
+		for _, a := range x.Args {
+			walkRefs(a, d, seen)
+		}
+	case *parse.FieldNode:
+		switch {
+		case d == dotRoot && len(x.Ident) >= 2 && x.Ident[0] == "vars":
+			seen[x.Ident[1]] = struct{}{}
+		case d == dotVars && len(x.Ident) >= 1:
+			seen[x.Ident[0]] = struct{}{}
+		}
+	case *parse.VariableNode:
+		if len(x.Ident) >= 3 && x.Ident[0] == "$" && x.Ident[1] == "vars" {
+			seen[x.Ident[2]] = struct{}{}
+		}
+	case *parse.ChainNode:
+		walkRefs(x.Node, d, seen)
+	case *parse.IfNode:
+		walkBranch(&x.BranchNode, d, d, seen)
+	case *parse.WithNode:
+		inner := dotOther
+		if isVarsPipe(x.Pipe, d) {
+			inner = dotVars
+		}
+		walkBranch(&x.BranchNode, d, inner, seen)
+	case *parse.RangeNode:
+		walkBranch(&x.BranchNode, d, dotOther, seen)
+	case *parse.TemplateNode:
+		walkRefs(x.Pipe, d, seen)
+	}
+}
+
+// walkBranch — условие вычисляется в текущей точке, тело — в inner; else — снова в текущей.
+func walkBranch(b *parse.BranchNode, d, inner dot, seen map[string]struct{}) {
+	walkRefs(b.Pipe, d, seen)
+	walkRefs(b.List, inner, seen)
+	walkRefs(b.ElseList, d, seen)
+}
+
+// isVarsNode — .vars, $.vars или . внутри {{ with .vars }}.
+func isVarsNode(n parse.Node, d dot) bool {
+	switch x := n.(type) {
+	case *parse.FieldNode:
+		return d == dotRoot && len(x.Ident) == 1 && x.Ident[0] == "vars"
+	case *parse.VariableNode:
+		return len(x.Ident) == 2 && x.Ident[0] == "$" && x.Ident[1] == "vars"
+	case *parse.DotNode:
+		return d == dotVars
+	}
+	return false
+}
+
+func isVarsPipe(p *parse.PipeNode, d dot) bool {
+	return p != nil && len(p.Decl) == 0 && len(p.Cmds) == 1 &&
+		len(p.Cmds[0].Args) == 1 && isVarsNode(p.Cmds[0].Args[0], d)
+}
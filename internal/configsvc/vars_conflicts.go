@@ -0,0 +1,91 @@
+// internal/configsvc/vars_conflicts.go
+package configsvc
+
+import (
+	"fmt"
+	"sort"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/models"
+)
+
+// VarConflict — две группы устройства с одинаковым приоритетом задают разные
+// значения одного ключа, и ни одна не является предком другой: победитель
+// определён только тай-брейком (основная группа, затем больший id).
+type VarConflict struct {
+	Key        string   `json:"key"`
+	Winner     groupRef `json:"winner"`
+	Value      string   `json:"value"`
+	Loser      groupRef `json:"loser"`
+	LoserValue string   `json:"loser_value"`
+	Priority   int      `json:"priority"`
+	Reason     string   `json:"reason"` // primary | group_id
+	Message    string   `json:"message"`
+}
+
+// Причины выбора победителя в VarConflict.
+const (
+	ConflictPrimary = "primary"
+	ConflictGroupID = "group_id"
+)
+
+// varConflicts — конфликты групповых переменных, решающих итоговое значение
+// (перекрытые устройством или overrides ключи не в счёт). Значения секретов маскируются.
+func varConflicts(bc *BuildContext, trace map[string][]VarValue) []VarConflict {
+	out := []VarConflict{}
+	idx := make(map[uint]models.Group, len(bc.Groups))
+	for _, g := range bc.Groups {
+		idx[g.ID] = g
+	}
+	related := func(a, b uint) bool {
+		for _, g := range lineage(idx, a) {
+			if g.ID == b {
+				return true
+			}
+		}
+		for _, g := range lineage(idx, b) {
+			if g.ID == a {
+				return true
+			}
+		}
+		return false
+	}
+
+	keys := make([]string, 0, len(trace))
+	for k := range trace {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hist := trace[k]
+		win := hist[len(hist)-1]
+		if win.GroupID == 0 {
+			continue
+		}
+		wg := idx[win.GroupID]
+		for _, v := range hist[:len(hist)-1] {
+			if v.GroupID == 0 || v.GroupID == win.GroupID || v.Value == win.Value {
+				continue
+			}
+			lg := idx[v.GroupID]
+			if lg.Priority != wg.Priority || related(wg.ID, lg.ID) {
+				continue
+			}
+			c := VarConflict{
+				Key:        k,
+				Winner:     groupRef{ID: wg.ID, Name: wg.Name},
+				Value:      varschema.Mask(k, win.Value),
+				Loser:      groupRef{ID: lg.ID, Name: lg.Name},
+				LoserValue: varschema.Mask(k, v.Value),
+				Priority:   wg.Priority,
+				Reason:     ConflictGroupID,
+			}
+			if bc.Primary != 0 && related(wg.ID, bc.Primary) {
+				c.Reason = ConflictPrimary
+			}
+			c.Message = fmt.Sprintf("var %s: groups %q and %q have equal priority %d and set different values; %q wins (%s)",
+				k, wg.Name, lg.Name, wg.Priority, wg.Name, c.Reason)
+			out = append(out, c)
+		}
+	}
+	return out
+}
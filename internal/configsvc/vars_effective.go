@@ -0,0 +1,105 @@
+// internal/configsvc/vars_effective.go
+package configsvc
+
+import (
+	"sort"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/owctrl"
+)
+
+// EffectiveVar — итоговая переменная устройства и её происхождение.
+// Секретные значения замаскированы во всех полях.
+type EffectiveVar struct {
+	Key        string     `json:"key"`
+	Value      string     `json:"value"`           // как увидят шаблоны (после нормализации varschema)
+	Raw        string     `json:"raw,omitempty"`   // значение источника, если нормализация его изменила
+	Error      string     `json:"error,omitempty"` // значение не прошло проверку varschema
+	Source     VarValue   `json:"source"`          // кто задал итоговое значение
+	Overridden []VarValue `json:"overridden"`      // перекрытые значения, в порядке применения
+}
+
+type groupRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// EffectiveVars — ответ /devices/{uuid}/vars/effective.
+type EffectiveVars struct {
+	UUID       string         `json:"uuid"`
+	Layers     []string       `json:"layers"`                  // порядок применения, поздние перекрывают ранние
+	Groups     []groupRef     `json:"groups"`                  // порядок применения внутри слоя group
+	Primary    uint           `json:"primary_group,omitempty"` // основная группа (IPAM, тай-брейк)
+	Vars       []EffectiveVar `json:"vars"`
+	Warnings   []VarConflict  `json:"warnings"` // итог решён тай-брейком, а не приоритетом
+	Validation string         `json:"validation_error,omitempty"`
+}
+
+// EffectiveVars — стадии 1–3 конвейера с историей значений каждого ключа.
+// keys — фильтр (пусто — все).
+func (b *Builder) EffectiveVars(d owctrl.DeviceFields, keys ...string) (*EffectiveVars, error) {
+	bc, err := b.Context(d)
+	if err != nil {
+		return nil, err
+	}
+	trace, err := b.TraceVars(bc, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &EffectiveVars{UUID: d.UUID, Groups: make([]groupRef, 0, len(bc.Groups)), Primary: bc.Primary, Vars: []EffectiveVar{}}
+	for _, l := range b.layers {
+		out.Layers = append(out.Layers, l.Name())
+	}
+	out.Layers = append(out.Layers, SourceDeviceFields)
+	for _, g := range bc.Groups {
+		out.Groups = append(out.Groups, groupRef{ID: g.ID, Name: g.Name})
+	}
+
+	merged, _ := mergeTrace(trace)
+	if err := validateVars(merged); err != nil {
+		out.Validation = err.Error()
+	}
+
+	want := map[string]bool{}
+	for _, k := range keys {
+		want[k] = true
+	}
+	out.Warnings = []VarConflict{}
+	for _, c := range varConflicts(bc, trace) {
+		if len(want) == 0 || want[c.Key] {
+			out.Warnings = append(out.Warnings, c)
+		}
+	}
+	names := make([]string, 0, len(trace))
+	for k := range trace {
+		if len(want) == 0 || want[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		hist := trace[k]
+		last := hist[len(hist)-1]
+		ev := EffectiveVar{Key: k, Value: last.Value, Source: maskVarValue(k, last), Overridden: []VarValue{}}
+		if _, known := varschema.Def(k); known && last.Value != "" {
+			if nv, err := varschema.ValidateOne(k, last.Value); err != nil {
+				ev.Error = err.Error()
+			} else if nv != last.Value {
+				ev.Value, ev.Raw = nv, last.Value
+			}
+		}
+		ev.Value = varschema.Mask(k, ev.Value)
+		ev.Raw = varschema.Mask(k, ev.Raw)
+		for _, v := range hist[:len(hist)-1] {
+			ev.Overridden = append(ev.Overridden, maskVarValue(k, v))
+		}
+		out.Vars = append(out.Vars, ev)
+	}
+	return out, nil
+}
+
+func maskVarValue(key string, v VarValue) VarValue {
+	v.Value = varschema.Mask(key, v.Value)
+	return v
+}
@@ -0,0 +1,172 @@
+package configsvc
+
+import (
+	"fmt"
+	"net"
+	"wisp/internal/ipam"
+	"wisp/internal/models"
+)
+
+// VarsLayer — один слой переменных конвейера (см. Builder).
+type VarsLayer interface {
+	Name() string
+	Vars(bc *BuildContext) (map[string]string, error)
+}
+
+// GlobalVarsProvider — переменные, общие для всех устройств.
+type GlobalVarsProvider interface {
+	GlobalVars() (map[string]string, error)
+}
+
+// VarSet — значения одного источника внутри слоя (например, одной группы).
+type VarSet struct {
+	GroupID uint
+	Group   string
+	Vars    map[string]string
+}
+
+// splitLayer — слой из нескольких источников; Sets — в порядке применения
+// (для provenance: какая именно группа дала значение).
+type splitLayer interface {
+	VarsLayer
+	Sets(bc *BuildContext) ([]VarSet, error)
+}
+
+/* ───────────────────────── global ───────────────────────── */
+
+type globalLayer struct{ p GlobalVarsProvider }
+
+func (globalLayer) Name() string { return "global" }
+
+func (l globalLayer) Vars(*BuildContext) (map[string]string, error) {
+	return l.p.GlobalVars()
+}
+
+/* ───────────────────────── group / device ───────────────────────── */
+
+type groupLayer struct{ repo *Repo }
+
+func (groupLayer) Name() string { return "group" }
+
+func (l groupLayer) Vars(bc *BuildContext) (map[string]string, error) {
+	sets, err := l.Sets(bc)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for _, s := range sets {
+		for k, v := range s.Vars {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+// Sets — по группе на набор, в порядке bc.Groups (поздние перекрывают ранние).
+func (l groupLayer) Sets(bc *BuildContext) ([]VarSet, error) {
+	byGroup, err := l.repo.GetGroupVarsByGroup(bc.GroupIDs)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]VarSet, 0, len(byGroup))
+	for _, g := range bc.Groups {
+		if vs, ok := byGroup[g.ID]; ok {
+			out = append(out, VarSet{GroupID: g.ID, Group: g.Name, Vars: vs})
+		}
+	}
+	return out, nil
+}
+
+type deviceLayer struct{ repo *Repo }
+
+func (deviceLayer) Name() string { return "device" }
+
+func (l deviceLayer) Vars(bc *BuildContext) (map[string]string, error) {
+	return l.repo.GetDeviceVars(bc.Device.UUID)
+}
+
+/* ───────────────────────── ipam ─────────────────────────
+   ipam_group_prefix_* — IPv4-префикс группы bc.IPAMGroup(): основной, иначе прямой
+                         с наибольшим приоритетом (или унаследованный от предка);
+   ipv4_* / ipv6_*     — адрес устройства (предпочтительно из префикса группы).
+   Слой стоит до group/device, поэтому явные переменные его перекрывают.
+*/
+
+type ipamLayer struct{ ipam *ipam.Repo }
+
+func (ipamLayer) Name() string { return "ipam" }
+
+func (l ipamLayer) Vars(bc *BuildContext) (map[string]string, error) {
+	out := map[string]string{}
+	ips, err := l.ipam.DeviceIPs(bc.Device.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pfx4, pfx6 *models.Prefix
+	if gid := bc.IPAMGroup(); gid != 0 {
+		// префикс этой группы или, если у неё нет, ближайшего предка
+		pfx4, _ = l.ipam.FirstGroupPrefixFamily(gid, "ipv4")
+		pfx6, _ = l.ipam.FirstGroupPrefixFamily(gid, "ipv6")
+	}
+	if pfx4 != nil {
+		out["ipam_group_prefix_cidr"] = pfx4.CIDR
+		if _, nw, e := net.ParseCIDR(pfx4.CIDR); e == nil {
+			ones, _ := nw.Mask.Size()
+			out["ipam_group_prefix_len"] = fmt.Sprintf("%d", ones)
+			out["ipam_group_prefix_network"] = nw.IP.String()
+			out["ipam_group_prefix_gw"] = ipam.FirstUsableIP(nw)
+			out["ipam_group_prefix_netmask"] = net.IP(nw.Mask).String()
+		}
+	}
+
+	if rec, ok := l.pick(ips, pfx4, "ipv4"); ok {
+		out["ipv4_address"] = rec.Address
+		if nw := l.prefixNet(rec.PrefixID); nw != nil {
+			out["ipv4_netmask"] = net.IP(nw.Mask).String()
+			out["ipv4_gateway"] = ipam.FirstUsableIP(nw)
+		}
+	}
+	if rec, ok := l.pick(ips, pfx6, "ipv6"); ok {
+		out["ipv6_address"] = rec.Address
+		if nw := l.prefixNet(rec.PrefixID); nw != nil {
+			ones, _ := nw.Mask.Size()
+			out["ipv6_prefixlen"] = fmt.Sprintf("%d", ones)
+			out["ipv6_gateway"] = ipam.FirstUsableIP(nw)
+		}
+	}
+	return out, nil
+}
+
+// pick — адрес из префикса группы, иначе первый адрес нужного семейства.
+func (l ipamLayer) pick(ips []models.DeviceIP, pfx *models.Prefix, family string) (models.DeviceIP, bool) {
+	var first *models.DeviceIP
+	for i, rec := range ips {
+		ip := net.ParseIP(rec.Address)
+		if ip == nil || (ip.To4() != nil) != (family == "ipv4") {
+			continue
+		}
+		if pfx != nil && rec.PrefixID == pfx.ID {
+			return rec, true
+		}
+		if first == nil {
+			first = &ips[i]
+		}
+	}
+	if first == nil {
+		return models.DeviceIP{}, false
+	}
+	return *first, true
+}
+
+func (l ipamLayer) prefixNet(id uint) *net.IPNet {
+	p, ok, err := l.ipam.GetPrefixByID(id)
+	if err != nil || !ok {
+		return nil
+	}
+	_, nw, err := net.ParseCIDR(p.CIDR)
+	if err != nil {
+		return nil
+	}
+	return nw
+}
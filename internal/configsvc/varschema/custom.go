@@ -0,0 +1,205 @@
+// internal/configsvc/varschema/custom.go
+package varschema
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Spec — декларативное описание переменной (хранится в БД, см. models.VarDefinition).
+type Spec struct {
+	Key         string   `json:"key"`
+	Type        VarType  `json:"type"`
+	Pattern     string   `json:"pattern,omitempty"`     // regexp, должен совпасть с нормализованным значением целиком
+	Min         *int     `json:"min,omitempty"`         // int — значение, string — длина
+	Max         *int     `json:"max,omitempty"`         // int — значение, string — длина
+	Enum        []string `json:"enum,omitempty"`        // допустимые значения (после нормализации)
+	Required    bool     `json:"required"`              // безусловно обязательна
+	Secret      bool     `json:"secret"`                // шифруется в БД, в API маскируется
+	RequiredIf  string   `json:"required_if,omitempty"` // "key" (задана) | "key=v1|v2"
+	Description string   `json:"description,omitempty"`
+	Example     string   `json:"example,omitempty"`
+}
+
+var reKey = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Compile проверяет Spec и собирает из него VarDef.
+func Compile(s Spec) (VarDef, error) {
+	if !reKey.MatchString(s.Key) {
+		return VarDef{}, errors.New("key must match [a-z][a-z0-9_]{0,63}")
+	}
+	if s.Type == "" {
+		s.Type = TString
+	}
+	if s.Min != nil && s.Max != nil && *s.Min > *s.Max {
+		return VarDef{}, errors.New("min > max")
+	}
+	lo, hi := math.MinInt32, math.MaxInt32
+	if s.Min != nil {
+		lo = *s.Min
+	}
+	if s.Max != nil {
+		hi = *s.Max
+	}
+
+	var base func(string) (string, error)
+	switch s.Type {
+	case TString:
+		base = func(v string) (string, error) {
+			v = strings.TrimSpace(v)
+			if n := len([]rune(v)); n < max(lo, 0) || n > hi {
+				return "", fmt.Errorf("length out of range [%d..%d]", max(lo, 0), hi)
+			}
+			return v, nil
+		}
+	case TInt:
+		base = normInt(lo, hi)
+	case TBool:
+		base = normBool
+	case TList:
+		base = normList
+	case TIPv4:
+		base = normIPv4
+	case TIPv6:
+		base = normIPv6
+	default:
+		return VarDef{}, fmt.Errorf("unknown type %q (string|int|bool|list|ipv4|ipv6)", s.Type)
+	}
+
+	var re *regexp.Regexp
+	if s.Pattern != "" {
+		var err error
+		if re, err = regexp.Compile(`^(?:` + s.Pattern + `)$`); err != nil {
+			return VarDef{}, fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	enum := append([]string(nil), s.Enum...)
+
+	requires, err := compileRequiredIf(s.RequiredIf)
+	if err != nil {
+		return VarDef{}, err
+	}
+
+	return VarDef{
+		Key:         s.Key,
+		Type:        s.Type,
+		Example:     s.Example,
+		Description: s.Description,
+		Required:    s.Required,
+		Requires:    requires,
+		Custom:      true,
+		Secret:      s.Secret,
+		Validate: func(v string) (string, error) {
+			nv, err := base(v)
+			if err != nil {
+				return "", err
+			}
+			if re != nil && !re.MatchString(nv) {
+				return "", fmt.Errorf("must match %s", s.Pattern)
+			}
+			if len(enum) > 0 {
+				ok := false
+				for _, e := range enum {
+					if nv == e {
+						ok = true
+						break
+					}
+				}
+				if !ok {
+					return "", fmt.Errorf("must be one of %s", strings.Join(enum, "|"))
+				}
+			}
+			return nv, nil
+		},
+	}, nil
+}
+
+// compileRequiredIf — "other" (задана и не пуста) или "other=a|b".
+func compileRequiredIf(cond string) (func(get func(string) (string, bool)) bool, error) {
+	cond = strings.TrimSpace(cond)
+	if cond == "" {
+		return nil, nil
+	}
+	key, vals, hasVal := strings.Cut(cond, "=")
+	key = strings.TrimSpace(key)
+	if !reKey.MatchString(key) {
+		return nil, fmt.Errorf("invalid required_if %q (want key or key=v1|v2)", cond)
+	}
+	if !hasVal {
+		return func(get func(string) (string, bool)) bool {
+			v, ok := get(key)
+			return ok && strings.TrimSpace(v) != ""
+		}, nil
+	}
+	want := strings.Split(vals, "|")
+	return func(get func(string) (string, bool)) bool {
+		v, ok := get(key)
+		if !ok {
+			return false
+		}
+		for _, w := range want {
+			if v == strings.TrimSpace(w) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+/* ——— runtime registry ——— */
+
+var (
+	customMu sync.RWMutex
+	custom   = map[string]VarDef{}
+)
+
+// SetCustom заменяет набор пользовательских определений (ключи встроенного
+// каталога не переопределяются — такие Spec отклоняются).
+func SetCustom(specs []Spec) error {
+	next := make(map[string]VarDef, len(specs))
+	for _, s := range specs {
+		if _, builtin := byKey[s.Key]; builtin {
+			return fmt.Errorf("%s: built-in variable cannot be redefined", s.Key)
+		}
+		d, err := Compile(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.Key, err)
+		}
+		next[s.Key] = d
+	}
+	customMu.Lock()
+	custom = next
+	customMu.Unlock()
+	return nil
+}
+
+// IsBuiltin — ключ из встроенного каталога.
+func IsBuiltin(key string) bool { _, ok := byKey[key]; return ok }
+
+// All — встроенный каталог + пользовательские определения (по ключу у пользовательских).
+func All() []VarDef {
+	customMu.RLock()
+	defer customMu.RUnlock()
+	out := append([]VarDef(nil), Catalog...)
+	keys := make([]string, 0, len(custom))
+	for k := range custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out = append(out, custom[k])
+	}
+	return out
+}
+
+func customDef(key string) (VarDef, bool) {
+	customMu.RLock()
+	defer customMu.RUnlock()
+	d, ok := custom[key]
+	return d, ok
+}
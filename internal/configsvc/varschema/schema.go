@@ -22,12 +22,15 @@ const (
 )
 
 type VarDef struct {
-	Key      string
-	Type     VarType
-	Example  string
-	Validate func(string) (string, error)               // нормализация/проверка одного значения
-	Required bool                                       // безусловно обязателен (редко)
-	Requires func(get func(string) (string, bool)) bool // условно обязателен (зависит от других vars)
+	Key         string
+	Type        VarType
+	Example     string
+	Description string
+	Validate    func(string) (string, error)               // нормализация/проверка одного значения
+	Required    bool                                       // безусловно обязателен (редко)
+	Requires    func(get func(string) (string, bool)) bool // условно обязателен (зависит от других vars)
+	Custom      bool                                       // определение из БД (см. custom.go)
+	Secret      bool                                       // шифруется в БД, в API маскируется (см. secret.go)
 }
 
 /* ——— validators ——— */
@@ -227,7 +230,7 @@ var Catalog = []VarDef{
 		}
 		return "", errors.New("wifi_encryption invalid")
 	}},
-	{Key: "wifi_psk", Type: TString, Example: "********", Validate: normWiFiPSK},
+	{Key: "wifi_psk", Type: TString, Example: "********", Validate: normWiFiPSK, Secret: true},
 }
 
 /* ——— registry ——— */
@@ -241,7 +244,13 @@ func init() {
 	}
 }
 
-func Def(key string) (VarDef, bool) { d, ok := byKey[key]; return d, ok }
+// Def — определение по ключу: встроенный каталог, затем пользовательские из БД.
+func Def(key string) (VarDef, bool) {
+	if d, ok := byKey[key]; ok {
+		return d, true
+	}
+	return customDef(key)
+}
 
 // ValidateOne validates and normalizes a single var by key.
 func ValidateOne(key, value string) (string, error) {
@@ -254,7 +263,7 @@ func ValidateOne(key, value string) (string, error) {
 // ValidateAll checks conditional requirements against a getter (merged vars).
 func ValidateAll(get func(string) (string, bool)) error {
 	missing := []string{}
-	for _, d := range Catalog {
+	for _, d := range All() {
 		need := d.Required
 		if d.Requires != nil && d.Requires(get) {
 			need = true
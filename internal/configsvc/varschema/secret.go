@@ -0,0 +1,100 @@
+// internal/configsvc/varschema/secret.go
+package varschema
+
+import (
+	"sort"
+	"strings"
+)
+
+// Masked — значение секретной переменной в ответах API и логах.
+const Masked = "********"
+
+// IsSecret — переменная помечена как секретная (встроенная или из БД).
+func IsSecret(key string) bool {
+	d, ok := Def(key)
+	return ok && d.Secret
+}
+
+// Mask — Masked для непустого секретного значения, иначе value как есть.
+func Mask(key, value string) string {
+	if value != "" && IsSecret(key) {
+		return Masked
+	}
+	return value
+}
+
+// Unchanged — клиент прислал обратно Masked для секрета (GET → правка → POST):
+// значение не меняем.
+func Unchanged(key, value string) bool {
+	return value == Masked && IsSecret(key)
+}
+
+// MaskMap — копия m с замаскированными секретами.
+func MaskMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = Mask(k, v)
+	}
+	return out
+}
+
+// minRedact — более короткие значения не вырезаем: "1" или "on" встречаются
+// в конфигурации повсюду, а такой секрет всё равно ничего не защищает.
+const minRedact = 3
+
+// Redact — копия files, где вхождения values (как есть и в UCI-экранировании
+// кавычек) заменены на Masked. Вхождение считается только целым токеном — соседние
+// символы не буквы/цифры/_/- — чтобы короткий секрет не портил посторонний текст.
+// Для админского/отладочного вывода отрендеренной конфигурации.
+func Redact(files map[string]string, values []string) map[string]string {
+	var pats []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		if len(s) >= minRedact && !seen[s] {
+			seen[s] = true
+			pats = append(pats, s)
+		}
+	}
+	for _, v := range values {
+		add(v)
+		add(strings.ReplaceAll(v, "'", `'\''`))
+	}
+	// длинные первыми: секрет, содержащий другой секрет, вырезается целиком
+	sort.Slice(pats, func(i, j int) bool { return len(pats[i]) > len(pats[j]) })
+	out := make(map[string]string, len(files))
+	for p, body := range files {
+		for _, pat := range pats {
+			body = redactToken(body, pat)
+		}
+		out[p] = body
+	}
+	return out
+}
+
+func redactToken(s, pat string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, pat)
+		if i < 0 {
+			break
+		}
+		end := i + len(pat)
+		if (i > 0 && tokenByte(s[i-1])) || (end < len(s) && tokenByte(s[end])) {
+			b.WriteString(s[:i+1])
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		b.WriteString(Masked)
+		s = s[end:]
+	}
+	if b.Len() == 0 {
+		return s
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func tokenByte(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
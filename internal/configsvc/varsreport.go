@@ -0,0 +1,97 @@
+// internal/configsvc/varsreport.go
+package configsvc
+
+import (
+	"sort"
+	"strings"
+	"wisp/internal/configsvc/varschema"
+	"wisp/internal/owctrl"
+)
+
+// TemplateRefs — переменные, на которые ссылается один шаблон сборки.
+type TemplateRefs struct {
+	ID    uint     `json:"id"`
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	Vars  []string `json:"vars"`
+	Error string   `json:"error,omitempty"` // шаблон не разобрался — его ссылки неизвестны
+}
+
+// VarUsage — одна переменная, нужная шаблонам устройства.
+type VarUsage struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`            // секреты замаскированы
+	Source    string `json:"source,omitempty"` // global|ipam|group|device|override|device_fields
+	Templates []uint `json:"templates"`
+	Missing   bool   `json:"missing"` // ни один слой не задал ключ — отрендерится пустой строкой
+	Empty     bool   `json:"empty"`   // задан, но пустой
+}
+
+// VarsReport — какие vars нужны шаблонам устройства, откуда они берутся и чего нет.
+type VarsReport struct {
+	UUID       string         `json:"uuid"`
+	Templates  []TemplateRefs `json:"templates"`
+	Vars       []VarUsage     `json:"vars"`
+	Missing    []string       `json:"missing"`
+	Validation string         `json:"validation_error,omitempty"`
+	Warnings   []VarConflict  `json:"warnings"` // конфликты групповых переменных
+	Complete   bool           `json:"complete"` // нет missing, ошибок разбора и валидации
+}
+
+// VarsReport проходит стадии 1–4 конвейера без рендера и сопоставляет ссылки
+// шаблонов (.vars.*) с итоговыми переменными. Ошибка валидации в отчёт, а не в err.
+func (b *Builder) VarsReport(d owctrl.DeviceFields) (*VarsReport, error) {
+	bc, err := b.Context(d)
+	if err != nil {
+		return nil, err
+	}
+	trace, err := b.TraceVars(bc, nil)
+	if err != nil {
+		return nil, err
+	}
+	vars, src := mergeTrace(trace)
+	rep := &VarsReport{UUID: d.UUID, Templates: []TemplateRefs{}, Vars: []VarUsage{}, Missing: []string{}, Warnings: varConflicts(bc, trace)}
+	if err := validateVars(vars); err != nil {
+		rep.Validation = err.Error()
+	}
+	tpls, err := b.Templates(bc, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	usedBy := map[string][]uint{}
+	parseFailed := false
+	for _, t := range tpls {
+		tr := TemplateRefs{ID: t.ID, Name: t.Name, Type: strings.ToLower(strings.TrimSpace(t.Type)), Vars: []string{}}
+		if tr.Type == "" {
+			tr.Type = "go"
+		}
+		refs, err := TemplateVarRefs(t.Body)
+		if err != nil {
+			tr.Error = err.Error()
+			parseFailed = true
+		} else {
+			tr.Vars = refs
+		}
+		for _, k := range tr.Vars {
+			usedBy[k] = append(usedBy[k], t.ID)
+		}
+		rep.Templates = append(rep.Templates, tr)
+	}
+
+	keys := make([]string, 0, len(usedBy))
+	for k := range usedBy {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, ok := vars[k]
+		u := VarUsage{Key: k, Value: varschema.Mask(k, v), Source: src[k], Templates: usedBy[k], Missing: !ok, Empty: ok && strings.TrimSpace(v) == ""}
+		if u.Missing {
+			rep.Missing = append(rep.Missing, k)
+		}
+		rep.Vars = append(rep.Vars, u)
+	}
+	rep.Complete = len(rep.Missing) == 0 && !parseFailed && rep.Validation == ""
+	return rep, nil
+}
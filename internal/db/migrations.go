@@ -3,10 +3,490 @@ package db
 
 import (
 	"fmt"
+	"time"
+	"wisp/internal/models"
 
 	"gorm.io/gorm"
 )
 
+// Migrations — реестр миграций схемы. Новые добавляются в конец со следующей версией;
+// уже выпущенные не меняются. Для существующих таблиц — AddColumn/CreateIndex,
+// а не AutoMigrate целой модели (иначе вернутся индексы, снятые миграциями, например idx_tpl_name).
+// Таблицы создаются по снимкам моделей на момент миграции (см. ниже), а не по
+// живым models.*: иначе результат миграции менялся бы с каждым новым полем.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "rename_reserved_columns",
+		Up:      MigrateReservedColumns,
+	},
+	{
+		Version: 2,
+		Name:    "initial_schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(initialSchema...)
+		},
+		Down: func(tx *gorm.DB) error {
+			for i := len(initialSchema) - 1; i >= 0; i-- {
+				if err := tx.Migrator().DropTable(initialSchema[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "templates_unique_name_index",
+		Up:      MigrateTemplateUniqueIndex,
+		Down:    dropTemplateUniqueIndex,
+	},
+	{
+		Version: 4,
+		Name:    "device_facts",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v4DeviceFacts{}, &v4DeviceFactsHistory{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v4DeviceFactsHistory{}, &v4DeviceFacts{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "template_revisions",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&v5TemplateRevision{}); err != nil {
+				return err
+			}
+			// существующие шаблоны получают ревизию 1 с текущим содержимым
+			var ts []v2Template
+			if err := tx.Find(&ts).Error; err != nil {
+				return err
+			}
+			for _, t := range ts {
+				rev := v5TemplateRevision{
+					TemplateID: t.ID, Revision: 1,
+					Name: t.Name, Path: t.Path, Body: t.Body, Type: t.Type,
+					Author: "system", Message: "initial (backfilled)",
+				}
+				if err := tx.Create(&rev).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v5TemplateRevision{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "config_archives",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v6ConfigArchive{}, &v6DeviceConfigArchive{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v6DeviceConfigArchive{}, &v6ConfigArchive{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "global_variables",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v7GlobalVariable{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v7GlobalVariable{})
+		},
+	},
+	{
+		Version: 8,
+		Name:    "var_definitions",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v8VarDefinition{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v8VarDefinition{})
+		},
+	},
+	{
+		Version: 9,
+		Name:    "var_definitions_secret",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&models.VarDefinition{}, "Secret") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&models.VarDefinition{}, "Secret")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&models.VarDefinition{}, "Secret")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "group_parent",
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if !m.HasColumn(&models.Group{}, "ParentID") {
+				if err := m.AddColumn(&models.Group{}, "ParentID"); err != nil {
+					return err
+				}
+			}
+			if m.HasIndex(&models.Group{}, "ParentID") {
+				return nil
+			}
+			return m.CreateIndex(&models.Group{}, "ParentID")
+		},
+		Down: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if m.HasIndex(&models.Group{}, "ParentID") {
+				if err := m.DropIndex(&models.Group{}, "ParentID"); err != nil {
+					return err
+				}
+			}
+			return m.DropColumn(&models.Group{}, "ParentID")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "group_rules",
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if !m.HasColumn(&models.DeviceGroup{}, "RuleID") {
+				if err := m.AddColumn(&models.DeviceGroup{}, "RuleID"); err != nil {
+					return err
+				}
+			}
+			if !m.HasIndex(&models.DeviceGroup{}, "RuleID") {
+				if err := m.CreateIndex(&models.DeviceGroup{}, "RuleID"); err != nil {
+					return err
+				}
+			}
+			return tx.AutoMigrate(&v11GroupRule{})
+		},
+		Down: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if err := m.DropTable(&v11GroupRule{}); err != nil {
+				return err
+			}
+			if m.HasIndex(&models.DeviceGroup{}, "RuleID") {
+				if err := m.DropIndex(&models.DeviceGroup{}, "RuleID"); err != nil {
+					return err
+				}
+			}
+			return m.DropColumn(&models.DeviceGroup{}, "RuleID")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "group_priority",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&models.Group{}, "Priority") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&models.Group{}, "Priority")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&models.Group{}, "Priority")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "template_source",
+		Up: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if !m.HasColumn(&models.Template{}, "Source") {
+				if err := m.AddColumn(&models.Template{}, "Source"); err != nil {
+					return err
+				}
+			}
+			if !m.HasIndex(&models.Template{}, "Source") {
+				return m.CreateIndex(&models.Template{}, "Source")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			m := tx.Migrator()
+			if m.HasIndex(&models.Template{}, "Source") {
+				if err := m.DropIndex(&models.Template{}, "Source"); err != nil {
+					return err
+				}
+			}
+			return m.DropColumn(&models.Template{}, "Source")
+		},
+	},
+	{
+		// prev_status раньше появлялся только через AutoMigrate живой модели в initial_schema
+		Version: 14,
+		Name:    "status_history_prev_status",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&models.DeviceStatusHistory{}, "PrevStatus") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&models.DeviceStatusHistory{}, "PrevStatus")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&models.DeviceStatusHistory{}, "PrevStatus")
+		},
+	},
+	{
+		// у отчётов до появления prev_status там NULL, и лента смен статуса их не видит
+		Version: 15,
+		Name:    "status_history_prev_status_backfill",
+		Up:      backfillPrevStatus,
+		// только данные: откатывать нечего, колонку снимает миграция 14
+		Down: func(*gorm.DB) error { return nil },
+	},
+	{
+		Version: 16,
+		Name:    "config_archive_secret_values",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&models.DeviceConfigArchive{}, "SecretValues") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&models.DeviceConfigArchive{}, "SecretValues")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&models.DeviceConfigArchive{}, "SecretValues")
+		},
+	},
+}
+
+// ── Снимки моделей ──────────────────────────────────────────
+// Схема таблиц на момент создавшей их миграции. Не менять: новые поля
+// добавляются отдельными миграциями (AddColumn по живой модели).
+
+// initialSchema — схема, которую создавал AutoMigrate до появления версионных миграций.
+var initialSchema = []any{
+	// devices
+	&v2Device{},
+	&v2DeviceStatusHistory{},
+
+	// configsvc (templates/vars/groups)
+	&v2Template{},
+	&v2DeviceVariable{},
+	&v2DeviceTemplateAssignment{},
+	&v2Group{},
+	&v2DeviceGroup{},
+	&v2GroupVariable{},
+	&v2GroupTemplateAssignment{},
+	&v2DeviceTemplateBlock{},
+
+	// ipam (prefixes & IPs)
+	&v2Prefix{},
+	&v2GroupPrefix{},
+	&v2DeviceIP{},
+}
+
+type v2Device struct {
+	gorm.Model
+	UUID          string `gorm:"uniqueIndex;size:36"`
+	DeviceKey     string `gorm:"index;size:64"`
+	Name          string
+	Backend       string
+	MAC           string
+	Status        string
+	LastSeen      *time.Time
+	LastError     string `gorm:"type:text"`
+	LastConfigSHA string `gorm:"size:64"`
+}
+
+type v2DeviceStatusHistory struct {
+	gorm.Model
+	DeviceUUID string `gorm:"index;size:36"`
+	Status     string `gorm:"index;size:16"`
+	ConfigSHA  string `gorm:"size:64"`
+	Error      string `gorm:"type:text"`
+}
+
+type v2Template struct {
+	gorm.Model
+	Name     string `gorm:"uniqueIndex:idx_tpl_name"`
+	Path     string
+	Body     string
+	Type     string `gorm:"default:'go'"`
+	Required bool   `gorm:"default:false"`
+	Default  bool   `gorm:"default:false"`
+}
+
+type v2DeviceVariable struct {
+	gorm.Model
+	DeviceUUID string `gorm:"index:idx_devvar,priority:1"`
+	VarKey     string `gorm:"column:var_key;index:idx_devvar,priority:2"`
+	Value      string
+}
+
+type v2DeviceTemplateAssignment struct {
+	gorm.Model
+	DeviceUUID string `gorm:"index;size:36"`
+	TemplateID uint   `gorm:"index"`
+	Enabled    bool   `gorm:"default:true"`
+	Order      int    `gorm:"default:100;index"`
+}
+
+type v2DeviceTemplateBlock struct {
+	gorm.Model
+	DeviceUUID string `gorm:"index;size:36"`
+	TemplateID uint   `gorm:"index"`
+}
+
+type v2Group struct {
+	gorm.Model
+	Name string `gorm:"uniqueIndex"`
+}
+
+type v2DeviceGroup struct {
+	gorm.Model
+	DeviceUUID string `gorm:"index"`
+	GroupID    uint   `gorm:"index"`
+	IsPrimary  bool   `gorm:"column:is_primary"`
+}
+
+type v2GroupVariable struct {
+	gorm.Model
+	GroupID uint   `gorm:"index"`
+	VarKey  string `gorm:"column:var_key;index:idx_group_key,priority:1"`
+	Value   string
+}
+
+type v2GroupTemplateAssignment struct {
+	gorm.Model
+	GroupID    uint `gorm:"index"`
+	TemplateID uint `gorm:"index"`
+	Enabled    bool `gorm:"default:true"`
+	Order      int  `gorm:"default:100;index"`
+}
+
+type v2Prefix struct {
+	gorm.Model
+	CIDR     string `gorm:"type:varchar(64);uniqueIndex"`
+	ParentID *uint  `gorm:"index"`
+	Family   string `gorm:"type:varchar(8)"`
+	Note     string `gorm:"type:varchar(255)"`
+}
+
+type v2GroupPrefix struct {
+	gorm.Model
+	GroupID  uint `gorm:"index"`
+	PrefixID uint `gorm:"uniqueIndex"`
+}
+
+type v2DeviceIP struct {
+	gorm.Model
+	DeviceUUID string `gorm:"type:char(36);index"`
+	PrefixID   uint   `gorm:"index"`
+	Address    string `gorm:"type:varchar(45);uniqueIndex"`
+}
+
+type v4DeviceFacts struct {
+	gorm.Model
+	DeviceUUID string `gorm:"uniqueIndex;size:36"`
+	Data       string `gorm:"type:text"`
+}
+
+type v4DeviceFactsHistory struct {
+	gorm.Model
+	DeviceUUID string `gorm:"index;size:36"`
+	Data       string `gorm:"type:text"`
+}
+
+type v5TemplateRevision struct {
+	gorm.Model
+	TemplateID uint   `gorm:"uniqueIndex:ux_tplrev,priority:1"`
+	Revision   int    `gorm:"uniqueIndex:ux_tplrev,priority:2"`
+	Name       string `gorm:"size:191"`
+	Path       string
+	Body       string
+	Type       string `gorm:"size:16"`
+	Author     string `gorm:"size:128"`
+	Message    string `gorm:"type:text"`
+}
+
+type v6ConfigArchive struct {
+	SHA256    string `gorm:"primaryKey;size:64"`
+	Size      int64
+	Data      []byte
+	CreatedAt time.Time
+}
+
+type v6DeviceConfigArchive struct {
+	ID            uint   `gorm:"primaryKey"`
+	DeviceUUID    string `gorm:"uniqueIndex:ux_dev_archive;size:36"`
+	SHA256        string `gorm:"uniqueIndex:ux_dev_archive;index;size:64"`
+	FirstServedAt time.Time
+	LastServedAt  time.Time `gorm:"index"`
+	ServeCount    int
+}
+
+type v7GlobalVariable struct {
+	gorm.Model
+	VarKey string `gorm:"column:var_key;uniqueIndex;size:191"`
+	Value  string
+}
+
+type v8VarDefinition struct {
+	gorm.Model
+	VarKey      string `gorm:"column:var_key;uniqueIndex;size:64"`
+	Type        string `gorm:"size:16"`
+	Pattern     string `gorm:"size:255"`
+	Min         *int
+	Max         *int
+	Enum        string `gorm:"type:text"`
+	Required    bool
+	RequiredIf  string `gorm:"size:255"`
+	Description string `gorm:"type:text"`
+	Example     string `gorm:"size:255"`
+}
+
+type v11GroupRule struct {
+	gorm.Model
+	GroupID uint   `gorm:"index"`
+	Field   string `gorm:"size:16"`
+	FactKey string `gorm:"size:128"`
+	Op      string `gorm:"size:8"`
+	Value   string `gorm:"size:255"`
+}
+
+// имена таблиц — как у соответствующих models.*
+func (v2Device) TableName() string                   { return "devices" }
+func (v2DeviceStatusHistory) TableName() string      { return "device_status_histories" }
+func (v2Template) TableName() string                 { return "templates" }
+func (v2DeviceVariable) TableName() string           { return "device_variables" }
+func (v2DeviceTemplateAssignment) TableName() string { return "device_template_assignments" }
+func (v2DeviceTemplateBlock) TableName() string      { return "device_template_blocks" }
+func (v2Group) TableName() string                    { return "groups" }
+func (v2DeviceGroup) TableName() string              { return "device_groups" }
+func (v2GroupVariable) TableName() string            { return "group_variables" }
+func (v2GroupTemplateAssignment) TableName() string  { return "group_template_assignments" }
+func (v2Prefix) TableName() string                   { return "prefixes" }
+func (v2GroupPrefix) TableName() string              { return "group_prefixes" }
+func (v2DeviceIP) TableName() string                 { return "device_ips" }
+func (v4DeviceFacts) TableName() string              { return "device_facts" }
+func (v4DeviceFactsHistory) TableName() string       { return "device_facts_histories" }
+func (v5TemplateRevision) TableName() string         { return "template_revisions" }
+func (v6ConfigArchive) TableName() string            { return "config_archives" }
+func (v6DeviceConfigArchive) TableName() string      { return "device_config_archives" }
+func (v7GlobalVariable) TableName() string           { return "global_variables" }
+func (v8VarDefinition) TableName() string            { return "var_definitions" }
+func (v11GroupRule) TableName() string               { return "group_rules" }
+
+// backfillPrevStatus — prev_status старых записей = статус предыдущего отчёта
+// того же устройства (пустая строка для первого).
+func backfillPrevStatus(tx *gorm.DB) error {
+	const lag = `SELECT id, COALESCE(LAG(status) OVER (PARTITION BY device_uuid ORDER BY created_at, id), '') AS prev
+		FROM device_status_histories`
+	switch tx.Dialector.Name() {
+	case "mysql":
+		return tx.Exec("UPDATE device_status_histories h JOIN (" + lag + ") p ON p.id = h.id " +
+			"SET h.prev_status = p.prev WHERE h.prev_status IS NULL").Error
+	case "postgres", "sqlite":
+		return tx.Exec("UPDATE device_status_histories SET prev_status = p.prev FROM (" + lag + ") AS p " +
+			"WHERE p.id = device_status_histories.id AND device_status_histories.prev_status IS NULL").Error
+	default:
+		return fmt.Errorf("unsupported dialect: %s", tx.Dialector.Name())
+	}
+}
+
 func MigrateTemplateUniqueIndex(db *gorm.DB) error {
 	if db == nil {
 		return nil
@@ -15,7 +495,12 @@ func MigrateTemplateUniqueIndex(db *gorm.DB) error {
 
 	switch dialect {
 	case "mysql":
-		_ = db.Exec("DROP INDEX `idx_tpl_name` ON `templates`").Error
+		if db.Migrator().HasIndex("templates", "idx_tpl_name") {
+			_ = db.Exec("DROP INDEX `idx_tpl_name` ON `templates`").Error
+		}
+		if db.Migrator().HasIndex("templates", "ux_templates_name_del") {
+			return nil
+		}
 		return db.Exec("CREATE UNIQUE INDEX `ux_templates_name_del` ON `templates` (`name`, `deleted_at`)").Error
 
 	case "postgres":
@@ -31,3 +516,16 @@ func MigrateTemplateUniqueIndex(db *gorm.DB) error {
 		return fmt.Errorf("unsupported dialect: %s", dialect)
 	}
 }
+
+func dropTemplateUniqueIndex(db *gorm.DB) error {
+	switch db.Dialector.Name() {
+	case "mysql":
+		return db.Exec("DROP INDEX `ux_templates_name_del` ON `templates`").Error
+	case "postgres":
+		return db.Exec(`DROP INDEX IF EXISTS ux_templates_name_null`).Error
+	case "sqlite":
+		return db.Exec(`DROP INDEX IF EXISTS ux_templates_name_del`).Error
+	default:
+		return fmt.Errorf("unsupported dialect: %s", db.Dialector.Name())
+	}
+}
@@ -0,0 +1,169 @@
+// internal/db/migrator.go
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration — одна пронумерованная миграция схемы.
+// Up/Down получают транзакцию; диалект доступен через tx.Dialector.Name().
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error // nil — миграция необратима
+}
+
+// SchemaMigration — запись о применённой миграции.
+type SchemaMigration struct {
+	Version   uint   `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:128"`
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string { return "schema_migrations" }
+
+// MigrationState — строка для `migrate status`.
+type MigrationState struct {
+	Version   uint       `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Unknown   bool       `json:"unknown,omitempty"` // есть в БД, но нет в коде
+}
+
+var ErrIrreversible = errors.New("migration is irreversible")
+
+// sorted — реестр по возрастанию версии (с проверкой уникальности).
+func sorted(ms []Migration) ([]Migration, error) {
+	out := append([]Migration(nil), ms...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	for i := 1; i < len(out); i++ {
+		if out[i].Version == out[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", out[i].Version)
+		}
+	}
+	return out, nil
+}
+
+func applied(db *gorm.DB) (map[uint]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("schema_migrations: %w", err)
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[uint]SchemaMigration, len(rows))
+	for _, r := range rows {
+		out[r.Version] = r
+	}
+	return out, nil
+}
+
+// PendingMigrations — ещё не применённые миграции по порядку.
+func PendingMigrations(db *gorm.DB) ([]Migration, error) {
+	all, err := sorted(Migrations)
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for _, m := range all {
+		if _, ok := done[m.Version]; !ok {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// MigrateUp применяет все ожидающие миграции по порядку; каждая — в своей транзакции.
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	done := make([]Migration, 0, len(pending))
+	for _, m := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown откатывает последние steps применённых миграций (в обратном порядке).
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	all, err := sorted(Migrations)
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	var rolled []Migration
+	for i := len(all) - 1; i >= 0 && len(rolled) < steps; i-- {
+		m := all[i]
+		if _, ok := done[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return rolled, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, ErrIrreversible)
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return rolled, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		rolled = append(rolled, m)
+	}
+	return rolled, nil
+}
+
+// MigrationStatus — состояние всех известных (и неизвестных коду) миграций.
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	all, err := sorted(Migrations)
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(db)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MigrationState, 0, len(all))
+	for _, m := range all {
+		st := MigrationState{Version: m.Version, Name: m.Name}
+		if r, ok := done[m.Version]; ok {
+			at := r.AppliedAt
+			st.Applied, st.AppliedAt = true, &at
+			delete(done, m.Version)
+		}
+		out = append(out, st)
+	}
+	for _, r := range done {
+		at := r.AppliedAt
+		out = append(out, MigrationState{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: &at, Unknown: true})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"wisp/internal/models"
 
 	"github.com/gorilla/mux"
 )
@@ -32,7 +33,17 @@ func (h *DeviceHTTP) assignToDeviceByGroup(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	rec, err := h.repo.AssignIPToDeviceByGroup(uint(gidU), uuid)
+	// family=ipv4 (по умолчанию) | ipv6; для ipv6 — mode=sequential|eui64
+	var rec *models.DeviceIP
+	switch fam := r.URL.Query().Get("family"); fam {
+	case "", "ipv4":
+		rec, err = h.repo.AssignIPToDeviceByGroup(uint(gidU), uuid)
+	case "ipv6":
+		rec, err = h.repo.AssignIPv6ToDeviceByGroup(uint(gidU), uuid, r.URL.Query().Get("mode"))
+	default:
+		http.Error(w, "family must be ipv4|ipv6", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -62,7 +73,8 @@ func (h *DeviceHTTP) listDeviceIPs(w http.ResponseWriter, r *http.Request) {
 		PrefixID   uint   `json:"prefix_id"`
 		Address    string `json:"address"`
 		PrefixCIDR string `json:"prefix_cidr"`
-		Netmask    string `json:"netmask"`
+		PrefixLen  int    `json:"prefix_len"`
+		Netmask    string `json:"netmask,omitempty"` // только IPv4
 		Gateway    string `json:"gateway"`
 	}
 
@@ -70,11 +82,15 @@ func (h *DeviceHTTP) listDeviceIPs(w http.ResponseWriter, r *http.Request) {
 	for _, r := range recs {
 		p, _ := h.repo.GetPrefix(r.PrefixID)
 		var cidr, nm, gw string
+		var plen int
 		if p != nil {
 			cidr = p.CIDR
 			if _, nw, e := net.ParseCIDR(p.CIDR); e == nil {
-				nm = net.IP(nw.Mask).String()
-				gw = firstUsableIPv4(nw)
+				plen, _ = nw.Mask.Size()
+				if nw.IP.To4() != nil {
+					nm = net.IP(nw.Mask).String()
+				}
+				gw = FirstUsableIP(nw)
 			}
 		}
 		result = append(result, out{
@@ -82,6 +98,7 @@ func (h *DeviceHTTP) listDeviceIPs(w http.ResponseWriter, r *http.Request) {
 			PrefixID:   r.PrefixID,
 			Address:    r.Address,
 			PrefixCIDR: cidr,
+			PrefixLen:  plen,
 			Netmask:    nm,
 			Gateway:    gw,
 		})
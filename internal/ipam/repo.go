@@ -3,6 +3,7 @@ package ipam
 import (
 	"errors"
 	"fmt"
+	"math/big"
 	"net"
 	"strings"
 	"wisp/internal/models"
@@ -24,7 +25,7 @@ func (r *Repo) CreateRootPrefix(cidr string, note string) (*models.Prefix, error
 	family := "ipv4"
 	if ip.To4() == nil {
 		family = "ipv6"
-	} // пока не обрабатываем v6 в калькуляторе
+	}
 	p := &models.Prefix{CIDR: nw.String(), ParentID: nil, Family: family, Note: note}
 	return p, r.db.Create(p).Error
 }
@@ -45,8 +46,8 @@ func (r *Repo) ListChildren(parentID uint) ([]models.Prefix, error) {
 	return out, err
 }
 
-// AllocateChild — выделить следующий свободный дочерний префикс заданной длины.
-// Реализация для IPv4. Для IPv6 вернёт ошибку.
+// AllocateChild — выделить следующий свободный дочерний префикс заданной длины
+// (IPv4 и IPv6, например /48 → /56 или /64).
 func (r *Repo) AllocateChild(parentID uint, newPrefixLen int, note string) (*models.Prefix, error) {
 	parent, err := r.GetPrefix(parentID)
 	if err != nil {
@@ -58,13 +59,8 @@ func (r *Repo) AllocateChild(parentID uint, newPrefixLen int, note string) (*mod
 		return nil, err
 	}
 
-	// IPv6 пока не поддерживаем в алгоритме деления
-	if parentNet.IP.To4() == nil {
-		return nil, errors.New("ipv6 division not implemented yet")
-	}
-
-	parentOnes, _ := parentNet.Mask.Size()
-	if newPrefixLen <= parentOnes || newPrefixLen > 32 {
+	parentOnes, bits := parentNet.Mask.Size()
+	if newPrefixLen <= parentOnes || newPrefixLen > bits {
 		return nil, fmt.Errorf("invalid new_prefix_len: %d", newPrefixLen)
 	}
 
@@ -74,6 +70,10 @@ func (r *Repo) AllocateChild(parentID uint, newPrefixLen int, note string) (*mod
 		return nil, err
 	}
 
+	if parentNet.IP.To4() == nil {
+		return r.allocateChild6(parentID, parentNet, existing, newPrefixLen, note)
+	}
+
 	// вычислим следующий свободный субпрефикс
 	size := 1 << uint(32-newPrefixLen)
 	start := ip4ToUint(parentNet.IP.To4())
@@ -109,6 +109,45 @@ func (r *Repo) AllocateChild(parentID uint, newPrefixLen int, note string) (*mod
 	return nil, errors.New("no free child prefix available")
 }
 
+// allocateChild6 — то же деление для IPv6: 128-битная арифметика через big.Int.
+func (r *Repo) allocateChild6(parentID uint, parentNet *net.IPNet, existing []models.Prefix, newPrefixLen int, note string) (*models.Prefix, error) {
+	parentOnes, _ := parentNet.Mask.Size()
+
+	occupied := map[string]bool{}
+	for _, c := range existing {
+		_, n, e := net.ParseCIDR(c.CIDR)
+		if e != nil || n.IP.To4() != nil {
+			continue
+		}
+		if ones, _ := n.Mask.Size(); ones == newPrefixLen {
+			occupied[n.IP.String()] = true
+		}
+	}
+
+	start := ip6ToInt(parentNet.IP)
+	size := new(big.Int).Lsh(big.NewInt(1), uint(128-newPrefixLen))
+	count := new(big.Int).Lsh(big.NewInt(1), uint(newPrefixLen-parentOnes))
+
+	netAddr := new(big.Int).Set(start)
+	for i := new(big.Int); i.Cmp(count) < 0; i.Add(i, big.NewInt(1)) {
+		ip := intToIP6(netAddr)
+		if !occupied[ip.String()] {
+			child := &models.Prefix{
+				CIDR:     fmt.Sprintf("%s/%d", ip.String(), newPrefixLen),
+				ParentID: &parentID,
+				Family:   "ipv6",
+				Note:     note,
+			}
+			if err := r.db.Create(child).Error; err != nil {
+				return nil, err
+			}
+			return child, nil
+		}
+		netAddr.Add(netAddr, size)
+	}
+	return nil, errors.New("no free child prefix available")
+}
+
 // AssignPrefixToGroup — выделяет следующий свободный дочерний префикс у parent и назначает группе.
 func (r *Repo) AssignPrefixToGroup(parentID uint, groupID uint, newPrefixLen int, note string) (*models.Prefix, error) {
 	child, err := r.AllocateChild(parentID, newPrefixLen, note)
@@ -142,16 +181,65 @@ func (r *Repo) GroupPrefixes(groupID uint) ([]models.Prefix, error) {
 	return out, nil
 }
 
-// FirstGroupPrefix — первый префикс группы, если есть (удобно для шаблонов).
+// FirstGroupPrefix — первый префикс группы, если есть (удобно для шаблонов);
+// у группы без префиксов — ближайшего предка.
 func (r *Repo) FirstGroupPrefix(groupID uint) (*models.Prefix, error) {
-	ps, err := r.GroupPrefixes(groupID)
+	return r.FirstGroupPrefixFamily(groupID, "")
+}
+
+// FirstGroupPrefixFamily — первый префикс группы указанного семейства ("ipv4" | "ipv6",
+// "" — любого); если у группы такого нет — ближайшего предка (models.Group.ParentID).
+func (r *Repo) FirstGroupPrefixFamily(groupID uint, family string) (*models.Prefix, error) {
+	lineage, err := r.groupLineage(groupID)
 	if err != nil {
 		return nil, err
 	}
-	if len(ps) == 0 {
-		return nil, gorm.ErrRecordNotFound
+	for _, gid := range lineage {
+		ps, err := r.GroupPrefixes(gid)
+		if err != nil {
+			return nil, err
+		}
+		for i := range ps {
+			if family == "" || prefixFamily(ps[i].CIDR) == family {
+				return &ps[i], nil
+			}
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// groupLineage — группа и её предки, от ближнего к корню (с защитой от циклов).
+func (r *Repo) groupLineage(groupID uint) ([]uint, error) {
+	out := []uint{groupID}
+	seen := map[uint]bool{groupID: true}
+	for cur := groupID; len(out) < models.MaxGroupDepth; {
+		var g models.Group
+		if err := r.db.Select("id", "parent_id").First(&g, cur).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			return nil, err
+		}
+		if g.ParentID == nil || seen[*g.ParentID] {
+			break
+		}
+		cur = *g.ParentID
+		seen[cur] = true
+		out = append(out, cur)
+	}
+	return out, nil
+}
+
+func prefixFamily(cidr string) string {
+	ip, _, err := net.ParseCIDR(cidr)
+	switch {
+	case err != nil:
+		return ""
+	case ip.To4() != nil:
+		return "ipv4"
+	default:
+		return "ipv6"
 	}
-	return &ps[0], nil
 }
 
 // Helpers IPv4
@@ -163,15 +251,52 @@ func uintToIP4(u uint32) net.IP {
 	return net.IPv4(byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
 }
 
-// AssignIPToDeviceByGroup — выбрать первый префикс группы и выдать след. свободный IP.
+// Helpers IPv6
+func ip6ToInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(ip.To16())
+}
+func intToIP6(n *big.Int) net.IP {
+	b := n.Bytes()
+	ip := make(net.IP, net.IPv6len)
+	copy(ip[net.IPv6len-len(b):], b)
+	return ip
+}
+
+// AssignIPToDeviceByGroup — выбрать первый IPv4-префикс группы и выдать след. свободный IP.
 func (r *Repo) AssignIPToDeviceByGroup(groupID uint, deviceUUID string) (*models.DeviceIP, error) {
-	pfx, err := r.FirstGroupPrefix(groupID)
+	pfx, err := r.FirstGroupPrefixFamily(groupID, "ipv4")
 	if err != nil {
 		return nil, err
 	}
 	return r.assignIPInPrefix(pfx, deviceUUID)
 }
 
+// Режимы выдачи IPv6-адреса хосту.
+const (
+	IPv6ModeSequential = "sequential" // ::2, ::3, … (::1 — шлюз)
+	IPv6ModeEUI64      = "eui64"      // интерфейсный идентификатор из MAC устройства (только /64)
+)
+
+// AssignIPv6ToDeviceByGroup — выдать адрес в первом IPv6-префиксе группы.
+func (r *Repo) AssignIPv6ToDeviceByGroup(groupID uint, deviceUUID, mode string) (*models.DeviceIP, error) {
+	pfx, err := r.FirstGroupPrefixFamily(groupID, "ipv6")
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case "", IPv6ModeSequential:
+		return r.assignIPInPrefix(pfx, deviceUUID)
+	case IPv6ModeEUI64:
+		var dev models.Device
+		if err := r.db.Select("mac").Where("uuid = ?", deviceUUID).First(&dev).Error; err != nil {
+			return nil, fmt.Errorf("device lookup: %w", err)
+		}
+		return r.assignEUI64InPrefix(pfx, deviceUUID, dev.MAC)
+	default:
+		return nil, fmt.Errorf("unknown ipv6 mode: %s", mode)
+	}
+}
+
 // DeviceIPs — список IP устройства.
 func (r *Repo) DeviceIPs(deviceUUID string) ([]models.DeviceIP, error) {
 	var out []models.DeviceIP
@@ -184,14 +309,14 @@ func (r *Repo) ReleaseDeviceIP(id uint) error {
 	return r.db.Delete(&models.DeviceIP{}, id).Error
 }
 
-// internal: выдача IP внутри конкретного префикса (IPv4).
+// internal: выдача IP внутри конкретного префикса.
 func (r *Repo) assignIPInPrefix(pfx *models.Prefix, deviceUUID string) (*models.DeviceIP, error) {
 	ip, nw, err := net.ParseCIDR(pfx.CIDR)
 	if err != nil {
 		return nil, err
 	}
 	if ip.To4() == nil {
-		return nil, errors.New("ipv6 allocation not implemented yet")
+		return r.assignIP6InPrefix(pfx, nw, deviceUUID)
 	}
 	ones, bits := nw.Mask.Size()
 	if bits != 32 {
@@ -235,3 +360,125 @@ func (r *Repo) assignIPInPrefix(pfx *models.Prefix, deviceUUID string) (*models.
 	}
 	return nil, errors.New("no free ip in prefix")
 }
+
+// internal: последовательная выдача IPv6 (network — зарезервирован, ::1 — шлюз, начинаем с ::2).
+func (r *Repo) assignIP6InPrefix(pfx *models.Prefix, nw *net.IPNet, deviceUUID string) (*models.DeviceIP, error) {
+	ones, _ := nw.Mask.Size()
+	if ones > 126 {
+		return nil, fmt.Errorf("prefix /%d too small for host allocation", ones)
+	}
+
+	var taken []models.DeviceIP
+	if err := r.db.Where("prefix_id = ?", pfx.ID).Find(&taken).Error; err != nil {
+		return nil, err
+	}
+	occ := map[string]bool{}
+	for _, t := range taken {
+		if ip := net.ParseIP(t.Address); ip != nil {
+			occ[ip.String()] = true
+		}
+	}
+
+	netU := ip6ToInt(nw.IP)
+	last := new(big.Int).Add(netU, new(big.Int).Lsh(big.NewInt(1), uint(128-ones)))
+	last.Sub(last, big.NewInt(1))
+	one := big.NewInt(1)
+	for u := new(big.Int).Add(netU, big.NewInt(2)); u.Cmp(last) <= 0; u.Add(u, one) {
+		addr := intToIP6(u).String()
+		if occ[addr] {
+			continue
+		}
+		rec := &models.DeviceIP{DeviceUUID: deviceUUID, PrefixID: pfx.ID, Address: addr}
+		if err := r.db.Create(rec).Error; err != nil {
+			return nil, err
+		}
+		return rec, nil
+	}
+	return nil, errors.New("no free ip in prefix")
+}
+
+// internal: адрес EUI-64 (RFC 4291, прил. A) — префикс /64 + MAC с инвертированным U/L-битом и ff:fe в середине.
+func (r *Repo) assignEUI64InPrefix(pfx *models.Prefix, deviceUUID, mac string) (*models.DeviceIP, error) {
+	_, nw, err := net.ParseCIDR(pfx.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	if ones, _ := nw.Mask.Size(); ones != 64 || nw.IP.To4() != nil {
+		return nil, errors.New("eui64 requires an ipv6 /64 prefix")
+	}
+	hw, err := net.ParseMAC(strings.TrimSpace(mac))
+	if err != nil || len(hw) != 6 {
+		return nil, fmt.Errorf("device has no valid mac address for eui64: %q", mac)
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, nw.IP.To16()[:8])
+	ip[8] = hw[0] ^ 0x02
+	ip[9], ip[10] = hw[1], hw[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = hw[3], hw[4], hw[5]
+	addr := ip.String()
+
+	var ex models.DeviceIP
+	if err := r.db.Where("address = ?", addr).First(&ex).Error; err == nil {
+		if ex.DeviceUUID == deviceUUID {
+			return &ex, nil
+		}
+		return nil, fmt.Errorf("eui64 address %s already assigned to %s", addr, ex.DeviceUUID)
+	}
+	rec := &models.DeviceIP{DeviceUUID: deviceUUID, PrefixID: pfx.ID, Address: addr}
+	if err := r.db.Create(rec).Error; err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// ── Чтение для билдера конфигураций ─────────────────────────
+
+// GetDeviceIP — первый IPv4-адрес устройства.
+func (r *Repo) GetDeviceIP(uuid string) (models.DeviceIP, bool, error) {
+	return r.firstDeviceIP(uuid, "ipv4")
+}
+
+// GetDeviceIPv6 — первый IPv6-адрес устройства.
+func (r *Repo) GetDeviceIPv6(uuid string) (models.DeviceIP, bool, error) {
+	return r.firstDeviceIP(uuid, "ipv6")
+}
+
+func (r *Repo) firstDeviceIP(uuid, family string) (models.DeviceIP, bool, error) {
+	recs, err := r.DeviceIPs(uuid)
+	if err != nil {
+		return models.DeviceIP{}, false, err
+	}
+	for _, rec := range recs {
+		ip := net.ParseIP(rec.Address)
+		if ip == nil {
+			continue
+		}
+		if (ip.To4() != nil) == (family == "ipv4") {
+			return rec, true, nil
+		}
+	}
+	return models.DeviceIP{}, false, nil
+}
+
+// GetPrefixByID — префикс по ID (ok=false, если не найден).
+func (r *Repo) GetPrefixByID(id uint) (models.Prefix, bool, error) {
+	p, err := r.GetPrefix(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Prefix{}, false, nil
+		}
+		return models.Prefix{}, false, err
+	}
+	return *p, true, nil
+}
+
+// FirstUsableIP — адрес шлюза (network + 1) для IPv4 и IPv6.
+func FirstUsableIP(nw *net.IPNet) string {
+	if nw.IP.To4() != nil {
+		return firstUsableIPv4(nw)
+	}
+	u := new(big.Int).Add(ip6ToInt(nw.IP), big.NewInt(1))
+	return intToIP6(u).String()
+}
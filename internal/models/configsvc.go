@@ -10,6 +10,9 @@ type Template struct {
 	Type     string `gorm:"default:'go'"` // "go" | "netjson"
 	Required bool   `gorm:"default:false"`
 	Default  bool   `gorm:"default:false"`
+	// Source — файл в controller.templates_dir (относительный путь), из которого
+	// шаблон загружен; пусто — шаблон ведётся через API. Файловые через API не меняются.
+	Source string `gorm:"size:255;index"`
 	// При необходимости: Backend, Tags JSON и т.п.
 }
 
@@ -20,6 +23,29 @@ type DeviceVariable struct {
 	Value      string
 }
 
+// GlobalVariable — переменная для всего парка (ниже group и device по приоритету).
+type GlobalVariable struct {
+	gorm.Model
+	VarKey string `gorm:"column:var_key;uniqueIndex;size:191"`
+	Value  string
+}
+
+// VarDefinition — пользовательское определение переменной (дополняет встроенный varschema.Catalog).
+type VarDefinition struct {
+	gorm.Model
+	VarKey      string `gorm:"column:var_key;uniqueIndex;size:64"`
+	Type        string `gorm:"size:16"`
+	Pattern     string `gorm:"size:255"`
+	Min         *int
+	Max         *int
+	Enum        string `gorm:"type:text"` // JSON-массив строк
+	Required    bool
+	Secret      bool   `gorm:"default:false"`
+	RequiredIf  string `gorm:"size:255"`
+	Description string `gorm:"type:text"`
+	Example     string `gorm:"size:255"`
+}
+
 type DeviceTemplateAssignment struct {
 	gorm.Model
 	DeviceUUID string `gorm:"index;size:36"`
@@ -34,16 +60,35 @@ type DeviceTemplateBlock struct {
 	TemplateID uint   `gorm:"index"`
 }
 
+// Group — группа устройств; ParentID строит дерево (регион → площадка → этаж):
+// переменные, назначения шаблонов и IPAM-префиксы наследуются вниз по дереву.
 type Group struct {
 	gorm.Model
-	Name string `gorm:"uniqueIndex"`
+	Name     string `gorm:"uniqueIndex"`
+	ParentID *uint  `gorm:"index"`
+	Priority int    `gorm:"default:0"` // больше — применяется позже и перекрывает группы с меньшим
 }
 
+// MaxGroupDepth — предел глубины дерева групп (и защита обхода от циклов).
+const MaxGroupDepth = 16
+
 type DeviceGroup struct {
 	gorm.Model
 	DeviceUUID string `gorm:"index"`
 	GroupID    uint   `gorm:"index"`
-	IsPrimary  bool   `gorm:"column:is_primary"` // раньше было Primary bool
+	IsPrimary  bool   `gorm:"column:is_primary"` // основная группа устройства (IPAM, перевес при равном Priority); не больше одной
+	RuleID     *uint  `gorm:"index"`             // членство вычислено правилом GroupRule; nil — назначено вручную
+}
+
+// GroupRule — правило динамического членства: устройство входит в группу,
+// если совпало хотя бы одно её правило (см. configsvc.Membership).
+type GroupRule struct {
+	gorm.Model
+	GroupID uint   `gorm:"index"`
+	Field   string `gorm:"size:16"`  // name | backend | mac_oui | status | fact
+	FactKey string `gorm:"size:128"` // для field=fact: путь через точку (board_name, release.version)
+	Op      string `gorm:"size:8"`   // eq | regex
+	Value   string `gorm:"size:255"`
 }
 
 type GroupVariable struct {
@@ -60,3 +105,16 @@ type GroupTemplateAssignment struct {
 	Enabled    bool `gorm:"default:true"`
 	Order      int  `gorm:"default:100;index"`
 }
+
+// TemplateRevision — снимок шаблона на момент сохранения (номер растёт с 1 в пределах шаблона).
+type TemplateRevision struct {
+	gorm.Model
+	TemplateID uint   `gorm:"uniqueIndex:ux_tplrev,priority:1"`
+	Revision   int    `gorm:"uniqueIndex:ux_tplrev,priority:2"`
+	Name       string `gorm:"size:191"`
+	Path       string
+	Body       string
+	Type       string `gorm:"size:16"`
+	Author     string `gorm:"size:128"`
+	Message    string `gorm:"type:text"`
+}
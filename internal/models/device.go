@@ -24,6 +24,43 @@ type DeviceStatusHistory struct {
 	gorm.Model
 	DeviceUUID string `gorm:"index;size:36"`
 	Status     string `gorm:"index;size:16"`
+	PrevStatus string `gorm:"size:16"` // статус устройства до этого отчёта
 	ConfigSHA  string `gorm:"size:64"`
 	Error      string `gorm:"type:text"`
 }
+
+// DeviceFacts — последние факты, присланные агентом (JSON-объект).
+type DeviceFacts struct {
+	gorm.Model
+	DeviceUUID string `gorm:"uniqueIndex;size:36"`
+	Data       string `gorm:"type:text"`
+}
+
+// DeviceFactsHistory — снимок фактов на момент изменения.
+type DeviceFactsHistory struct {
+	gorm.Model
+	DeviceUUID string `gorm:"index;size:36"`
+	Data       string `gorm:"type:text"`
+}
+
+// ConfigArchive — отданный устройству tar.gz, адресуется содержимым (sha256);
+// одинаковые архивы разных устройств хранятся один раз.
+type ConfigArchive struct {
+	SHA256    string `gorm:"primaryKey;size:64"`
+	Size      int64
+	Data      []byte
+	CreatedAt time.Time
+}
+
+// DeviceConfigArchive — какой архив и когда отдавался устройству (одна строка на пару device+sha).
+type DeviceConfigArchive struct {
+	ID            uint   `gorm:"primaryKey"`
+	DeviceUUID    string `gorm:"uniqueIndex:ux_dev_archive;size:36"`
+	SHA256        string `gorm:"uniqueIndex:ux_dev_archive;index;size:64"`
+	FirstServedAt time.Time
+	LastServedAt  time.Time `gorm:"index"`
+	ServeCount    int
+	// значения секретов устройства на момент отдачи (JSON-массив) — чтобы маскировать
+	// архив и после смены секретов; сам архив содержит их же открытым текстом
+	SecretValues string `gorm:"type:text"`
+}
@@ -10,13 +10,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net"
+	"io/fs"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"wisp/internal/configsvc/varschema"
+	"wisp/internal/logs"
 	"wisp/internal/models"
 
 	"github.com/google/uuid"
@@ -49,10 +51,6 @@ type DeviceFields struct {
 	UpdatedAt time.Time
 }
 
-type GlobalVarsProvider interface {
-	GlobalVars() map[string]string
-}
-
 // Store — контракт хранилища устройств.
 type Store interface {
 	UpsertByKey(key string, d DeviceFields) (DeviceFields, bool)
@@ -63,101 +61,35 @@ type Store interface {
 
 // ConfigBuilder — контракт сборщика конфигурации устройства.
 type ConfigBuilder interface {
-	// Возвращает набор файлов конфигурации: абсолютный путь в tar -> содержимое.
-	BuildConfig(d DeviceFields) (map[string]string, error)
-}
-
-type VarsProvider interface {
-	GetDeviceVars(uuid string) (map[string]string, error)
-	GetGroupIDs(uuid string) ([]uint, error)
-	GetGroupVars(ids []uint) (map[string]string, error)
-}
-
-type IPAMProvider interface {
-	GetDeviceIP(uuid string) (models.DeviceIP, bool, error) // верните хотя бы адрес и prefix cidr
-	GetPrefixByID(id uint) (models.Prefix, bool, error)
-}
-
-// TemplateBuildAdapter — обёртка, которая делает наш Builder совместимым с ConfigBuilder.
-type TemplateBuildAdapter struct{ B *Builder }
-
-func (a *TemplateBuildAdapter) BuildConfig(d DeviceFields) (map[string]string, error) {
-	// наш Builder рендерит по UUID, vars он соберёт сам через repo/ipam
-	return a.B.RenderFiles(d.UUID)
-}
-
-// Builder имеет ссылки на repo и ipam
-type Builder struct {
-	repo    VarsProvider
-	ipam    IPAMProvider
-	tpl     TemplateRenderer
-	tplrepo TemplateRepository
-	gvars   GlobalVarsProvider
-}
-
-type TemplateRepository interface {
-	ListRequiredTemplates() ([]models.Template, error)
-	ListDefaultTemplates() ([]models.Template, error)
-	ListGroupTemplates(groupIDs []uint) ([]models.GroupTemplateAssignment, error)
-	ListAssignments(uuid string) ([]models.DeviceTemplateAssignment, error)
-	ListDeviceTemplateBlocks(uuid string) (map[uint]struct{}, error)
-	GetTemplatesByIDs(ids []uint) (map[uint]models.Template, error)
+	// Возвращает набор файлов конфигурации: абсолютный путь в tar -> содержимое,
+	// и права файлов, отличные от 0644 (по тем же путям; nil — у всех 0644).
+	BuildConfig(d DeviceFields) (map[string]string, map[string]fs.FileMode, error)
 }
 
-func NewBuilder(repo VarsProvider, ip IPAMProvider, tpl TemplateRenderer, g GlobalVarsProvider) *Builder {
-	return &Builder{repo: repo, ipam: ip, tpl: tpl, gvars: g}
+// SecretSource — необязательное расширение ConfigBuilder: действующие значения
+// секретных переменных устройства, чтобы маскировать их в отладочном выводе.
+type SecretSource interface {
+	SecretValues(d DeviceFields) ([]string, error)
 }
 
-func (b *Builder) collectTemplates(uuid string) ([]models.Template, error) {
-	// 1) required
-	req, _ := b.tplrepo.ListRequiredTemplates()
-	// 2) group (с учётом блок-листа)
-	gids, _ := b.repo.GetGroupIDs(uuid)
-	gas, _ := b.tplrepo.ListGroupTemplates(gids)
-	blocks, _ := b.tplrepo.ListDeviceTemplateBlocks(uuid)
-	groupIDs := make([]uint, 0, len(gas))
-	for _, a := range gas {
-		if _, blocked := blocks[a.TemplateID]; blocked {
-			continue
-		}
-		groupIDs = append(groupIDs, a.TemplateID)
-	}
-	// 3) device
-	das, _ := b.tplrepo.ListAssignments(uuid)
-	// собираем полный список ID
-	ids := make([]uint, 0, len(req)+len(groupIDs)+len(das))
-	for _, t := range req {
-		ids = append(ids, t.ID)
-	}
-	ids = append(ids, groupIDs...)
-	for _, a := range das {
-		ids = append(ids, a.TemplateID)
-	}
-	// загружаем карты ID->Template
-	byID, _ := b.tplrepo.GetTemplatesByIDs(ids)
-	// финальная упорядоченная последовательность:
-	out := make([]models.Template, 0, len(ids))
-
-	// required: как есть, по id ASC (мы уже вытянули в порядке id)
-	out = append(out, req...)
-
-	// group: сортировка уже учтена в запросе по order asc, id asc
-	for _, a := range gas {
-		if _, blocked := blocks[a.TemplateID]; blocked {
-			continue
-		}
-		if t, ok := byID[a.TemplateID]; ok {
-			out = append(out, t)
-		}
+// MaskSecrets — files с вырезанными значениями секретов устройства (varschema.Masked).
+// Билдер без SecretSource — files как есть.
+func MaskSecrets(b ConfigBuilder, d DeviceFields, files map[string]string) (map[string]string, error) {
+	src, ok := b.(SecretSource)
+	if !ok {
+		return files, nil
 	}
-
-	// device: сортировка по order asc, id asc
-	for _, a := range das {
-		if t, ok := byID[a.TemplateID]; ok {
-			out = append(out, t)
-		}
+	values, err := src.SecretValues(d)
+	if err != nil {
+		return nil, err
 	}
-	return out, nil
+	return varschema.Redact(files, values), nil
+}
+
+// WantsSecrets — ?secrets=1|true: явный запрос вывода без маскирования.
+func WantsSecrets(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("secrets"))
+	return v
 }
 
 func (c *Controller) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
@@ -175,13 +107,13 @@ func (c *Controller) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	files, err := c.buildFiles(dev)
+	files, modes, err := c.buildFiles(dev)
 	if err != nil {
 		models.WriteProblem(w, http.StatusUnprocessableEntity, "Build failed", err.Error(), nil)
 		return
 	}
 
-	tgz := mustTarGz(files)
+	tgz := mustTarGz(files, modes)
 	sum := sha256.Sum256(tgz)
 	shaHex := hex.EncodeToString(sum[:])
 
@@ -191,9 +123,18 @@ func (c *Controller) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
 	}
 	sort.Strings(paths)
 
+	// sha — реального архива; превью — с замаскированными секретами, если не ?secrets=1
+	if !WantsSecrets(r) {
+		if files, err = MaskSecrets(c.builder, dev, files); err != nil {
+			models.WriteProblem(w, http.StatusInternalServerError, "Internal error", err.Error(), nil)
+			return
+		}
+	}
+
 	type fileInfo struct {
 		Path    string `json:"path"`
 		Size    int    `json:"size"`
+		Mode    string `json:"mode,omitempty"` // только отличные от 0644
 		Preview string `json:"preview"`
 	}
 	out := struct {
@@ -207,7 +148,11 @@ func (c *Controller) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
 		if len(prev) > 300 {
 			prev = prev[:300] + "...(truncated)"
 		}
-		out.Files = append(out.Files, fileInfo{Path: p, Size: len(body), Preview: prev})
+		fi := fileInfo{Path: p, Size: len(body), Preview: prev}
+		if m, ok := modes[p]; ok {
+			fi.Mode = fmt.Sprintf("%04o", m)
+		}
+		out.Files = append(out.Files, fi)
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -234,128 +179,6 @@ func (m *memStore) UpdateStatusDetail(id, st, sha, errMsg string, _ map[string]a
 	return nil
 }
 
-func (b *Builder) mergeVars(uuid string) (map[string]string, error) {
-	merged := map[string]string{}
-
-	// 0) global
-	if b.gvars != nil {
-		for k, v := range b.gvars.GlobalVars() {
-			merged[k] = v
-		}
-	}
-	// 1) group
-	gids, _ := b.repo.GetGroupIDs(uuid)
-	gvars, _ := b.repo.GetGroupVars(gids)
-	for k, v := range gvars {
-		merged[k] = v
-	}
-	// 2) device
-	dvars, _ := b.repo.GetDeviceVars(uuid)
-	for k, v := range dvars {
-		merged[k] = v
-	}
-
-	// 3) derived from IPAM if missing
-	if _, ok := merged["ipv4_address"]; !ok {
-		if dip, ok2, _ := b.ipam.GetDeviceIP(uuid); ok2 {
-			merged["ipv4_address"] = dip.Address
-
-			// подтянем префикс по PrefixID, чтобы получить маску и вычислить шлюз
-			if pfx, ok3, _ := b.ipam.GetPrefixByID(dip.PrefixID); ok3 {
-				ip, ipnet, _ := net.ParseCIDR(pfx.CIDR)
-				if ipnet != nil {
-					// netmask
-					if _, ok := merged["ipv4_netmask"]; !ok {
-						merged["ipv4_netmask"] = net.IP(ipnet.Mask).String()
-					}
-					// gateway: network + 1
-					if _, ok := merged["ipv4_gateway"]; !ok {
-						base := ip.Mask(ipnet.Mask).To4()
-						if base != nil {
-							gw := make(net.IP, len(base))
-							copy(gw, base)
-							gw[3] += 1
-							merged["ipv4_gateway"] = gw.String()
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// 4) final validation: ensure required are present and valid
-	missing := []string{}
-	for _, def := range varschema.Catalog {
-		if !def.Required {
-			continue
-		}
-		if v, ok := merged[def.Key]; !ok || v == "" {
-			missing = append(missing, def.Key)
-		} else {
-			// re-validate normalized form
-			if nv, err := varschema.ValidateOne(def.Key, v); err != nil {
-				return nil, fmt.Errorf("invalid %s: %v", def.Key, err)
-			} else {
-				merged[def.Key] = nv
-			}
-		}
-	}
-	if len(missing) > 0 {
-		return nil, fmt.Errorf("missing required vars: %v", missing)
-	}
-
-	return merged, nil
-}
-
-// BuildConfig satisfies ConfigBuilder
-func (b *Builder) BuildConfig(d DeviceFields) (map[string]string, error) {
-	// 1) собираем переменные (global → group → device → IPAM → validate)
-	vars, err := b.mergeVars(d.UUID)
-	if err != nil {
-		return nil, fmt.Errorf("config build error: %w", err)
-	}
-
-	// 2) предопределенные поля устройства — как в OpenWISP (в шаблонах должны быть доступны всегда)
-	vars["id"] = d.UUID
-	vars["key"] = d.Key
-	vars["name"] = d.Name
-	if d.MAC != "" {
-		vars["mac_address"] = d.MAC
-	}
-	if strings.TrimSpace(vars["hostname"]) == "" && d.Name != "" {
-		vars["hostname"] = d.Name
-	}
-
-	// 3) получаем итоговый список шаблонов по порядку (required → default → group → device)
-	tpls, err := b.collectTemplates(d.UUID)
-	if err != nil {
-		return nil, fmt.Errorf("collect templates: %w", err)
-	}
-
-	// 4) рендерим каждый шаблон и сливаем файлы (поздние перекрывают ранние по одному и тому же path)
-	files := make(map[string]string, 8)
-	for _, t := range tpls {
-		m, err := b.tpl.RenderOneFiles(t, vars)
-		if err != nil {
-			return nil, fmt.Errorf("template %d (%s): %w", t.ID, t.Name, err)
-		}
-		for p, c := range m {
-			files[p] = c
-		}
-	}
-
-	return files, nil
-}
-
-func (b *Builder) RenderFiles(uuid string) (map[string]string, error) {
-	vars, err := b.mergeVars(uuid)
-	if err != nil {
-		return nil, fmt.Errorf("config build error: %w", err)
-	}
-	// отрендерить все назначенные шаблоны с vars
-	return b.tpl.RenderAll(uuid, vars)
-}
-
 // ─────────────────────────── in-memory store (fallback) ───────────────────────────
 
 type memStore struct {
@@ -425,6 +248,39 @@ type Controller struct {
 	store        Store
 	sharedSecret string
 	builder      ConfigBuilder
+	archive      ArchiveSink
+	observer     DeviceObserver
+}
+
+// ArchiveSink — куда складывать отданные устройствам архивы (опционально).
+// secrets — значения секретов устройства (SecretSource), чтобы архив можно было
+// маскировать и после их смены.
+type ArchiveSink interface {
+	SaveArchive(uuid, sha string, tgz []byte, secrets []string) error
+}
+
+// WithArchive — сохранять каждый отданный tar.gz (см. internal/archive).
+func (c *Controller) WithArchive(a ArchiveSink) *Controller {
+	c.archive = a
+	return c
+}
+
+// DeviceObserver — уведомление после регистрации и отчёта о статусе/фактах
+// (например, пересчёт динамических групп). Вызывается синхронно.
+type DeviceObserver interface {
+	DeviceChanged(uuid string)
+}
+
+// WithObserver — подписать наблюдателя на изменения устройств.
+func (c *Controller) WithObserver(o DeviceObserver) *Controller {
+	c.observer = o
+	return c
+}
+
+func (c *Controller) notify(uuid string) {
+	if c.observer != nil && uuid != "" {
+		c.observer.DeviceChanged(uuid)
+	}
 }
 
 func NewController(sharedSecret string) *Controller {
@@ -487,6 +343,7 @@ func (c *Controller) handleRegister(w http.ResponseWriter, r *http.Request) {
 		Backend: backend,
 		MAC:     mac,
 	})
+	c.notify(dev.UUID)
 
 	// ВАЖНО: возвращаем key из стора (dev.Key), не keyIn
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -522,14 +379,14 @@ func (c *Controller) handleChecksum(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	files, err := c.buildFiles(dev)
+	files, modes, err := c.buildFiles(dev)
 	if err != nil {
 		models.WriteProblem(w, http.StatusUnprocessableEntity, "Build failed", err.Error(), map[string]string{
 			"uuid": dev.UUID,
 		})
 		return
 	}
-	tgz := mustTarGz(files)
+	tgz := mustTarGz(files, modes)
 	sum := sha256.Sum256(tgz)
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -553,14 +410,14 @@ func (c *Controller) handleDownloadConfig(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	files, err := c.buildFiles(dev)
+	files, modes, err := c.buildFiles(dev)
 	if err != nil {
 		models.WriteProblem(w, http.StatusUnprocessableEntity, "Build failed", err.Error(), map[string]string{
 			"uuid": dev.UUID,
 		})
 		return
 	}
-	tgz := mustTarGz(files)
+	tgz := mustTarGz(files, modes)
 	sum := sha256.Sum256(tgz)
 	shaHex := hex.EncodeToString(sum[:])
 	etag := `"` + shaHex + `"` // strong ETag
@@ -574,6 +431,19 @@ func (c *Controller) handleDownloadConfig(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// архив, который реально ушёл на устройство; ошибка архива не мешает отдаче
+	if c.archive != nil {
+		var secrets []string
+		if src, ok := c.builder.(SecretSource); ok {
+			if secrets, err = src.SecretValues(dev); err != nil {
+				logs.Logger.Warnf("archive %s for %s: secret values: %v", shaHex, dev.UUID, err)
+			}
+		}
+		if err := c.archive.SaveArchive(dev.UUID, shaHex, tgz, secrets); err != nil {
+			logs.Logger.Warnf("archive %s for %s: %v", shaHex, dev.UUID, err)
+		}
+	}
+
 	w.Header().Set("ETag", etag)
 	w.Header().Set("X-Openwisp-Archive-Sha256", shaHex)
 	w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
@@ -635,7 +505,23 @@ func (c *Controller) handleReportStatus(w http.ResponseWriter, r *http.Request)
 		} else {
 			errLog = r.Form.Get("error")
 		}
-		// facts для формы опционально можно добавить позже (не критично)
+		// facts: либо JSON-объект в поле "facts", либо отдельные поля "facts.<name>"
+		if v := strings.TrimSpace(r.Form.Get("facts")); v != "" {
+			if err := json.Unmarshal([]byte(v), &facts); err != nil {
+				models.WriteProblem(w, http.StatusBadRequest, "Bad facts", "facts must be a JSON object", nil)
+				return
+			}
+		}
+		for k, vs := range r.Form {
+			name, ok := strings.CutPrefix(k, "facts.")
+			if !ok || name == "" || len(vs) == 0 {
+				continue
+			}
+			if facts == nil {
+				facts = map[string]any{}
+			}
+			facts[name] = vs[0]
+		}
 	}
 
 	// Валидация устройства и ключа
@@ -663,6 +549,7 @@ func (c *Controller) handleReportStatus(w http.ResponseWriter, r *http.Request)
 	if err := c.store.UpdateStatusDetail(id, status, configSHA, errLog, facts); err != nil {
 		_ = c.store.UpdateStatus(id, status)
 	}
+	c.notify(id)
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
@@ -670,7 +557,7 @@ func (c *Controller) handleReportStatus(w http.ResponseWriter, r *http.Request)
 }
 
 // buildFiles — выбирает: использовать внешний билдер или минимальный fallback.
-func (c *Controller) buildFiles(d DeviceFields) (map[string]string, error) {
+func (c *Controller) buildFiles(d DeviceFields) (map[string]string, map[string]fs.FileMode, error) {
 	if c.builder != nil {
 		return c.builder.BuildConfig(d)
 	}
@@ -679,7 +566,7 @@ func (c *Controller) buildFiles(d DeviceFields) (map[string]string, error) {
 		"etc/config/system":                      "config system 'system'\n  option hostname '" + safe(d.Name) + "'\n  option timezone 'UTC'\n",
 		"etc/openwisp/device.meta":               fmt.Sprintf("uuid=%s\nmac=%s\nbackend=%s\n", d.UUID, d.MAC, d.Backend),
 		"etc/openwisp/managed_by_openwisp_go.md": "This device is managed by OpenWISP-Go controller.\n",
-	}, nil
+	}, nil, nil
 }
 
 func safe(s string) string {
@@ -687,7 +574,7 @@ func safe(s string) string {
 }
 
 // tarGzFromMap — собирает tar.gz из карты файлов.
-func deterministicTarGz(files map[string]string) ([]byte, error) {
+func deterministicTarGz(files map[string]string, modes map[string]fs.FileMode) ([]byte, error) {
 	// 1) Отсортировать ключи (пути)
 	paths := make([]string, 0, len(files))
 	for p := range files {
@@ -709,9 +596,13 @@ func deterministicTarGz(files map[string]string) ([]byte, error) {
 	// 3) Писать tar с фикс. полями
 	for _, name := range paths {
 		content := files[name]
+		mode, ok := modes[name]
+		if !ok {
+			mode = 0644
+		}
 		hdr := &tar.Header{
 			Name:    name,
-			Mode:    0644,
+			Mode:    tarMode(mode),
 			Size:    int64(len(content)),
 			ModTime: epoch,
 			Uid:     0,
@@ -741,8 +632,34 @@ func deterministicTarGz(files map[string]string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func mustTarGz(files map[string]string) []byte {
-	b, err := deterministicTarGz(files)
+// tarMode — права в заголовок tar (с setuid/setgid/sticky).
+func tarMode(m fs.FileMode) int64 {
+	v := int64(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		v |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		v |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		v |= 0o1000
+	}
+	return v
+}
+
+// TarGz — детерминированный tar.gz (тот же, что отдаётся устройству) и его sha256 в hex;
+// modes — права файлов, отличные от 0644 (см. ConfigBuilder).
+func TarGz(files map[string]string, modes map[string]fs.FileMode) ([]byte, string, error) {
+	b, err := deterministicTarGz(files, modes)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(b)
+	return b, hex.EncodeToString(sum[:]), nil
+}
+
+func mustTarGz(files map[string]string, modes map[string]fs.FileMode) []byte {
+	b, err := deterministicTarGz(files, modes)
 	if err != nil {
 		return []byte{}
 	}
@@ -802,7 +719,7 @@ func RegisterRoutesWithStore(root *mux.Router, sharedSecret string, store Store)
 		HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
 }
 
-func RegisterRoutesWithStoreAndBuilder(root *mux.Router, sharedSecret string, store Store, builder ConfigBuilder) {
+func RegisterRoutesWithStoreAndBuilder(root *mux.Router, sharedSecret string, store Store, builder ConfigBuilder) *Controller {
 	ctrl := NewControllerWithStoreAndBuilder(sharedSecret, store, builder)
 
 	root.HandleFunc("/controller", ctrl.handleRoot).Methods(http.MethodGet, http.MethodHead)
@@ -815,6 +732,7 @@ func RegisterRoutesWithStoreAndBuilder(root *mux.Router, sharedSecret string, st
 	sub.HandleFunc("/download-config/{uuid}/", ctrl.handleDownloadConfig).Methods(http.MethodGet)
 	sub.HandleFunc("/report-status/{uuid}/", ctrl.handleReportStatus).Methods(http.MethodPost)
 	sub.HandleFunc("/debug-config/{uuid}/", ctrl.handleDebugConfig).Methods(http.MethodGet)
+	return ctrl
 }
 
 func normalizeStatus(s string) string {
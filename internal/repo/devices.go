@@ -1,9 +1,11 @@
 package repo
 
 import (
+	"encoding/json"
 	"errors"
 	"strings"
 	"time"
+	"wisp/internal/archive"
 	"wisp/internal/models"
 	"wisp/internal/owctrl"
 
@@ -81,6 +83,10 @@ func (s *DeviceStore) UpsertByKey(key string, d owctrl.DeviceFields) (owctrl.Dev
 func (s *DeviceStore) UpdateStatusDetail(uuid, st, sha, errMsg string, facts map[string]any) error {
 	now := time.Now()
 	return s.db.Transaction(func(tx *gorm.DB) error {
+		var prev models.Device
+		if err := tx.Select("status").Where("uuid = ?", uuid).First(&prev).Error; err != nil {
+			return err
+		}
 		if err := tx.Model(&models.Device{}).
 			Where("uuid = ?", uuid).
 			Updates(map[string]any{
@@ -89,15 +95,96 @@ func (s *DeviceStore) UpdateStatusDetail(uuid, st, sha, errMsg string, facts map
 			}).Error; err != nil {
 			return err
 		}
-		h := models.DeviceStatusHistory{DeviceUUID: uuid, Status: st, ConfigSHA: sha, Error: errMsg}
+		h := models.DeviceStatusHistory{DeviceUUID: uuid, Status: st, PrevStatus: prev.Status, ConfigSHA: sha, Error: errMsg}
 		if err := tx.Create(&h).Error; err != nil {
 			return err
 		}
-		// facts можно сохранить в отдельную json-таблицу при необходимости
+		if facts != nil {
+			return saveFacts(tx, uuid, facts)
+		}
 		return nil
 	})
 }
 
+// ── Facts ─────────────────────────────────────────────────────
+
+// saveFacts — обновляет последние факты устройства; при изменении пишет снимок в историю.
+func saveFacts(tx *gorm.DB, uuid string, facts map[string]any) error {
+	b, err := json.Marshal(facts) // ключи map сериализуются отсортированными → стабильное сравнение
+	if err != nil {
+		return err
+	}
+	data := string(b)
+
+	var cur models.DeviceFacts
+	err = tx.Where("device_uuid = ?", uuid).First(&cur).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		cur = models.DeviceFacts{DeviceUUID: uuid, Data: data}
+		if err := tx.Create(&cur).Error; err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case cur.Data == data:
+		return nil
+	default:
+		cur.Data = data
+		if err := tx.Save(&cur).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Create(&models.DeviceFactsHistory{DeviceUUID: uuid, Data: data}).Error
+}
+
+// GetDeviceFacts — последние факты устройства (пустая map, если агент их не присылал).
+func (s *DeviceStore) GetDeviceFacts(uuid string) (map[string]any, error) {
+	var cur models.DeviceFacts
+	if err := s.db.Where("device_uuid = ?", uuid).First(&cur).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return map[string]any{}, nil
+		}
+		return nil, err
+	}
+	out := map[string]any{}
+	if err := json.Unmarshal([]byte(cur.Data), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FactsSnapshot — запись истории фактов.
+type FactsSnapshot struct {
+	At    time.Time      `json:"at"`
+	Facts map[string]any `json:"facts"`
+}
+
+// ListFactsHistory — история изменений фактов устройства, новые сверху.
+func (s *DeviceStore) ListFactsHistory(uuid string, f HistoryFilter) ([]FactsSnapshot, int64, error) {
+	q := s.db.Model(&models.DeviceFactsHistory{}).Where("device_uuid = ?", uuid)
+	if f.From != nil {
+		q = q.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("created_at <= ?", *f.To)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []models.DeviceFactsHistory
+	if err := q.Order("created_at DESC, id DESC").Limit(f.Limit).Offset(f.Offset).Find(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+	out := make([]FactsSnapshot, 0, len(rows))
+	for _, r := range rows {
+		m := map[string]any{}
+		_ = json.Unmarshal([]byte(r.Data), &m)
+		out = append(out, FactsSnapshot{At: r.CreatedAt, Facts: m})
+	}
+	return out, total, nil
+}
+
 func (s *DeviceStore) FindByUUID(id string) (owctrl.DeviceFields, bool) {
 	var m models.Device
 	if err := s.db.Where("uuid = ?", id).First(&m).Error; err != nil {
@@ -110,10 +197,208 @@ func (s *DeviceStore) FindByUUID(id string) (owctrl.DeviceFields, bool) {
 		Backend:   m.Backend,
 		MAC:       m.MAC,
 		Status:    m.Status,
+		LastSeen:  derefTime(m.LastSeen),
+		LastError: m.LastError,
+		LastSHA:   m.LastConfigSHA,
 		UpdatedAt: m.UpdatedAt,
 	}, true
 }
 
+func derefTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
 func (s *DeviceStore) UpdateStatus(id, status string) error {
 	return s.db.Model(&models.Device{}).Where("uuid = ?", id).Update("status", status).Error
 }
+
+// ── Inventory (admin API) ─────────────────────────────────────
+
+// DeviceFilter — параметры выборки устройств для /api/v1/devices.
+type DeviceFilter struct {
+	Status        string
+	Backend       string
+	Name          string // подстрока (LIKE)
+	MAC           string
+	LastSeenFrom  *time.Time
+	LastSeenTo    *time.Time
+	Limit, Offset int
+	Sort          string // поле; префикс "-" — по убыванию
+}
+
+// сортировка только по белому списку колонок
+var deviceSortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"status":     "status",
+	"backend":    "backend",
+	"mac":        "mac",
+	"last_seen":  "last_seen",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// ListDevices — выборка устройств по фильтру; возвращает страницу и общее число записей.
+func (s *DeviceStore) ListDevices(f DeviceFilter) ([]models.Device, int64, error) {
+	q := s.db.Model(&models.Device{})
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.Backend != "" {
+		q = q.Where("backend = ?", f.Backend)
+	}
+	if f.Name != "" {
+		q = q.Where("name LIKE ?", "%"+f.Name+"%")
+	}
+	if f.MAC != "" {
+		q = q.Where("LOWER(mac) = ?", strings.ToLower(f.MAC))
+	}
+	if f.LastSeenFrom != nil {
+		q = q.Where("last_seen >= ?", *f.LastSeenFrom)
+	}
+	if f.LastSeenTo != nil {
+		q = q.Where("last_seen <= ?", *f.LastSeenTo)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := "id ASC"
+	if f.Sort != "" {
+		field, dir := strings.TrimPrefix(f.Sort, "-"), "ASC"
+		if strings.HasPrefix(f.Sort, "-") {
+			dir = "DESC"
+		}
+		if col, ok := deviceSortColumns[field]; ok {
+			order = col + " " + dir + ", id ASC"
+		}
+	}
+
+	var out []models.Device
+	err := q.Order(order).Limit(f.Limit).Offset(f.Offset).Find(&out).Error
+	return out, total, err
+}
+
+// GetDevice — устройство по UUID (gorm.ErrRecordNotFound, если нет).
+func (s *DeviceStore) GetDevice(id string) (*models.Device, error) {
+	var m models.Device
+	if err := s.db.Where("uuid = ?", id).First(&m).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// UpdateDevice — меняет редактируемые админом поля (nil — не трогать).
+func (s *DeviceStore) UpdateDevice(id string, name, backend *string) (*models.Device, error) {
+	m, err := s.GetDevice(id)
+	if err != nil {
+		return nil, err
+	}
+	if name != nil {
+		m.Name = strings.TrimSpace(*name)
+	}
+	if backend != nil {
+		m.Backend = strings.TrimSpace(*backend)
+	}
+	if err := s.db.Save(m).Error; err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DeleteDevice — удаляет устройство вместе с его переменными, назначениями,
+// блокировками, членством в группах, выданными IP, фактами, историей статусов
+// и архивами конфигураций, чтобы при повторной регистрации с тем же UUID
+// устройство не подхватило чужие данные.
+func (s *DeviceStore) DeleteDevice(id string) error {
+	if _, err := s.GetDevice(id); err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range []any{
+			&models.DeviceVariable{},
+			&models.DeviceTemplateAssignment{},
+			&models.DeviceTemplateBlock{},
+			&models.DeviceGroup{},
+		} {
+			if err := tx.Where("device_uuid = ?", id).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		// физически: уникальные индексы (address у IP, device_uuid у фактов и
+		// uuid у самого устройства) иначе не дадут использовать их повторно
+		for _, m := range []any{
+			&models.DeviceIP{},
+			&models.DeviceFacts{},
+			&models.DeviceFactsHistory{},
+			&models.DeviceStatusHistory{},
+		} {
+			if err := tx.Unscoped().Where("device_uuid = ?", id).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		// архивы конфигураций: привязки устройства + ставшие ничьими tar.gz
+		var shas []string
+		if err := tx.Model(&models.DeviceConfigArchive{}).Where("device_uuid = ?", id).Pluck("sha256", &shas).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("device_uuid = ?", id).Delete(&models.DeviceConfigArchive{}).Error; err != nil {
+			return err
+		}
+		if err := archive.DeleteOrphans(tx, shas); err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("uuid = ?", id).Delete(&models.Device{}).Error
+	})
+}
+
+// ── Status history ────────────────────────────────────────────
+
+// HistoryFilter — выборка из device_status_histories.
+type HistoryFilter struct {
+	From, To      *time.Time
+	Status        string
+	Limit, Offset int
+}
+
+func (f HistoryFilter) apply(q *gorm.DB) *gorm.DB {
+	if f.From != nil {
+		q = q.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("created_at <= ?", *f.To)
+	}
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	return q
+}
+
+// ListStatusHistory — таймлайн отчётов одного устройства, новые сверху.
+func (s *DeviceStore) ListStatusHistory(uuid string, f HistoryFilter) ([]models.DeviceStatusHistory, int64, error) {
+	q := f.apply(s.db.Model(&models.DeviceStatusHistory{}).Where("device_uuid = ?", uuid))
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var out []models.DeviceStatusHistory
+	err := q.Order("created_at DESC, id DESC").Limit(f.Limit).Offset(f.Offset).Find(&out).Error
+	return out, total, err
+}
+
+// ListStatusChanges — лента смен статуса по всему парку (только записи, где статус изменился).
+func (s *DeviceStore) ListStatusChanges(f HistoryFilter) ([]models.DeviceStatusHistory, int64, error) {
+	q := f.apply(s.db.Model(&models.DeviceStatusHistory{}).Where("status <> prev_status"))
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var out []models.DeviceStatusHistory
+	err := q.Order("created_at DESC, id DESC").Limit(f.Limit).Offset(f.Offset).Find(&out).Error
+	return out, total, err
+}
@@ -0,0 +1,335 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"wisp/internal/models"
+	"wisp/internal/owctrl"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// DeviceHTTP — админский REST для инвентаря устройств.
+type DeviceHTTP struct {
+	store    *DeviceStore
+	observer owctrl.DeviceObserver
+}
+
+func NewDeviceHTTP(s *DeviceStore) *DeviceHTTP { return &DeviceHTTP{store: s} }
+
+// WithObserver — уведомлять об изменении устройства через API (пересчёт
+// динамических групп по name/backend), как owctrl при отчётах агента.
+func (h *DeviceHTTP) WithObserver(o owctrl.DeviceObserver) *DeviceHTTP {
+	h.observer = o
+	return h
+}
+
+func (h *DeviceHTTP) RegisterRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// GET /api/v1/devices?status=&backend=&name=&mac=&last_seen_from=&last_seen_to=&limit=&offset=&sort=-last_seen
+	api.HandleFunc("/devices", h.listDevices).Methods(http.MethodGet)
+	// лента смен статуса по всем устройствам: ?from=&to=&status=&limit=&offset=
+	api.HandleFunc("/status-changes", h.statusChanges).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{uuid}", h.getDevice).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{uuid}", h.updateDevice).Methods(http.MethodPut, http.MethodPatch)
+	api.HandleFunc("/devices/{uuid}", h.deleteDevice).Methods(http.MethodDelete)
+	// таймлайн отчётов агента: ?from=&to=&status=&limit=&offset=
+	api.HandleFunc("/devices/{uuid}/history", h.deviceHistory).Methods(http.MethodGet)
+
+	// факты агента: последние + история изменений (?from=&to=&limit=&offset=)
+	api.HandleFunc("/devices/{uuid}/facts", h.deviceFacts).Methods(http.MethodGet)
+	api.HandleFunc("/devices/{uuid}/facts/history", h.deviceFactsHistory).Methods(http.MethodGet)
+}
+
+// deviceOut — представление устройства в API (без device_key).
+type deviceOut struct {
+	UUID          string     `json:"uuid"`
+	Name          string     `json:"name"`
+	Backend       string     `json:"backend"`
+	MAC           string     `json:"mac"`
+	Status        string     `json:"status"`
+	LastSeen      *time.Time `json:"last_seen"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastConfigSHA string     `json:"last_config_sha,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func toDeviceOut(m models.Device) deviceOut {
+	return deviceOut{
+		UUID:          m.UUID,
+		Name:          m.Name,
+		Backend:       m.Backend,
+		MAC:           m.MAC,
+		Status:        m.Status,
+		LastSeen:      m.LastSeen,
+		LastError:     m.LastError,
+		LastConfigSHA: m.LastConfigSHA,
+		CreatedAt:     m.CreatedAt,
+		UpdatedAt:     m.UpdatedAt,
+	}
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePaging — limit/offset из query с дефолтами.
+func parsePaging(q url.Values) (limit, offset int, err error) {
+	get := func(k string) string { return strings.TrimSpace(q.Get(k)) }
+	limit = defaultPageLimit
+	if s := get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return 0, 0, errors.New("invalid limit")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+	if s := get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, errors.New("invalid offset")
+		}
+	}
+	return limit, offset, nil
+}
+
+// parseTimeParam — RFC3339 время из query (пусто — nil).
+func parseTimeParam(s, name string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errors.New(name + " must be RFC3339")
+	}
+	return &t, nil
+}
+
+func (h *DeviceHTTP) listDevices(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, offset, err := parsePaging(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(q.Get("last_seen_from"), "last_seen_from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q.Get("last_seen_to"), "last_seen_to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, total, err := h.store.ListDevices(DeviceFilter{
+		Status:       strings.TrimSpace(q.Get("status")),
+		Backend:      strings.TrimSpace(q.Get("backend")),
+		Name:         strings.TrimSpace(q.Get("name")),
+		MAC:          strings.TrimSpace(q.Get("mac")),
+		LastSeenFrom: from,
+		LastSeenTo:   to,
+		Limit:        limit,
+		Offset:       offset,
+		Sort:         strings.TrimSpace(q.Get("sort")),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items := make([]deviceOut, 0, len(list))
+	for _, m := range list {
+		items = append(items, toDeviceOut(m))
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (h *DeviceHTTP) getDevice(w http.ResponseWriter, r *http.Request) {
+	m, err := h.store.GetDevice(mux.Vars(r)["uuid"])
+	if err != nil {
+		writeStoreErr(w, err)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, toDeviceOut(*m))
+}
+
+func (h *DeviceHTTP) updateDevice(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Name    *string `json:"name"`
+		Backend *string `json:"backend"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if in.Name != nil && strings.TrimSpace(*in.Name) == "" {
+		http.Error(w, "name must not be empty", http.StatusBadRequest)
+		return
+	}
+	m, err := h.store.UpdateDevice(mux.Vars(r)["uuid"], in.Name, in.Backend)
+	if err != nil {
+		writeStoreErr(w, err)
+		return
+	}
+	if h.observer != nil {
+		h.observer.DeviceChanged(m.UUID)
+	}
+	models.WriteJSON(w, http.StatusOK, toDeviceOut(*m))
+}
+
+func (h *DeviceHTTP) deleteDevice(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.DeleteDevice(mux.Vars(r)["uuid"]); err != nil {
+		writeStoreErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// historyOut — одна запись таймлайна статусов.
+type historyOut struct {
+	ID         uint      `json:"id"`
+	DeviceUUID string    `json:"device_uuid"`
+	Status     string    `json:"status"`
+	PrevStatus string    `json:"prev_status,omitempty"`
+	ConfigSHA  string    `json:"config_sha,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+func parseHistoryFilter(q url.Values) (HistoryFilter, error) {
+	limit, offset, err := parsePaging(q)
+	if err != nil {
+		return HistoryFilter{}, err
+	}
+	from, err := parseTimeParam(q.Get("from"), "from")
+	if err != nil {
+		return HistoryFilter{}, err
+	}
+	to, err := parseTimeParam(q.Get("to"), "to")
+	if err != nil {
+		return HistoryFilter{}, err
+	}
+	return HistoryFilter{
+		From:   from,
+		To:     to,
+		Status: strings.TrimSpace(q.Get("status")),
+		Limit:  limit,
+		Offset: offset,
+	}, nil
+}
+
+func writeHistory(w http.ResponseWriter, list []models.DeviceStatusHistory, total int64, f HistoryFilter) {
+	items := make([]historyOut, 0, len(list))
+	for _, h := range list {
+		items = append(items, historyOut{
+			ID:         h.ID,
+			DeviceUUID: h.DeviceUUID,
+			Status:     h.Status,
+			PrevStatus: h.PrevStatus,
+			ConfigSHA:  h.ConfigSHA,
+			Error:      h.Error,
+			At:         h.CreatedAt,
+		})
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{
+		"items":  items,
+		"total":  total,
+		"limit":  f.Limit,
+		"offset": f.Offset,
+	})
+}
+
+func (h *DeviceHTTP) deviceHistory(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["uuid"]
+	f, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.store.GetDevice(id); err != nil {
+		writeStoreErr(w, err)
+		return
+	}
+	list, total, err := h.store.ListStatusHistory(id, f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeHistory(w, list, total, f)
+}
+
+func (h *DeviceHTTP) statusChanges(w http.ResponseWriter, r *http.Request) {
+	f, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, total, err := h.store.ListStatusChanges(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeHistory(w, list, total, f)
+}
+
+func (h *DeviceHTTP) deviceFacts(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["uuid"]
+	if _, err := h.store.GetDevice(id); err != nil {
+		writeStoreErr(w, err)
+		return
+	}
+	facts, err := h.store.GetDeviceFacts(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, facts)
+}
+
+func (h *DeviceHTTP) deviceFactsHistory(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["uuid"]
+	f, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.store.GetDevice(id); err != nil {
+		writeStoreErr(w, err)
+		return
+	}
+	list, total, err := h.store.ListFactsHistory(id, f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]any{
+		"items":  list,
+		"total":  total,
+		"limit":  f.Limit,
+		"offset": f.Offset,
+	})
+}
+
+func writeStoreErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,123 @@
+// internal/secrets/keyring.go
+//
+// Шифрование секретных переменных в БД: AES-256-GCM, ключ — sha256 от мастер-ключа
+// из конфига. Шифртекст хранится строкой "enc:v1:<kid>:<base64(nonce|ct)>", где kid —
+// отпечаток ключа; поэтому после смены мастер-ключа старые значения читаются,
+// пока старый ключ указан в previous_keys (и до перешифрования, см. Repo.RotateSecrets).
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const prefix = "enc:v1:"
+
+var (
+	ErrNoKey      = errors.New("secrets: master key is not configured")
+	ErrUnknownKey = errors.New("secrets: value is encrypted with an unknown key")
+	ErrCorrupt    = errors.New("secrets: malformed ciphertext")
+)
+
+type key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring — текущий ключ (шифрует) + предыдущие (только расшифровка).
+type Keyring struct {
+	primary key
+	byID    map[string]key
+}
+
+// NewKeyring — master обязателен; previous — прежние мастер-ключи для ротации.
+func NewKeyring(master string, previous []string) (*Keyring, error) {
+	if strings.TrimSpace(master) == "" {
+		return nil, ErrNoKey
+	}
+	p, err := newKey(master)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{primary: p, byID: map[string]key{p.id: p}}
+	for _, s := range previous {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		old, err := newKey(s)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := k.byID[old.id]; !dup {
+			k.byID[old.id] = old
+		}
+	}
+	return k, nil
+}
+
+func newKey(master string) (key, error) {
+	sum := sha256.Sum256([]byte(master))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return key{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return key{}, err
+	}
+	fp := sha256.Sum256(sum[:])
+	return key{id: hex.EncodeToString(fp[:4]), aead: aead}, nil
+}
+
+// KeyID — отпечаток текущего ключа.
+func (k *Keyring) KeyID() string { return k.primary.id }
+
+// IsEncrypted — строка в формате шифртекста Keyring.
+func IsEncrypted(s string) bool { return strings.HasPrefix(s, prefix) }
+
+// Encrypt шифрует текущим ключом.
+func (k *Keyring) Encrypt(plain string) (string, error) {
+	nonce := make([]byte, k.primary.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	ct := k.primary.aead.Seal(nonce, nonce, []byte(plain), []byte(k.primary.id))
+	return prefix + k.primary.id + ":" + base64.RawStdEncoding.EncodeToString(ct), nil
+}
+
+// Decrypt расшифровывает значение любым известным ключом; не-шифртекст возвращается как есть.
+func (k *Keyring) Decrypt(s string) (string, error) {
+	if !IsEncrypted(s) {
+		return s, nil
+	}
+	kid, b64, ok := strings.Cut(strings.TrimPrefix(s, prefix), ":")
+	if !ok {
+		return "", ErrCorrupt
+	}
+	kk, ok := k.byID[kid]
+	if !ok {
+		return "", fmt.Errorf("%w (kid %s)", ErrUnknownKey, kid)
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(b64)
+	if err != nil || len(raw) < kk.aead.NonceSize() {
+		return "", ErrCorrupt
+	}
+	n := kk.aead.NonceSize()
+	plain, err := kk.aead.Open(nil, raw[:n], raw[n:], []byte(kid))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return string(plain), nil
+}
+
+// Current — значение зашифровано текущим ключом (перешифровывать не нужно).
+func (k *Keyring) Current(s string) bool {
+	return strings.HasPrefix(s, prefix+k.primary.id+":")
+}
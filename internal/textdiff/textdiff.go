@@ -0,0 +1,271 @@
+// internal/textdiff/textdiff.go
+//
+// Построчный diff (алгоритм Майерса) и вывод в unified-формате, как у `diff -u`.
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind — тип строки в скрипте правки.
+type Kind byte
+
+const (
+	Equal  Kind = ' '
+	Delete Kind = '-'
+	Insert Kind = '+'
+)
+
+// Op — одна строка скрипта правки.
+type Op struct {
+	Kind Kind
+	Text string
+}
+
+// maxEditCost — предел числа правок, которые ищет одна бисекция. Дальше
+// подзадача считается заменой целиком: скрипт остаётся верным, хоть и не
+// кратчайшим, а время на огромных несхожих текстах — ограниченным.
+const maxEditCost = 4096
+
+// Lines — кратчайший скрипт правки a → b. Майерс в линейной памяти: задача
+// делится по точке, где встречаются прямой и обратный поиски, и половины
+// решаются рекурсивно (как в diff-match-patch).
+func Lines(a, b []string) []Op {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+	ops := make([]Op, 0, max(len(a), len(b)))
+	return lines(ops, a, b)
+}
+
+func lines(ops []Op, a, b []string) []Op {
+	// общие префикс и суффикс
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	for _, s := range a[:p] {
+		ops = append(ops, Op{Equal, s})
+	}
+	a, b = a[p:], b[p:]
+	q := 0
+	for q < len(a) && q < len(b) && a[len(a)-1-q] == b[len(b)-1-q] {
+		q++
+	}
+	suffix := a[len(a)-q:]
+	a, b = a[:len(a)-q], b[:len(b)-q]
+
+	switch {
+	case len(a) == 0:
+		ops = appendOps(ops, Insert, b)
+	case len(b) == 0:
+		ops = appendOps(ops, Delete, a)
+	default:
+		if i := index(b, a); i >= 0 { // a целиком внутри b
+			ops = appendOps(ops, Insert, b[:i])
+			ops = appendOps(ops, Equal, a)
+			ops = appendOps(ops, Insert, b[i+len(a):])
+		} else if i := index(a, b); i >= 0 {
+			ops = appendOps(ops, Delete, a[:i])
+			ops = appendOps(ops, Equal, b)
+			ops = appendOps(ops, Delete, a[i+len(b):])
+		} else if x, y, ok := bisect(a, b); ok {
+			ops = lines(ops, a[:x], b[:y])
+			ops = lines(ops, a[x:], b[y:])
+		} else {
+			ops = appendOps(ops, Delete, a)
+			ops = appendOps(ops, Insert, b)
+		}
+	}
+	return appendOps(ops, Equal, suffix)
+}
+
+func appendOps(ops []Op, k Kind, ss []string) []Op {
+	for _, s := range ss {
+		ops = append(ops, Op{k, s})
+	}
+	return ops
+}
+
+// index — позиция sub в s как непрерывной последовательности (-1, если нет).
+func index(s, sub []string) int {
+outer:
+	for i := 0; i+len(sub) <= len(s); i++ {
+		for j := range sub {
+			if s[i+j] != sub[j] {
+				continue outer
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+// bisect — точка (x, y) на кратчайшем пути правки, делящая задачу на две
+// меньших; ok=false — пути не нашлось в пределах maxEditCost.
+// Память — O(len(a)+len(b)).
+func bisect(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	maxD := min((n+m+1)/2, maxEditCost)
+	off := maxD
+	size := 2*maxD + 2
+	v1, v2 := make([]int, size), make([]int, size)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[off+1], v2[off+1] = 0, 0
+	delta := n - m
+	front := delta%2 != 0 // при нечётной дельте пути встречаются на прямом проходе
+	// границы диагоналей, ушедших за край
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for d := 0; d < maxD; d++ {
+		// прямой проход
+		for k1 := -d + k1start; k1 <= d-k1end; k1 += 2 {
+			i := off + k1
+			var x1 int
+			if k1 == -d || (k1 != d && v1[i-1] < v1[i+1]) {
+				x1 = v1[i+1]
+			} else {
+				x1 = v1[i-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[i] = x1
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				j := off + delta - k1
+				if j >= 0 && j < size && v2[j] != -1 && x1 >= n-v2[j] {
+					return x1, y1, true
+				}
+			}
+		}
+		// обратный проход (с концов)
+		for k2 := -d + k2start; k2 <= d-k2end; k2 += 2 {
+			i := off + k2
+			var x2 int
+			if k2 == -d || (k2 != d && v2[i-1] < v2[i+1]) {
+				x2 = v2[i+1]
+			} else {
+				x2 = v2[i-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[i] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				j := off + delta - k2
+				if j >= 0 && j < size && v1[j] != -1 {
+					x1 := v1[j]
+					y1 := off + x1 - j
+					if x1 >= n-x2 {
+						return x1, y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Context — число строк контекста вокруг изменений.
+const Context = 3
+
+// Unified — diff в unified-формате; пустая строка, если тексты совпадают.
+func Unified(aName, bName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := Lines(splitLines(a), splitLines(b)) // строки с "\n": последняя без него отличается от такой же с ним
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+
+	// позиции (1-based) начала каждой операции в a и b
+	aPos, bPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	aPos[0], bPos[0] = 1, 1
+	for i, op := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if op.Kind != Insert {
+			aPos[i+1]++
+		}
+		if op.Kind != Delete {
+			bPos[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == Equal {
+			i++
+			continue
+		}
+		// границы ханка: изменения, склеенные, если между ними ≤ 2*Context равных строк
+		start := max(i-Context, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].Kind != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].Kind == Equal {
+				run++
+			}
+			if run == len(ops) || run-end > 2*Context {
+				end = min(end+Context, len(ops))
+				break
+			}
+			end = run
+		}
+
+		aStart, bStart := aPos[start], bPos[start]
+		aLen, bLen := aPos[end]-aStart, bPos[end]-bStart
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, op := range ops[start:end] {
+			out.WriteByte(byte(op.Kind))
+			out.WriteString(op.Text)
+			if !strings.HasSuffix(op.Text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
+
+// splitLines — строки вместе с завершающим "\n" (у последней его может не быть).
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	ls := strings.SplitAfter(s, "\n")
+	if ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	return ls
+}
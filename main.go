@@ -3,6 +3,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"wisp/config"
 	"wisp/server"
@@ -10,6 +11,15 @@ import (
 
 func main() {
 	cfg := config.MustLoad()
+
+	// wisp migrate up | down [N] | status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app := &server.App{}
 	app.Initialize(cfg)
 	if err := app.Run(); err != nil {
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"wisp/config"
+	"wisp/internal/db"
+)
+
+// runMigrate — режим командной строки для версионных миграций схемы.
+func runMigrate(cfg *config.Config, args []string) error {
+	if cfg.Database.Driver == "" {
+		return errors.New("migrate: database.driver is not configured")
+	}
+	d, err := db.Open(cfg.Database.Driver, cfg.Database.DSN)
+	if err != nil {
+		return fmt.Errorf("db open failed: %w", err)
+	}
+
+	cmd := "status"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		done, err := db.MigrateUp(d)
+		for _, m := range done {
+			fmt.Printf("applied  %04d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("nothing to apply")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("migrate down: invalid steps %q", args[1])
+			}
+		}
+		done, err := db.MigrateDown(d, steps)
+		for _, m := range done {
+			fmt.Printf("reverted %04d %s\n", m.Version, m.Name)
+		}
+		return err
+
+	case "status":
+		states, err := db.MigrationStatus(d)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, st := range states {
+			status, at := "pending", ""
+			if st.Applied {
+				status = "applied"
+				at = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if st.Unknown {
+				status = "unknown"
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", st.Version, st.Name, status, at)
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("migrate: unknown command %q (want up|down [N]|status)", cmd)
+	}
+}
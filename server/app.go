@@ -11,15 +11,16 @@ import (
 	"time"
 
 	"wisp/config"
+	"wisp/internal/archive"
 	"wisp/internal/configsvc"
 	"wisp/internal/db"
 	"wisp/internal/health"
 	"wisp/internal/ipam"
 	"wisp/internal/logs"
 	"wisp/internal/middleware"
-	"wisp/internal/models"
 	"wisp/internal/owctrl"
 	"wisp/internal/repo"
+	"wisp/internal/secrets"
 
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
@@ -30,9 +31,10 @@ type App struct {
 	Router     *mux.Router
 	httpServer *http.Server
 
-	db     *gorm.DB
-	ctx    context.Context
-	cancel context.CancelFunc
+	db       *gorm.DB
+	tplFiles *configsvc.FileTemplates // nil — controller.templates_dir не задан
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
 func (a *App) Initialize(cfg *config.Config) {
@@ -52,46 +54,24 @@ func (a *App) Initialize(cfg *config.Config) {
 			log.Fatalf("db open failed: %v", err)
 		}
 		a.db = d
-		// базовые миграции устройств
-		if err := a.db.AutoMigrate(&models.Device{}); err != nil {
-			log.Fatalf("db migrate failed: %v", err)
-		}
-	}
 
-	// миграции доменных сущностей (если БД подключена)
-	// ---- DB migrations (only if DB is connected) ----
-	if a.db != nil {
-		// 1) One-off rename of reserved columns (MySQL/MariaDB safe)
-		if err := db.MigrateReservedColumns(a.db); err != nil {
-			logs.Logger.Warnf("reserved columns migration: %v", err)
+		// схема ведётся версионными миграциями (см. `wisp migrate status`)
+		if a.cfg.Database.AutoMigrate {
+			done, err := db.MigrateUp(a.db)
+			for _, m := range done {
+				logs.Logger.Infof("migration %d (%s) applied", m.Version, m.Name)
+			}
+			if err != nil {
+				log.Fatalf("db migrate failed: %v", err)
+			}
 		}
-
-		// 2) AutoMigrate all domain models
-		if err := a.db.AutoMigrate(
-			// devices
-			&models.Device{},
-
-			// configsvc (templates/vars/groups)
-			&models.Template{},
-			&models.DeviceVariable{},
-			&models.DeviceTemplateAssignment{},
-			&models.Group{},
-			&models.DeviceGroup{},
-			&models.GroupVariable{},
-			&models.GroupTemplateAssignment{},
-			&models.DeviceTemplateBlock{},
-			&models.DeviceStatusHistory{},
-
-			// ipam (prefixes & IPs)
-			&models.Prefix{},
-			&models.GroupPrefix{},
-			&models.DeviceIP{},
-		); err != nil {
-			logs.Logger.Errorf("automigrate: %v", err)
+		pending, err := db.PendingMigrations(a.db)
+		if err != nil {
+			log.Fatalf("db migrations check failed: %v", err)
 		}
-
-		if err := db.MigrateTemplateUniqueIndex(a.db); err != nil {
-			logs.Logger.Errorf("templates unique index migration: %v", err)
+		if len(pending) > 0 {
+			log.Fatalf("db has %d pending migration(s), first: %d (%s); run `migrate up` or set database.auto_migrate",
+				len(pending), pending[0].Version, pending[0].Name)
 		}
 	}
 
@@ -111,21 +91,79 @@ func (a *App) Initialize(cfg *config.Config) {
 	}
 
 	cfgRepoInst := configsvc.NewRepo(a.db)
+	// пользовательские определения переменных дополняют встроенный каталог
+	if err := cfgRepoInst.ReloadVarSchema(); err != nil {
+		log.Fatalf("var schema load failed: %v", err)
+	}
+	if mk := a.cfg.Secrets.MasterKey; mk != "" {
+		keys, err := secrets.NewKeyring(mk, a.cfg.Secrets.PreviousKeys)
+		if err != nil {
+			log.Fatalf("secrets keyring: %v", err)
+		}
+		cfgRepoInst.WithSecrets(keys)
+	} else {
+		logs.Logger.Warn("secrets.master_key is not set: secret variables are stored unencrypted")
+	}
 	ipamRepo := ipam.NewRepo(a.db)
 
 	// HTTP ручки (как было)
 	configsvc.NewHTTP(cfgRepoInst).RegisterRoutes(a.Router)
 	configsvc.NewGroupHTTP(cfgRepoInst).RegisterRoutes(a.Router)
+	configsvc.NewVarDefsHTTP(cfgRepoInst).RegisterRoutes(a.Router)
+	configsvc.NewSecretsHTTP(cfgRepoInst).RegisterRoutes(a.Router)
+
+	// Файловые шаблоны: первичная синхронизация здесь, слежение за каталогом — в Run
+	if dir := a.cfg.Controller.TemplatesDir; dir != "" {
+		if a.db == nil {
+			logs.Logger.Infof("controller.templates_dir %s ignored: no database", dir)
+		} else if st, err := os.Stat(dir); err == nil && st.IsDir() {
+			a.tplFiles = configsvc.NewFileTemplates(cfgRepoInst, dir)
+			a.tplFiles.SyncAndLog()
+			configsvc.NewFileTemplatesHTTP(a.tplFiles).RegisterRoutes(a.Router)
+		} else {
+			logs.Logger.Infof("controller.templates_dir %s not found: file templates disabled", dir)
+		}
+	}
 	ipam.NewHTTP(ipamRepo).RegisterRoutes(a.Router)
 	ipam.NewDeviceHTTP(ipamRepo).RegisterRoutes(a.Router)
 
 	// Рендерер + билдер
-	tplRenderer := configsvc.NewTemplateRenderer(cfgRepoInst)
-	cfgBuilder := configsvc.NewBuilderWithIPAMAndRenderer(cfgRepoInst, ipamRepo, tplRenderer)
+	tplRenderer := configsvc.NewTemplateRenderer()
+	ds := repo.NewDeviceStore(a.db)
+	globals := configsvc.NewGlobals(cfgRepoInst, a.cfg.GlobalVars)
+	configsvc.NewGlobalsHTTP(globals).RegisterRoutes(a.Router)
+	cfgBuilder := configsvc.NewBuilderWithIPAMAndRenderer(cfgRepoInst, ipamRepo, tplRenderer).
+		WithGlobals(globals).
+		WithFacts(ds).
+		WithStrictVars(a.cfg.Controller.StrictVars)
 
 	// Контроллер
-	ds := repo.NewDeviceStore(a.db)
-	owctrl.RegisterRoutesWithStoreAndBuilder(a.Router, a.cfg.OpenWISP.SharedSecret, ds, cfgBuilder)
+	devHTTP := repo.NewDeviceHTTP(ds)
+	devHTTP.RegisterRoutes(a.Router)
+	configsvc.NewPreviewHTTP(ds, cfgBuilder).RegisterRoutes(a.Router)
+	configsvc.NewVarsReportHTTP(ds, cfgBuilder).RegisterRoutes(a.Router)
+	ctrl := owctrl.RegisterRoutesWithStoreAndBuilder(a.Router, a.cfg.OpenWISP.SharedSecret, ds, cfgBuilder)
+
+	// Динамические группы: пересчёт при регистрации и отчётах агента
+	membership := configsvc.NewMembership(cfgRepoInst, ds, ds)
+	if a.db != nil {
+		ctrl.WithObserver(membership)
+		devHTTP.WithObserver(membership)
+	}
+	configsvc.NewGroupRulesHTTP(cfgRepoInst, membership).RegisterRoutes(a.Router)
+	configsvc.NewBundleHTTP(cfgRepoInst, membership).RegisterRoutes(a.Router)
+	configsvc.NewBulkHTTP(configsvc.NewBulk(cfgRepoInst, ds)).RegisterRoutes(a.Router)
+
+	// Архив отданных конфигураций (только с БД)
+	if a.db != nil {
+		arch := archive.NewStore(a.db, archive.Retention{
+			KeepPerDevice: a.cfg.Archive.KeepPerDevice,
+			MaxAge:        a.cfg.Archive.MaxAge,
+		})
+		ctrl.WithArchive(arch)
+		archive.NewHTTP(arch, ds, cfgBuilder).RegisterRoutes(a.Router)
+		archive.NewDiffHTTP(arch, ds, cfgBuilder).RegisterRoutes(a.Router)
+	}
 
 	a.Router.Walk(func(rt *mux.Route, r *mux.Router, ancestors []*mux.Route) error {
 		path, _ := rt.GetPathTemplate()
@@ -146,6 +184,14 @@ func (a *App) Run() error {
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() { <-sigs; a.cancel() }()
 
+	if a.tplFiles != nil {
+		go func() {
+			if err := a.tplFiles.Watch(a.ctx); err != nil {
+				logs.Logger.Errorf("templates_dir watch: %v (hot reload disabled)", err)
+			}
+		}()
+	}
+
 	a.httpServer = &http.Server{
 		Addr:         bind,
 		Handler:      a.Router,